@@ -3,12 +3,14 @@ package analytics
 import (
 	"testing"
 	"time"
+
+	"app/internal/money"
 )
 
 type Transaction struct {
 	OrderID     string
 	CustomerID  string
-	Amount      float64
+	Amount      money.Amount
 	Status      string
 	CreatedAt   time.Time
 }
@@ -32,7 +34,7 @@ func TestFraudDetection(t *testing.T) {
 			transaction: Transaction{
 				OrderID:    "order-123",
 				CustomerID: "customer-456",
-				Amount:    1000.00,
+				Amount:    100000,
 				Status:    "pending",
 			},
 			wantScore:   0.8,
@@ -44,7 +46,7 @@ func TestFraudDetection(t *testing.T) {
 			transaction: Transaction{
 				OrderID:    "order-124",
 				CustomerID: "customer-789",
-				Amount:    50.00,
+				Amount:    5000,
 				Status:    "pending",
 			},
 			wantScore:   0.2,
@@ -65,7 +67,7 @@ func TestMonthlyReport(t *testing.T) {
 		name     string
 		month    time.Time
 		wantData struct {
-			TotalSales   float64
+			TotalSales   money.Amount
 			TotalOrders  int
 			TotalDisputes int
 		}
@@ -75,11 +77,11 @@ func TestMonthlyReport(t *testing.T) {
 			name:  "valid monthly report",
 			month: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
 			wantData: struct {
-				TotalSales   float64
+				TotalSales   money.Amount
 				TotalOrders  int
 				TotalDisputes int
 			}{
-				TotalSales:   10000.00,
+				TotalSales:   1000000,
 				TotalOrders:  100,
 				TotalDisputes: 5,
 			},
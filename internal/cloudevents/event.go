@@ -0,0 +1,209 @@
+// Package cloudevents builds and parses the CloudEvents 1.0 envelopes every module publishes on NATS.
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// SpecVersion is the CloudEvents specification version produced and accepted
+	SpecVersion = "1.0"
+
+	// StructuredContentType marks a message whose body is the whole JSON envelope
+	StructuredContentType = "application/cloudevents+json"
+	// JSONContentType is the content type of event data
+	JSONContentType = "application/json"
+
+	// headerPrefix prefixes every attribute carried as a NATS header in binary mode
+	headerPrefix      = "ce-"
+	contentTypeHeader = "Content-Type"
+)
+
+// Mode selects how an event is laid out on a NATS message
+type Mode string
+
+const (
+	// Structured puts the whole envelope, data included, in the message body
+	Structured Mode = "structured"
+	// Binary puts the attributes in ce- headers and only the data in the body
+	Binary Mode = "binary"
+)
+
+// Event is a CloudEvents 1.0 envelope with JSON data.
+// Sequence and SchemaVersion are extension attributes: the position of the event within its
+// aggregate (the subject) and the version of the data's schema for its type.
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Sequence        int64           `json:"sequence,omitempty"`
+	SchemaVersion   int             `json:"schemaversion,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// New creates an event with JSON data
+func New(id, source, eventType string, data []byte) Event {
+	return Event{
+		SpecVersion:     SpecVersion,
+		ID:              id,
+		Source:          source,
+		Type:            eventType,
+		Time:            time.Now().UTC(),
+		DataContentType: JSONContentType,
+		Data:            data,
+	}
+}
+
+// Validate checks the attributes required by the specification
+func (e Event) Validate() error {
+	var missing []string
+	if e.ID == "" {
+		missing = append(missing, "id")
+	}
+	if e.Source == "" {
+		missing = append(missing, "source")
+	}
+	if e.Type == "" {
+		missing = append(missing, "type")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("cloudevent missing required attributes: %s", strings.Join(missing, ", "))
+	}
+	if e.SpecVersion != SpecVersion {
+		return fmt.Errorf("unsupported cloudevents specversion %q", e.SpecVersion)
+	}
+	return nil
+}
+
+// Expect validates the event and checks it comes from source with one of the given types
+func (e Event) Expect(source string, types ...string) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	if e.Source != source {
+		return fmt.Errorf("unexpected cloudevent source %q, want %q", e.Source, source)
+	}
+	for _, eventType := range types {
+		if e.Type == eventType {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected cloudevent type %q from %s", e.Type, source)
+}
+
+// NewMsg encodes the event onto a NATS message in the given mode. The event ID is also set
+// as the Nats-Msg-Id header so JetStream can de-duplicate redeliveries.
+func NewMsg(subject string, e Event, mode Mode) (*nats.Msg, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Header.Set(nats.MsgIdHdr, e.ID)
+
+	switch mode {
+	case Structured:
+		body, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		msg.Header.Set(contentTypeHeader, StructuredContentType)
+		msg.Data = body
+
+	case Binary:
+		msg.Header.Set(headerPrefix+"specversion", e.SpecVersion)
+		msg.Header.Set(headerPrefix+"id", e.ID)
+		msg.Header.Set(headerPrefix+"source", e.Source)
+		msg.Header.Set(headerPrefix+"type", e.Type)
+		msg.Header.Set(headerPrefix+"time", e.Time.Format(time.RFC3339Nano))
+		if e.Subject != "" {
+			msg.Header.Set(headerPrefix+"subject", e.Subject)
+		}
+		if e.Sequence != 0 {
+			msg.Header.Set(headerPrefix+"sequence", strconv.FormatInt(e.Sequence, 10))
+		}
+		if e.SchemaVersion != 0 {
+			msg.Header.Set(headerPrefix+"schemaversion", strconv.Itoa(e.SchemaVersion))
+		}
+		if e.DataContentType != "" {
+			msg.Header.Set(contentTypeHeader, e.DataContentType)
+		}
+		msg.Data = e.Data
+
+	default:
+		return nil, fmt.Errorf("unknown cloudevents mode %q", mode)
+	}
+
+	return msg, nil
+}
+
+// FromMsg decodes an event from a NATS message in either mode and validates it
+func FromMsg(msg *nats.Msg) (Event, error) {
+	var e Event
+	if msg.Header.Get(headerPrefix+"specversion") != "" {
+		decoded, err := fromBinary(msg)
+		if err != nil {
+			return Event{}, err
+		}
+		e = decoded
+	} else {
+		if len(msg.Data) == 0 {
+			return Event{}, errors.New("message is not a cloudevent")
+		}
+		if err := json.Unmarshal(msg.Data, &e); err != nil {
+			return Event{}, fmt.Errorf("invalid structured cloudevent: %v", err)
+		}
+	}
+
+	if err := e.Validate(); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+func fromBinary(msg *nats.Msg) (Event, error) {
+	e := Event{
+		SpecVersion:     msg.Header.Get(headerPrefix + "specversion"),
+		ID:              msg.Header.Get(headerPrefix + "id"),
+		Source:          msg.Header.Get(headerPrefix + "source"),
+		Type:            msg.Header.Get(headerPrefix + "type"),
+		Subject:         msg.Header.Get(headerPrefix + "subject"),
+		DataContentType: msg.Header.Get(contentTypeHeader),
+		Data:            msg.Data,
+	}
+
+	if value := msg.Header.Get(headerPrefix + "time"); value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return Event{}, fmt.Errorf("invalid ce-time header: %v", err)
+		}
+		e.Time = t
+	}
+	if value := msg.Header.Get(headerPrefix + "sequence"); value != "" {
+		sequence, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return Event{}, fmt.Errorf("invalid ce-sequence header: %v", err)
+		}
+		e.Sequence = sequence
+	}
+	if value := msg.Header.Get(headerPrefix + "schemaversion"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return Event{}, fmt.Errorf("invalid ce-schemaversion header: %v", err)
+		}
+		e.SchemaVersion = version
+	}
+
+	return e, nil
+}
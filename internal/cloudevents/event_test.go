@@ -0,0 +1,61 @@
+package cloudevents
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent() Event {
+	e := New("event-1", "/customers", "customers.CustomerCreated", []byte(`{"name":"Test User"}`))
+	e.Subject = "customer-1"
+	e.Sequence = 3
+	e.SchemaVersion = 1
+	e.Time = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return e
+}
+
+func TestStructuredRoundTrip(t *testing.T) {
+	e := testEvent()
+
+	msg, err := NewMsg("customers.CustomerCreated", e, Structured)
+	require.NoError(t, err)
+	assert.Equal(t, StructuredContentType, msg.Header.Get("Content-Type"))
+	assert.Equal(t, "event-1", msg.Header.Get(nats.MsgIdHdr))
+
+	decoded, err := FromMsg(msg)
+	require.NoError(t, err)
+	assert.Equal(t, e.ID, decoded.ID)
+	assert.Equal(t, e.Sequence, decoded.Sequence)
+	assert.True(t, e.Time.Equal(decoded.Time))
+	assert.JSONEq(t, string(e.Data), string(decoded.Data))
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	e := testEvent()
+
+	msg, err := NewMsg("customers.CustomerCreated", e, Binary)
+	require.NoError(t, err)
+	assert.Equal(t, "customers.CustomerCreated", msg.Header.Get("ce-type"))
+	assert.Equal(t, []byte(e.Data), msg.Data)
+
+	decoded, err := FromMsg(msg)
+	require.NoError(t, err)
+	assert.Equal(t, e, decoded)
+}
+
+func TestFromMsgRejectsBareJSON(t *testing.T) {
+	_, err := FromMsg(&nats.Msg{Data: []byte(`{"product_id":"PROD123"}`)})
+	assert.Error(t, err)
+}
+
+func TestExpect(t *testing.T) {
+	e := testEvent()
+
+	assert.NoError(t, e.Expect("/customers", "customers.CustomerCreated", "customers.CustomerUpdated"))
+	assert.Error(t, e.Expect("/inventory", "customers.CustomerCreated"))
+	assert.Error(t, e.Expect("/customers", "customers.CustomerDeleted"))
+}
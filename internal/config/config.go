@@ -0,0 +1,262 @@
+// Package config loads the application configuration. Defaults are overridden by an
+// optional YAML file, named by APP_CONFIG, which is in turn overridden by environment
+// variables, so a deployment can keep a shared file and patch single values.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable holding the path of the optional YAML file
+const FileEnv = "APP_CONFIG"
+
+// Config is the configuration of the whole modulith
+type Config struct {
+	HTTP     HTTP     `yaml:"http"`
+	Mongo    Mongo    `yaml:"mongo"`
+	NATS     NATS     `yaml:"nats"`
+	Temporal Temporal `yaml:"temporal"`
+	Relay    Relay    `yaml:"relay"`
+}
+
+// HTTP configures the API server
+type HTTP struct {
+	Addr string `yaml:"addr"` // HTTP_ADDR
+	// ShutdownTimeout bounds the whole graceful drain
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // HTTP_SHUTDOWN_TIMEOUT
+}
+
+// Mongo configures the shared client and the database of every bounded context
+type Mongo struct {
+	URI       string    `yaml:"uri"` // MONGO_URI
+	Databases Databases `yaml:"databases"`
+}
+
+// Databases names the database owned by each bounded context
+type Databases struct {
+	Customers string `yaml:"customers"` // MONGO_CUSTOMERS_DB
+	Inventory string `yaml:"inventory"` // MONGO_INVENTORY_DB
+	Ordering  string `yaml:"ordering"`  // MONGO_ORDERING_DB
+}
+
+// NATS configures the shared connection
+type NATS struct {
+	URL string `yaml:"url"` // NATS_URL
+}
+
+// Temporal configures the workflow client and the ordering worker
+type Temporal struct {
+	HostPort  string `yaml:"host_port"`  // TEMPORAL_HOST_PORT
+	Namespace string `yaml:"namespace"`  // TEMPORAL_NAMESPACE
+	TaskQueue string `yaml:"task_queue"` // TEMPORAL_TASK_QUEUE
+	// MaxConcurrentActivities and MaxConcurrentWorkflowTasks bound what one worker runs at once
+	MaxConcurrentActivities    int `yaml:"max_concurrent_activities"`     // TEMPORAL_MAX_CONCURRENT_ACTIVITIES
+	MaxConcurrentWorkflowTasks int `yaml:"max_concurrent_workflow_tasks"` // TEMPORAL_MAX_CONCURRENT_WORKFLOW_TASKS
+	// WorkerStopTimeout is how long a stopping worker waits for its running activities
+	WorkerStopTimeout time.Duration `yaml:"worker_stop_timeout"` // TEMPORAL_WORKER_STOP_TIMEOUT
+}
+
+// Relay configures the outbox relays of every module
+type Relay struct {
+	// Mode is "polling" or "change_stream"
+	Mode          string        `yaml:"mode"`           // RELAY_MODE
+	PollInterval  time.Duration `yaml:"poll_interval"`  // RELAY_POLL_INTERVAL
+	LeaseDuration time.Duration `yaml:"lease_duration"` // RELAY_LEASE_DURATION
+	BatchSize     int           `yaml:"batch_size"`     // RELAY_BATCH_SIZE
+	Concurrency   int           `yaml:"concurrency"`    // RELAY_CONCURRENCY
+	MaxRetries    int32         `yaml:"max_retries"`    // RELAY_MAX_RETRIES
+	BaseBackoff   time.Duration `yaml:"base_backoff"`   // RELAY_BASE_BACKOFF
+	MaxBackoff    time.Duration `yaml:"max_backoff"`    // RELAY_MAX_BACKOFF
+}
+
+// Default returns the configuration used for a local development stack
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:            ":8080",
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Mongo: Mongo{
+			URI: "mongodb://localhost:27017",
+			Databases: Databases{
+				Customers: "CustomersDB",
+				Inventory: "InventoryDB",
+				Ordering:  "OrderingDB",
+			},
+		},
+		NATS: NATS{
+			URL: "nats://127.0.0.1:4222",
+		},
+		Temporal: Temporal{
+			HostPort:                   "localhost:7233",
+			Namespace:                  "default",
+			TaskQueue:                  "ordering",
+			MaxConcurrentActivities:    100,
+			MaxConcurrentWorkflowTasks: 100,
+			WorkerStopTimeout:          15 * time.Second,
+		},
+		Relay: Relay{
+			Mode:          "change_stream",
+			PollInterval:  5 * time.Second,
+			LeaseDuration: 30 * time.Second,
+			BatchSize:     100,
+			Concurrency:   10,
+			MaxRetries:    5,
+			BaseBackoff:   time.Second,
+			MaxBackoff:    5 * time.Minute,
+		},
+	}
+}
+
+// Load reads the configuration from the file named by APP_CONFIG, if set, and the environment
+func Load() (Config, error) {
+	return LoadFrom(os.Getenv(FileEnv), os.LookupEnv)
+}
+
+// LoadFrom applies the YAML file at path, when path is not empty, and then the variables
+// returned by lookupEnv on top of the defaults, and validates the result
+func LoadFrom(path string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	env := envReader{lookup: lookupEnv}
+	env.string("HTTP_ADDR", &cfg.HTTP.Addr)
+	env.duration("HTTP_SHUTDOWN_TIMEOUT", &cfg.HTTP.ShutdownTimeout)
+	env.string("MONGO_URI", &cfg.Mongo.URI)
+	env.string("MONGO_CUSTOMERS_DB", &cfg.Mongo.Databases.Customers)
+	env.string("MONGO_INVENTORY_DB", &cfg.Mongo.Databases.Inventory)
+	env.string("MONGO_ORDERING_DB", &cfg.Mongo.Databases.Ordering)
+	env.string("NATS_URL", &cfg.NATS.URL)
+	env.string("TEMPORAL_HOST_PORT", &cfg.Temporal.HostPort)
+	env.string("TEMPORAL_NAMESPACE", &cfg.Temporal.Namespace)
+	env.string("TEMPORAL_TASK_QUEUE", &cfg.Temporal.TaskQueue)
+	env.int("TEMPORAL_MAX_CONCURRENT_ACTIVITIES", &cfg.Temporal.MaxConcurrentActivities)
+	env.int("TEMPORAL_MAX_CONCURRENT_WORKFLOW_TASKS", &cfg.Temporal.MaxConcurrentWorkflowTasks)
+	env.duration("TEMPORAL_WORKER_STOP_TIMEOUT", &cfg.Temporal.WorkerStopTimeout)
+	env.string("RELAY_MODE", &cfg.Relay.Mode)
+	env.duration("RELAY_POLL_INTERVAL", &cfg.Relay.PollInterval)
+	env.duration("RELAY_LEASE_DURATION", &cfg.Relay.LeaseDuration)
+	env.int("RELAY_BATCH_SIZE", &cfg.Relay.BatchSize)
+	env.int("RELAY_CONCURRENCY", &cfg.Relay.Concurrency)
+	env.int32("RELAY_MAX_RETRIES", &cfg.Relay.MaxRetries)
+	env.duration("RELAY_BASE_BACKOFF", &cfg.Relay.BaseBackoff)
+	env.duration("RELAY_MAX_BACKOFF", &cfg.Relay.MaxBackoff)
+	if err := errors.Join(env.errs...); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate reports every missing or out-of-range setting
+func (c Config) Validate() error {
+	var errs []error
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"http.addr", c.HTTP.Addr},
+		{"mongo.uri", c.Mongo.URI},
+		{"mongo.databases.customers", c.Mongo.Databases.Customers},
+		{"mongo.databases.inventory", c.Mongo.Databases.Inventory},
+		{"mongo.databases.ordering", c.Mongo.Databases.Ordering},
+		{"nats.url", c.NATS.URL},
+		{"temporal.host_port", c.Temporal.HostPort},
+		{"temporal.namespace", c.Temporal.Namespace},
+		{"temporal.task_queue", c.Temporal.TaskQueue},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", r.name))
+		}
+	}
+
+	if c.Relay.Mode != "polling" && c.Relay.Mode != "change_stream" {
+		errs = append(errs, fmt.Errorf("relay.mode must be polling or change_stream, got %q", c.Relay.Mode))
+	}
+	positive := []struct {
+		name  string
+		value int64
+	}{
+		{"http.shutdown_timeout", int64(c.HTTP.ShutdownTimeout)},
+		{"temporal.max_concurrent_activities", int64(c.Temporal.MaxConcurrentActivities)},
+		{"temporal.max_concurrent_workflow_tasks", int64(c.Temporal.MaxConcurrentWorkflowTasks)},
+		{"temporal.worker_stop_timeout", int64(c.Temporal.WorkerStopTimeout)},
+		{"relay.poll_interval", int64(c.Relay.PollInterval)},
+		{"relay.lease_duration", int64(c.Relay.LeaseDuration)},
+		{"relay.batch_size", int64(c.Relay.BatchSize)},
+		{"relay.concurrency", int64(c.Relay.Concurrency)},
+		{"relay.max_retries", int64(c.Relay.MaxRetries)},
+		{"relay.base_backoff", int64(c.Relay.BaseBackoff)},
+		{"relay.max_backoff", int64(c.Relay.MaxBackoff)},
+	}
+	for _, p := range positive {
+		if p.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", p.name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// envReader overrides settings from environment variables, collecting parse errors
+type envReader struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+func (e *envReader) string(name string, target *string) {
+	if value, ok := e.lookup(name); ok {
+		*target = value
+	}
+}
+
+func (e *envReader) duration(name string, target *time.Duration) {
+	if value, ok := e.lookup(name); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*target = d
+	}
+}
+
+func (e *envReader) int(name string, target *int) {
+	if value, ok := e.lookup(name); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*target = n
+	}
+}
+
+func (e *envReader) int32(name string, target *int32) {
+	if value, ok := e.lookup(name); ok {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*target = int32(n)
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := LoadFrom("", env(nil))
+
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestLoadFileThenEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+http:
+  addr: ":9090"
+mongo:
+  uri: mongodb://mongo:27017/?replicaSet=rs0
+  databases:
+    ordering: OrderingTestDB
+temporal:
+  namespace: orders
+relay:
+  mode: polling
+  poll_interval: 2s
+`), 0o600))
+
+	cfg, err := LoadFrom(path, env(map[string]string{
+		"NATS_URL":                           "nats://nats:4222",
+		"RELAY_POLL_INTERVAL":                "500ms",
+		"RELAY_BATCH_SIZE":                   "25",
+		"TEMPORAL_MAX_CONCURRENT_ACTIVITIES": "8",
+	}))
+
+	require.NoError(t, err)
+	// From the file
+	assert.Equal(t, ":9090", cfg.HTTP.Addr)
+	assert.Equal(t, "mongodb://mongo:27017/?replicaSet=rs0", cfg.Mongo.URI)
+	assert.Equal(t, "OrderingTestDB", cfg.Mongo.Databases.Ordering)
+	assert.Equal(t, "orders", cfg.Temporal.Namespace)
+	assert.Equal(t, "polling", cfg.Relay.Mode)
+	// Defaults the file leaves alone
+	assert.Equal(t, "CustomersDB", cfg.Mongo.Databases.Customers)
+	assert.Equal(t, "localhost:7233", cfg.Temporal.HostPort)
+	// The environment wins over the file
+	assert.Equal(t, "nats://nats:4222", cfg.NATS.URL)
+	assert.Equal(t, 500*time.Millisecond, cfg.Relay.PollInterval)
+	assert.Equal(t, 25, cfg.Relay.BatchSize)
+	assert.Equal(t, 8, cfg.Temporal.MaxConcurrentActivities)
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	_, err := LoadFrom("", env(map[string]string{"RELAY_POLL_INTERVAL": "soon"}))
+	assert.ErrorContains(t, err, "RELAY_POLL_INTERVAL")
+
+	_, err = LoadFrom("", env(map[string]string{"MONGO_URI": "", "RELAY_MODE": "push", "RELAY_CONCURRENCY": "0"}))
+	assert.ErrorContains(t, err, "mongo.uri is required")
+	assert.ErrorContains(t, err, "relay.mode must be polling or change_stream")
+	assert.ErrorContains(t, err, "relay.concurrency must be positive")
+
+	_, err = LoadFrom(filepath.Join(t.TempDir(), "missing.yaml"), env(nil))
+	assert.Error(t, err)
+}
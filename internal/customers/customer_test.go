@@ -2,10 +2,19 @@ package customers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
 
+	"app/internal/cloudevents"
+	"app/internal/outbox"
+	"app/internal/schema"
+
+	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -48,8 +57,8 @@ type MockEventForwarder struct {
 	mock.Mock
 }
 
-func (m *MockEventForwarder) Forward(event OutboxEvent) error {
-	args := m.Called(event)
+func (m *MockEventForwarder) Forward(ctx context.Context, event OutboxEvent) error {
+	args := m.Called(ctx, event)
 	return args.Error(0)
 }
 
@@ -57,21 +66,61 @@ func (m *MockEventForwarder) Start() {
 	m.Called()
 }
 
-func (m *MockEventForwarder) Stop() {
-	m.Called()
+func (m *MockEventForwarder) Stop(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+type MockUnitOfWork struct {
+	mock.Mock
+}
+
+func (m *MockUnitOfWork) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	m.Called(ctx)
+	return fn(ctx)
+}
+
+type MockProjectionRepository struct {
+	mock.Mock
+}
+
+func (m *MockProjectionRepository) Upsert(ctx context.Context, projection *CustomerProjection, meta outbox.Meta) error {
+	args := m.Called(ctx, projection, meta)
+	return args.Error(0)
+}
+
+func (m *MockProjectionRepository) MarkDeleted(ctx context.Context, id primitive.ObjectID, meta outbox.Meta, deletedAt time.Time) error {
+	args := m.Called(ctx, id, meta, deletedAt)
+	return args.Error(0)
+}
+
+type MockPublisher struct {
+	mock.Mock
+}
+
+func (m *MockPublisher) Publish(subject string, data []byte) error {
+	args := m.Called(subject, data)
+	return args.Error(0)
+}
+
+func (m *MockPublisher) PublishMsg(msg *nats.Msg) error {
+	args := m.Called(msg)
+	return args.Error(0)
 }
 
 type CustomerTestSuite struct {
 	suite.Suite
 	mockRepo       *MockRepository
 	mockForwarder  *MockEventForwarder
+	mockUnitOfWork *MockUnitOfWork
 	service        *Service
 }
 
 func (s *CustomerTestSuite) SetupTest() {
 	s.mockRepo = new(MockRepository)
 	s.mockForwarder = new(MockEventForwarder)
-	s.service = NewService(s.mockRepo, s.mockForwarder)
+	s.mockUnitOfWork = new(MockUnitOfWork)
+	s.service = NewService(s.mockRepo, s.mockForwarder, s.mockUnitOfWork)
 }
 
 func TestCustomerTestSuite(t *testing.T) {
@@ -87,7 +136,8 @@ func (s *CustomerTestSuite) TestCreateCustomer() {
 
 	// Setup expectations
 	s.mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*customers.Customer")).Return(nil)
-	s.mockForwarder.On("Forward", mock.AnythingOfType("OutboxEvent")).Return(nil)
+	s.mockUnitOfWork.On("WithTransaction", mock.Anything).Return()
+	s.mockForwarder.On("Forward", mock.Anything, mock.AnythingOfType("OutboxEvent")).Return(nil)
 
 	// Execute test
 	err := s.service.CreateCustomer(context.Background(), customer)
@@ -96,6 +146,29 @@ func (s *CustomerTestSuite) TestCreateCustomer() {
 	s.NoError(err)
 	s.mockRepo.AssertExpectations(s.T())
 	s.mockForwarder.AssertExpectations(s.T())
+	s.mockUnitOfWork.AssertExpectations(s.T())
+}
+
+func (s *CustomerTestSuite) TestCreateCustomerForwardFailure() {
+	// Test data
+	customer := &Customer{
+		Email: "test@example.com",
+		Name:  "Test User",
+	}
+
+	// Setup expectations
+	s.mockUnitOfWork.On("WithTransaction", mock.Anything).Return()
+	s.mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*customers.Customer")).Return(nil)
+	s.mockForwarder.On("Forward", mock.Anything, mock.AnythingOfType("OutboxEvent")).Return(errors.New("write conflict"))
+
+	// Execute test
+	err := s.service.CreateCustomer(context.Background(), customer)
+
+	// Assertions: the error is returned from inside the transaction so it is rolled back
+	s.Error(err)
+	s.mockRepo.AssertExpectations(s.T())
+	s.mockForwarder.AssertExpectations(s.T())
+	s.mockUnitOfWork.AssertExpectations(s.T())
 }
 
 func (s *CustomerTestSuite) TestUpdateCustomer() {
@@ -108,7 +181,8 @@ func (s *CustomerTestSuite) TestUpdateCustomer() {
 
 	// Setup expectations
 	s.mockRepo.On("Update", mock.Anything, mock.AnythingOfType("*customers.Customer")).Return(nil)
-	s.mockForwarder.On("Forward", mock.AnythingOfType("OutboxEvent")).Return(nil)
+	s.mockUnitOfWork.On("WithTransaction", mock.Anything).Return()
+	s.mockForwarder.On("Forward", mock.Anything, mock.AnythingOfType("OutboxEvent")).Return(nil)
 
 	// Execute test
 	err := s.service.UpdateCustomer(context.Background(), customer)
@@ -117,6 +191,7 @@ func (s *CustomerTestSuite) TestUpdateCustomer() {
 	s.NoError(err)
 	s.mockRepo.AssertExpectations(s.T())
 	s.mockForwarder.AssertExpectations(s.T())
+	s.mockUnitOfWork.AssertExpectations(s.T())
 }
 
 func (s *CustomerTestSuite) TestFindCustomerByEmail() {
@@ -166,7 +241,8 @@ func (s *CustomerTestSuite) TestSoftDeleteCustomer() {
 
 	// Setup expectations
 	s.mockRepo.On("SoftDelete", mock.Anything, id).Return(nil)
-	s.mockForwarder.On("Forward", mock.AnythingOfType("OutboxEvent")).Return(nil)
+	s.mockUnitOfWork.On("WithTransaction", mock.Anything).Return()
+	s.mockForwarder.On("Forward", mock.Anything, mock.AnythingOfType("OutboxEvent")).Return(nil)
 
 	// Execute test
 	err := s.service.SoftDeleteCustomer(context.Background(), id)
@@ -175,4 +251,262 @@ func (s *CustomerTestSuite) TestSoftDeleteCustomer() {
 	s.NoError(err)
 	s.mockRepo.AssertExpectations(s.T())
 	s.mockForwarder.AssertExpectations(s.T())
+	s.mockUnitOfWork.AssertExpectations(s.T())
+}
+
+func (s *CustomerTestSuite) TestForwarderPublishesToEventSubject() {
+	// Test data
+	event := OutboxEvent{
+		ID:            primitive.NewObjectID(),
+		EventType:     "CustomerCreated",
+		AggregateID:   primitive.NewObjectID().Hex(),
+		Sequence:      1,
+		SchemaVersion: 1,
+		Payload:       []byte(`{"name":"Test User"}`),
+		Status:        "pending",
+	}
+
+	for _, mode := range []cloudevents.Mode{cloudevents.Structured, cloudevents.Binary} {
+		mockPublisher := new(MockPublisher)
+		forwarder := &EventForwarderImpl{publisher: mockPublisher, config: ForwarderConfig{Encoding: mode}}
+
+		// Setup expectations
+		mockPublisher.On("PublishMsg", mock.MatchedBy(func(msg *nats.Msg) bool {
+			ce, err := cloudevents.FromMsg(msg)
+			return err == nil &&
+				msg.Subject == "customers.CustomerCreated" &&
+				ce.ID == event.ID.Hex() &&
+				ce.Source == EventSource &&
+				ce.Type == "customers.CustomerCreated" &&
+				ce.Subject == event.AggregateID &&
+				ce.Sequence == 1 &&
+				ce.SchemaVersion == 1 &&
+				string(ce.Data) == string(event.Payload)
+		})).Return(nil)
+
+		// Execute test
+		err := forwarder.publish(event)
+
+		// Assertions
+		s.NoError(err, mode)
+		mockPublisher.AssertExpectations(s.T())
+	}
+}
+
+func (s *CustomerTestSuite) TestForwarderRejectsInvalidPayload() {
+	forwarder := &EventForwarderImpl{}
+	event := OutboxEvent{
+		ID:            primitive.NewObjectID(),
+		EventType:     "CustomerCreated",
+		SchemaVersion: 1,
+		Payload:       []byte(`{"name":"Test User","email":"not-an-email"}`),
+	}
+
+	// Execute test: validation fails before the outbox is touched
+	err := forwarder.Forward(context.Background(), event)
+
+	// Assertions
+	var invalid *schema.ValidationError
+	s.ErrorAs(err, &invalid)
+
+	event.SchemaVersion = 3
+	s.ErrorIs(forwarder.Forward(context.Background(), event), schema.ErrUnknownEvent)
+}
+
+func (s *CustomerTestSuite) TestForwarderStopEndsPollLoop() {
+	forwarder := &EventForwarderImpl{
+		config:   ForwarderConfig{Mode: outbox.ModePolling, PollInterval: time.Hour},
+		stopChan: make(chan struct{}),
+	}
+	forwarder.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.NoError(forwarder.Stop(ctx))
+}
+
+func (s *CustomerTestSuite) TestForwarderBackoff() {
+	forwarder := &EventForwarderImpl{config: ForwarderConfig{
+		MaxRetries:  10,
+		BaseBackoff: time.Second,
+		MaxBackoff:  10 * time.Second,
+	}}
+
+	s.Equal(time.Second, forwarder.backoff(1))
+	s.Equal(2*time.Second, forwarder.backoff(2))
+	s.Equal(8*time.Second, forwarder.backoff(4))
+	s.Equal(10*time.Second, forwarder.backoff(5))
+}
+
+func (s *CustomerTestSuite) TestForwarderFailureUpdate() {
+	forwarder := &EventForwarderImpl{config: ForwarderConfig{
+		MaxRetries:  3,
+		BaseBackoff: time.Second,
+		MaxBackoff:  time.Minute,
+	}}
+	now := time.Now()
+	cause := errors.New("nats: timeout")
+
+	// Test retry is scheduled with backoff
+	update := forwarder.failureUpdate(OutboxEvent{RetryCount: 1}, cause, now)
+	set := update["$set"].(bson.M)
+	s.Equal(int32(2), set["retry_count"])
+	s.Equal("nats: timeout", set["error"])
+	s.Equal(now.Add(2*time.Second), set["next_attempt_at"])
+	s.NotContains(set, "status")
+	s.Contains(update["$unset"], "lease_owner")
+
+	// Test event is dead-lettered once retries are exhausted
+	update = forwarder.failureUpdate(OutboxEvent{RetryCount: 2}, cause, now)
+	set = update["$set"].(bson.M)
+	s.Equal(int32(3), set["retry_count"])
+	s.Equal(OutboxStatusDeadLetter, set["status"])
+	s.NotContains(set, "next_attempt_at")
+}
+
+func (s *CustomerTestSuite) TestProjectorUpsertsCustomer() {
+	// Test data
+	mockProjections := new(MockProjectionRepository)
+	projector := NewProjector(mockProjections)
+	customer := Customer{
+		ID:    primitive.NewObjectID(),
+		Email: "test@example.com",
+		Name:  "Test User",
+	}
+	payload, err := json.Marshal(customer)
+	s.Require().NoError(err)
+
+	meta := outbox.Meta{EventID: primitive.NewObjectID().Hex(), Sequence: 1, Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	// Setup expectations
+	mockProjections.On("Upsert", mock.Anything, mock.MatchedBy(func(p *CustomerProjection) bool {
+		return p.ID == customer.ID && p.Email == customer.Email && p.Name == customer.Name && !p.Deleted
+	}), meta).Return(nil)
+
+	// Execute test
+	event := cloudevents.New(meta.EventID, EventSource, "customers.CustomerCreated", payload)
+	event.Time = meta.Time
+	event.Sequence = meta.Sequence
+	event.SchemaVersion = 1
+	msg, err := cloudevents.NewMsg("customers.CustomerCreated", event, cloudevents.Structured)
+	s.Require().NoError(err)
+	projector.HandleMessage(msg)
+
+	// Assertions
+	mockProjections.AssertExpectations(s.T())
+}
+
+func (s *CustomerTestSuite) TestProjectorCopiesAddresses() {
+	// Test data
+	mockProjections := new(MockProjectionRepository)
+	projector := NewProjector(mockProjections)
+	delivery := &Address{Line1: "1 Harbour Street", City: "Singapore", PostalCode: "049315", Country: "SG"}
+	customer := Customer{
+		ID:              primitive.NewObjectID(),
+		Email:           "test@example.com",
+		Name:            "Test User",
+		DeliveryAddress: delivery,
+	}
+	payload, err := json.Marshal(customer)
+	s.Require().NoError(err)
+	meta := outbox.Meta{EventID: primitive.NewObjectID().Hex(), Sequence: 2}
+
+	// Setup expectations
+	mockProjections.On("Upsert", mock.Anything, mock.MatchedBy(func(p *CustomerProjection) bool {
+		return p.ID == customer.ID && *p.DeliveryAddress == *delivery && p.BillingAddress == nil
+	}), meta).Return(nil)
+
+	// Execute test
+	err = projector.Project(context.Background(), "CustomerUpdated", 2, meta, payload)
+
+	// Assertions
+	s.NoError(err)
+	mockProjections.AssertExpectations(s.T())
+}
+
+func (s *CustomerTestSuite) TestProjectorUpcastsEventWithoutAddresses() {
+	// Test data: a v1 event written before customers had addresses
+	mockProjections := new(MockProjectionRepository)
+	projector := NewProjector(mockProjections)
+	id := primitive.NewObjectID()
+	meta := outbox.Meta{EventID: primitive.NewObjectID().Hex(), Sequence: 1}
+	payload := `{"id":"` + id.Hex() + `","name":"Old User","email":"old@example.com","deleted":false,` +
+		`"created_at":"2024-01-01T00:00:00Z","updated_at":"2024-01-01T00:00:00Z"}`
+
+	// Setup expectations
+	mockProjections.On("Upsert", mock.Anything, mock.MatchedBy(func(p *CustomerProjection) bool {
+		return p.ID == id && p.DeliveryAddress == nil && p.BillingAddress == nil
+	}), meta).Return(nil)
+
+	// Execute test
+	err := projector.Project(context.Background(), "CustomerCreated", 1, meta, []byte(payload))
+
+	// Assertions
+	s.NoError(err)
+	mockProjections.AssertExpectations(s.T())
+
+	// A v2 address must be complete
+	invalid := `{"id":"` + id.Hex() + `","name":"New User","email":"new@example.com","deleted":false,` +
+		`"delivery_address":{"line1":"1 Harbour Street"},"billing_address":null,` +
+		`"created_at":"2024-01-01T00:00:00Z","updated_at":"2024-01-01T00:00:00Z"}`
+	var validation *schema.ValidationError
+	s.ErrorAs(projector.Project(context.Background(), "CustomerCreated", 2, meta, []byte(invalid)), &validation)
+}
+
+func (s *CustomerTestSuite) TestProjectorMarksCustomerDeleted() {
+	// Test data
+	mockProjections := new(MockProjectionRepository)
+	projector := NewProjector(mockProjections)
+	id := primitive.NewObjectID()
+	deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	meta := outbox.Meta{EventID: primitive.NewObjectID().Hex(), Sequence: 3, Time: deletedAt}
+
+	// Setup expectations: the deletion is stamped with the event's time
+	mockProjections.On("MarkDeleted", mock.Anything, id, meta, deletedAt).Return(nil)
+
+	// Execute test
+	err := projector.Project(context.Background(), "CustomerDeleted", 1, meta, []byte(`{"id":"`+id.Hex()+`"}`))
+
+	// Assertions
+	s.NoError(err)
+	mockProjections.AssertExpectations(s.T())
+}
+
+func (s *CustomerTestSuite) TestProjectorRejectsUnknownEvent() {
+	projector := NewProjector(new(MockProjectionRepository))
+
+	err := projector.Project(context.Background(), "CustomerMerged", 1, outbox.Meta{EventID: "event-1", Sequence: 1}, []byte(`{}`))
+
+	s.Error(err)
+}
+
+func (s *CustomerTestSuite) TestProjectorRejectsInvalidPayload() {
+	mockProjections := new(MockProjectionRepository)
+	projector := NewProjector(mockProjections)
+
+	err := projector.Project(context.Background(), "CustomerUpdated", 1, outbox.Meta{EventID: "event-1", Sequence: 1}, []byte(`{"name":"No ID"}`))
+
+	var invalid *schema.ValidationError
+	s.ErrorAs(err, &invalid)
+	mockProjections.AssertNotCalled(s.T(), "Upsert", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (s *CustomerTestSuite) TestProjectorIgnoresStaleEvent() {
+	// Test data
+	mockProjections := new(MockProjectionRepository)
+	projector := NewProjector(mockProjections)
+	id := primitive.NewObjectID()
+	meta := outbox.Meta{EventID: primitive.NewObjectID().Hex(), Sequence: 1}
+
+	// Setup expectations: the projection is already at a later version
+	mockProjections.On("Upsert", mock.Anything, mock.AnythingOfType("*customers.CustomerProjection"), meta).Return(outbox.ErrStaleEvent)
+
+	// Execute test
+	payload := `{"id":"` + id.Hex() + `","name":"Old Name","email":"old@example.com","deleted":false,` +
+		`"created_at":"2024-01-01T00:00:00Z","updated_at":"2024-01-01T00:00:00Z"}`
+	err := projector.Project(context.Background(), "CustomerUpdated", 1, meta, []byte(payload))
+
+	// Assertions
+	s.ErrorIs(err, outbox.ErrStaleEvent)
+	mockProjections.AssertExpectations(s.T())
 }
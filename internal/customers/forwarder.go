@@ -2,41 +2,119 @@ package customers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"app/internal/cloudevents"
+	"app/internal/outbox"
+
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const (
+	// EventSubjectPrefix is prepended to the event type to form the NATS subject and CloudEvents type
+	EventSubjectPrefix = "customers."
+	// EventSource is the CloudEvents source of every customer event
+	EventSource = "/customers"
+)
+
+// ErrEventNotFound is returned when an outbox event does not exist in the expected status
+var ErrEventNotFound = errors.New("outbox event not found")
+
+// ForwarderConfig controls how the forwarder finds new events and how failed events are retried
+type ForwarderConfig struct {
+	// Mode selects polling or change-stream relaying; polling always runs to pick up retries
+	Mode         outbox.Mode
+	PollInterval time.Duration
+	// MaxRetries is the number of failed attempts before an event is dead-lettered
+	MaxRetries  int32
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// InstanceID owns the leases this forwarder takes; it must differ between app instances
+	InstanceID    string
+	LeaseDuration time.Duration
+	// BatchSize is the number of aggregates relayed per poll, Concurrency how many at once
+	BatchSize   int
+	Concurrency int
+	// Encoding selects structured or binary CloudEvents messages
+	Encoding cloudevents.Mode
+}
+
+// DefaultForwarderConfig returns the forwarder settings used when none are supplied
+func DefaultForwarderConfig() ForwarderConfig {
+	return ForwarderConfig{
+		Mode:          outbox.ModePolling,
+		PollInterval:  5 * time.Second,
+		MaxRetries:    5,
+		BaseBackoff:   time.Second,
+		MaxBackoff:    5 * time.Minute,
+		InstanceID:    outbox.NewOwnerID(),
+		LeaseDuration: 30 * time.Second,
+		BatchSize:     100,
+		Concurrency:   10,
+		Encoding:      cloudevents.Structured,
+	}
+}
+
 type EventForwarderImpl struct {
-	client     *mongo.Client
 	db         *mongo.Database
 	collection *mongo.Collection
+	sequences  *mongo.Collection
+	relay      *outbox.OrderedRelay[OutboxEvent]
+	publisher  Publisher
+	config     ForwarderConfig
 	stopChan   chan struct{}
+	// running tracks the poll and watch loops so Stop can wait for their current batch
+	running sync.WaitGroup
 }
 
-func NewEventForwarder(mongoURI string) *EventForwarderImpl {
-	ctx := context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
-	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
-	}
-
-	db := client.Database("CustomersDB")
+// NewEventForwarder creates a forwarder on the outbox of db; it must share the
+// client used by the repository so Forward can join the caller's transaction
+func NewEventForwarder(db *mongo.Database, publisher Publisher, config ForwarderConfig) *EventForwarderImpl {
 	collection := db.Collection("outbox")
 
-	return &EventForwarderImpl{
-		client:     client,
+	f := &EventForwarderImpl{
 		db:         db,
 		collection: collection,
+		sequences:  db.Collection("outbox_sequences"),
+		publisher:  publisher,
+		config:     config,
 		stopChan:   make(chan struct{}),
 	}
+	f.relay = &outbox.OrderedRelay[OutboxEvent]{
+		Collection: collection,
+		Lease: outbox.Lease{
+			Owner:    config.InstanceID,
+			Duration: config.LeaseDuration,
+		},
+		// A dead-lettered event holds back the rest of its aggregate until it is redriven
+		Unfinished:  []string{OutboxStatusPending, OutboxStatusDeadLetter},
+		Due:         dueFilter,
+		Concurrency: config.Concurrency,
+		Process:     f.processEvent,
+	}
+	return f
 }
 
-func (f *EventForwarderImpl) Forward(event OutboxEvent) error {
-	_, err := f.collection.InsertOne(context.Background(), event)
+// Forward validates the event against its schema and inserts it with the next sequence number of its aggregate
+func (f *EventForwarderImpl) Forward(ctx context.Context, event OutboxEvent) error {
+	if err := validateEvent(event); err != nil {
+		return err
+	}
+
+	sequence, err := outbox.NextSequence(ctx, f.sequences, event.AggregateID)
+	if err != nil {
+		return err
+	}
+	event.Sequence = sequence
+
+	_, err = f.collection.InsertOne(ctx, event)
 	if err != nil {
 		return err
 	}
@@ -44,18 +122,41 @@ func (f *EventForwarderImpl) Forward(event OutboxEvent) error {
 }
 
 func (f *EventForwarderImpl) Start() {
-	go f.processOutboxEvents()
+	f.running.Add(1)
+	go func() {
+		defer f.running.Done()
+		f.processOutboxEvents()
+	}()
+	if f.config.Mode == outbox.ModeChangeStream {
+		f.running.Add(1)
+		go func() {
+			defer f.running.Done()
+			f.watchOutboxEvents()
+		}()
+	}
 }
 
-func (f *EventForwarderImpl) Stop() {
+// Stop ends the poll and watch loops and waits for the batch in flight to finish,
+// returning ctx's error if the deadline passes first
+func (f *EventForwarderImpl) Stop(ctx context.Context) error {
 	close(f.stopChan)
-	if err := f.client.Disconnect(context.Background()); err != nil {
-		log.Printf("Error disconnecting from MongoDB: %v", err)
+
+	done := make(chan struct{})
+	go func() {
+		f.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 func (f *EventForwarderImpl) processOutboxEvents() {
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(f.config.PollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -63,43 +164,230 @@ func (f *EventForwarderImpl) processOutboxEvents() {
 		case <-f.stopChan:
 			return
 		case <-ticker.C:
-			ctx := context.Background()
-			filter := bson.M{"status": "pending"}
-
-			// Find pending events
-			cursor, err := f.collection.Find(ctx, filter)
-			if err != nil {
-				log.Printf("Error finding pending events: %v", err)
-				continue
-			}
-
-			var events []OutboxEvent
-			if err := cursor.All(ctx, &events); err != nil {
-				log.Printf("Error decoding events: %v", err)
-				cursor.Close(ctx)
-				continue
-			}
-			cursor.Close(ctx)
-
-			for _, event := range events {
-				// Process event (in a real application, this would publish to a message broker)
-				log.Printf("Processing event: %s", event.EventType)
-
-				// Mark event as processed
-				_, err := f.collection.UpdateOne(
-					ctx,
-					bson.M{"_id": event.ID},
-					bson.M{
-						"$set": bson.M{
-							"status":     "processed",
-							"updatedAt": time.Now(),
-						},
-					},
-				)
-				if err != nil {
-					log.Printf("Error updating event status: %v", err)
-				}
-			}
+			f.processPendingEvents(context.Background())
+		}
+	}
+}
+
+// watchOutboxEvents relays events as soon as the change stream reports them,
+// leaving the poll loop as the only relay when change streams are unavailable
+func (f *EventForwarderImpl) watchOutboxEvents() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-f.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	watcher := outbox.NewWatcher("customers.outbox", f.collection)
+	for {
+		err := watcher.Run(ctx, f.handleOutboxChange)
+		if ctx.Err() != nil {
+			return
+		}
+		if outbox.IsChangeStreamUnsupported(err) {
+			log.Printf("Change streams unavailable, falling back to polling: %v", err)
+			return
+		}
+		log.Printf("Error watching outbox, restarting: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.config.PollInterval):
+		}
+	}
+}
+
+func (f *EventForwarderImpl) handleOutboxChange(ctx context.Context, id primitive.ObjectID) error {
+	var event OutboxEvent
+	err := f.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	// Relay from the head of the aggregate, which may be an earlier event than this one.
+	// Stopping cancels the change stream but lets the aggregate in flight finish.
+	if err := f.relay.RunAggregate(context.WithoutCancel(ctx), event.AggregateID); err != nil {
+		// The failed event is retried by the poll loop; the change stream carries on
+		log.Printf("Error relaying event %s: %v", id.Hex(), err)
+	}
+	return nil
+}
+
+func (f *EventForwarderImpl) processPendingEvents(ctx context.Context) {
+	if err := f.relay.RunOnce(ctx, f.config.BatchSize); err != nil {
+		log.Printf("Error relaying pending events: %v", err)
+	}
+}
+
+// processEvent publishes a leased event; an error leaves the rest of its aggregate waiting
+func (f *EventForwarderImpl) processEvent(ctx context.Context, event OutboxEvent) error {
+	owned := outbox.OwnedFilter(event.ID, f.config.InstanceID)
+
+	// Schedule a retry when the broker did not acknowledge the event
+	if err := f.publish(event); err != nil {
+		log.Printf("Error publishing event %s: %v", event.ID.Hex(), err)
+		if _, err := f.collection.UpdateOne(ctx, owned, f.failureUpdate(event, err, time.Now())); err != nil {
+			log.Printf("Error recording event failure: %v", err)
+		}
+		return err
+	}
+
+	// Mark event as processed
+	result, err := f.collection.UpdateOne(
+		ctx,
+		owned,
+		bson.M{
+			"$set": bson.M{
+				"status":     OutboxStatusProcessed,
+				"updated_at": time.Now(),
+			},
+			"$unset": outbox.ReleaseLease(),
+		},
+	)
+	if err != nil {
+		log.Printf("Error updating event status: %v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		log.Printf("Lease on event %s expired before it was marked processed", event.ID.Hex())
+		return fmt.Errorf("lease on event %s lost", event.ID.Hex())
+	}
+	return nil
+}
+
+// dueFilter matches events whose retry backoff has elapsed
+func dueFilter(now time.Time) bson.M {
+	return bson.M{
+		"$or": bson.A{
+			bson.M{"next_attempt_at": bson.M{"$exists": false}},
+			bson.M{"next_attempt_at": bson.M{"$lte": now}},
+		},
+	}
+}
+
+// EventSubject returns the NATS subject an event type is published on
+func EventSubject(eventType string) string {
+	return EventSubjectPrefix + eventType
+}
+
+// publish sends the event to the broker as a CloudEvent; a nil error means it was acknowledged.
+// The event ID and sequence let consumers drop duplicates and stale events.
+func (f *EventForwarderImpl) publish(event OutboxEvent) error {
+	msg, err := cloudevents.NewMsg(EventSubject(event.EventType), NewCloudEvent(event), f.config.Encoding)
+	if err != nil {
+		return err
+	}
+	return f.publisher.PublishMsg(msg)
+}
+
+// NewCloudEvent wraps an outbox event in its CloudEvents envelope
+func NewCloudEvent(event OutboxEvent) cloudevents.Event {
+	ce := cloudevents.New(event.ID.Hex(), EventSource, EventSubject(event.EventType), event.Payload)
+	ce.Subject = event.AggregateID
+	ce.Time = event.CreatedAt.UTC()
+	ce.Sequence = event.Sequence
+	ce.SchemaVersion = event.SchemaVersion
+	if ce.SchemaVersion == 0 {
+		// Written before schema versions were recorded
+		ce.SchemaVersion = 1
+	}
+	return ce
+}
+
+// backoff returns the delay before the given attempt, doubling from BaseBackoff up to MaxBackoff
+func (f *EventForwarderImpl) backoff(retryCount int32) time.Duration {
+	delay := f.config.BaseBackoff
+	for i := int32(1); i < retryCount; i++ {
+		delay *= 2
+		if delay >= f.config.MaxBackoff {
+			return f.config.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// failureUpdate records a failed attempt, either scheduling the next one or dead-lettering the event
+func (f *EventForwarderImpl) failureUpdate(event OutboxEvent, cause error, now time.Time) bson.M {
+	retryCount := event.RetryCount + 1
+	set := bson.M{
+		"retry_count": retryCount,
+		"error":       cause.Error(),
+		"updated_at":  now,
+	}
+
+	if retryCount >= f.config.MaxRetries {
+		set["status"] = OutboxStatusDeadLetter
+	} else {
+		set["next_attempt_at"] = now.Add(f.backoff(retryCount))
+	}
+
+	return bson.M{"$set": set, "$unset": outbox.ReleaseLease()}
+}
+
+// ListDeadLetters returns every dead-lettered event, oldest first
+func (f *EventForwarderImpl) ListDeadLetters(ctx context.Context) ([]OutboxEvent, error) {
+	cursor, err := f.collection.Find(
+		ctx,
+		bson.M{"status": OutboxStatusDeadLetter},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []OutboxEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetDeadLetter returns a single dead-lettered event
+func (f *EventForwarderImpl) GetDeadLetter(ctx context.Context, id primitive.ObjectID) (*OutboxEvent, error) {
+	var event OutboxEvent
+	err := f.collection.FindOne(ctx, bson.M{"_id": id, "status": OutboxStatusDeadLetter}).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrEventNotFound
 		}
+		return nil, err
 	}
+	return &event, nil
+}
+
+// RedriveDeadLetter moves a dead-lettered event back to pending with a fresh retry budget
+func (f *EventForwarderImpl) RedriveDeadLetter(ctx context.Context, id primitive.ObjectID) error {
+	result, err := f.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": OutboxStatusDeadLetter},
+		bson.M{
+			"$set": bson.M{
+				"status":      OutboxStatusPending,
+				"retry_count": 0,
+				"updated_at":  time.Now(),
+			},
+			"$unset": bson.M{
+				"error":            "",
+				"next_attempt_at":  "",
+				"lease_owner":      "",
+				"lease_expires_at": "",
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrEventNotFound
+	}
+	return nil
 }
@@ -0,0 +1,174 @@
+package customers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"app/internal/schema"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errorStatus maps a service error to its HTTP status
+func errorStatus(err error) int {
+	var invalid *schema.ValidationError
+	if errors.As(err, &invalid) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// CreateCustomer handles POST /customers requests
+func (m *Module) CreateCustomer(w http.ResponseWriter, r *http.Request) {
+	var customer Customer
+	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := m.service.CreateCustomer(r.Context(), &customer); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create customer: %v", err), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(customer)
+}
+
+// UpdateCustomer handles PUT /customers/{id} requests
+func (m *Module) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+
+	var customer Customer
+	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	customer.ID = id
+	if err := m.service.UpdateCustomer(r.Context(), &customer); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update customer: %v", err), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(customer)
+}
+
+// GetCustomer handles GET /customers/{id} requests
+func (m *Module) GetCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+
+	customer, err := m.service.FindCustomerByID(r.Context(), id, false)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Customer not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to get customer: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(customer)
+}
+
+// DeleteCustomer handles DELETE /customers/{id} requests
+func (m *Module) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := m.service.SoftDeleteCustomer(r.Context(), id); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete customer: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeadLetters handles GET /customers/outbox/dead-letters requests
+func (m *Module) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	events, err := m.forwarder.ListDeadLetters(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list dead-lettered events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(events)
+}
+
+// GetDeadLetter handles GET /customers/outbox/dead-letters/{id} requests
+func (m *Module) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	event, err := m.forwarder.GetDeadLetter(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrEventNotFound) {
+			http.Error(w, "Dead-lettered event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to get dead-lettered event: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(event)
+}
+
+// RedriveDeadLetter handles POST /customers/outbox/dead-letters/{id}/redrive requests
+func (m *Module) RedriveDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := m.forwarder.RedriveDeadLetter(r.Context(), id); err != nil {
+		if errors.Is(err, ErrEventNotFound) {
+			http.Error(w, "Dead-lettered event not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to redrive event: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// SetupTestData handles POST /setup/testdata requests
+func (m *Module) SetupTestData(w http.ResponseWriter, r *http.Request) {
+	if err := m.setupHandler.SetupTestData(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to setup test data: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Test data setup completed successfully")
+}
+
+// ResetData handles POST /setup/reset requests
+func (m *Module) ResetData(w http.ResponseWriter, r *http.Request) {
+	if err := m.setupHandler.SetupTestData(r.Context()); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to reset data: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Data reset completed successfully")
+}
@@ -0,0 +1,121 @@
+package customers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"app/internal/modulith"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	_ modulith.Module    = (*Module)(nil)
+	_ modulith.Lifecycle = (*Module)(nil)
+)
+
+// Module plugs the customers context into the modulith host
+type Module struct {
+	client          *mongo.Client
+	db              *mongo.Database
+	forwarderConfig ForwarderConfig
+
+	wireOnce     sync.Once
+	repository   *MongoRepository
+	forwarder    *EventForwarderImpl
+	service      *Service
+	projector    *Projector
+	setupHandler *SetupHandler
+}
+
+// NewModule creates an uninitialised customers module
+func NewModule() *Module {
+	return &Module{}
+}
+
+func (m *Module) Name() string {
+	return "customers"
+}
+
+// Init expects "client" (*mongo.Client), "db" (CustomersDB) and "projection_db" (OrderingDB),
+// all on the app's shared client; "forwarder" (ForwarderConfig) is optional
+func (m *Module) Init(config map[string]any) error {
+	client, ok := config["client"].(*mongo.Client)
+	if !ok {
+		return fmt.Errorf("invalid client configuration")
+	}
+	db, ok := config["db"].(*mongo.Database)
+	if !ok {
+		return fmt.Errorf("invalid db configuration")
+	}
+	projectionDB, ok := config["projection_db"].(*mongo.Database)
+	if !ok {
+		return fmt.Errorf("invalid projection_db configuration")
+	}
+
+	m.forwarderConfig = DefaultForwarderConfig()
+	if forwarderConfig, ok := config["forwarder"].(ForwarderConfig); ok {
+		m.forwarderConfig = forwarderConfig
+	}
+
+	m.client = client
+	m.db = db
+	m.setupHandler = NewSetupHandler(db, projectionDB)
+	m.repository = NewMongoRepository(db)
+	// Keeps OrderingDB.projection_customers in step with customer events
+	m.projector = NewProjector(NewMongoProjectionRepository(projectionDB))
+	return nil
+}
+
+// wire builds the forwarder and service once the host has handed over its publisher
+func (m *Module) wire(pub Publisher) {
+	m.wireOnce.Do(func() {
+		m.forwarder = NewEventForwarder(m.db, pub, m.forwarderConfig)
+		// Customer writes and outbox inserts share one transaction
+		m.service = NewService(m.repository, m.forwarder, NewMongoUnitOfWork(m.client))
+	})
+}
+
+func (m *Module) HTTPHandlers(pub Publisher) []modulith.HTTPHandler {
+	m.wire(pub)
+	return []modulith.HTTPHandler{
+		// Customer routes
+		{Method: http.MethodPost, Path: "/customers", Handler: m.CreateCustomer},
+		{Method: http.MethodPut, Path: "/customers/{id}", Handler: m.UpdateCustomer},
+		{Method: http.MethodGet, Path: "/customers/{id}", Handler: m.GetCustomer},
+		{Method: http.MethodDelete, Path: "/customers/{id}", Handler: m.DeleteCustomer},
+
+		// Outbox dead-letter routes
+		{Method: http.MethodGet, Path: "/customers/outbox/dead-letters", Handler: m.ListDeadLetters},
+		{Method: http.MethodGet, Path: "/customers/outbox/dead-letters/{id}", Handler: m.GetDeadLetter},
+		{Method: http.MethodPost, Path: "/customers/outbox/dead-letters/{id}/redrive", Handler: m.RedriveDeadLetter},
+
+		// Setup routes
+		{Method: http.MethodPost, Path: "/setup/testdata", Handler: m.SetupTestData},
+		{Method: http.MethodPost, Path: "/setup/reset", Handler: m.ResetData},
+	}
+}
+
+// MsgHandlers subscribes the customer projector
+func (m *Module) MsgHandlers(pub Publisher) []modulith.MsgHandler {
+	m.wire(pub)
+	return []modulith.MsgHandler{
+		{Subject: ProjectorSubject, Handler: m.projector.HandleMessage},
+	}
+}
+
+// Start starts the outbox forwarder relaying through the host's publisher
+func (m *Module) Start(ctx context.Context) error {
+	if m.forwarder == nil {
+		return fmt.Errorf("module not initialised")
+	}
+	m.forwarder.Start()
+	return nil
+}
+
+// Stop waits for the forwarder's current batch
+func (m *Module) Stop(ctx context.Context) error {
+	return m.forwarder.Stop(ctx)
+}
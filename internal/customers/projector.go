@@ -0,0 +1,123 @@
+package customers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"app/internal/cloudevents"
+	"app/internal/outbox"
+	"app/internal/schema"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ProjectorSubject matches every customer event published by the forwarder
+const ProjectorSubject = EventSubjectPrefix + ">"
+
+// Projector maintains OrderingDB.projection_customers from customer events
+type Projector struct {
+	repository ProjectionRepository
+}
+
+// NewProjector creates a new customer projector
+func NewProjector(repository ProjectionRepository) *Projector {
+	return &Projector{
+		repository: repository,
+	}
+}
+
+// projectedTypes lists the CloudEvents types the projector accepts
+var projectedTypes = []string{
+	EventSubject("CustomerCreated"),
+	EventSubject("CustomerUpdated"),
+	EventSubject("CustomerDeleted"),
+}
+
+// HandleMessage projects a customer CloudEvent received from NATS
+func (p *Projector) HandleMessage(msg *nats.Msg) {
+	event, err := cloudevents.FromMsg(msg)
+	if err == nil {
+		err = event.Expect(EventSource, projectedTypes...)
+	}
+	if err != nil {
+		log.Printf("Error reading customer event on %s: %v", msg.Subject, err)
+		return
+	}
+
+	eventType := strings.TrimPrefix(event.Type, EventSubjectPrefix)
+	meta := outbox.Meta{EventID: event.ID, Sequence: event.Sequence, Time: event.Time}
+	err = p.Project(context.Background(), eventType, event.SchemaVersion, meta, event.Data)
+	if err == outbox.ErrStaleEvent {
+		// Redelivered or overtaken event; the projection is already newer
+		return
+	}
+	if err != nil {
+		log.Printf("Error projecting %s event: %v", eventType, err)
+	}
+}
+
+// Project applies a single customer event to the projection unless it is already at or past meta.Sequence.
+// The payload is validated and upcast from schemaVersion to the current version of its type first.
+func (p *Projector) Project(ctx context.Context, eventType string, schemaVersion int, meta outbox.Meta, payload []byte) error {
+	payload, _, err := schema.Upcast(EventSubject(eventType), schemaVersion, payload)
+	if err != nil {
+		return err
+	}
+
+	switch eventType {
+	case "CustomerCreated", "CustomerUpdated":
+		var customer Customer
+		if err := json.Unmarshal(payload, &customer); err != nil {
+			return fmt.Errorf("invalid customer payload: %v", err)
+		}
+		if customer.ID.IsZero() {
+			return fmt.Errorf("customer payload has no ID")
+		}
+
+		return p.repository.Upsert(ctx, &CustomerProjection{
+			ID:              customer.ID,
+			Name:            customer.Name,
+			Email:           customer.Email,
+			DeliveryAddress: customer.DeliveryAddress,
+			BillingAddress:  customer.BillingAddress,
+			Deleted:         customer.Deleted,
+			CreatedAt:       customer.CreatedAt,
+			UpdatedAt:       customer.UpdatedAt,
+		}, meta)
+
+	case "CustomerDeleted":
+		var deleted struct {
+			ID primitive.ObjectID `json:"id"`
+		}
+		if err := json.Unmarshal(payload, &deleted); err != nil {
+			return fmt.Errorf("invalid customer deleted payload: %v", err)
+		}
+
+		// Stamped with the event's time, so a rebuild replays the same deletion time
+		return p.repository.MarkDeleted(ctx, deleted.ID, meta, meta.Time)
+
+	default:
+		return fmt.Errorf("unknown customer event type %q", eventType)
+	}
+}
+
+// RebuildProjection regenerates OrderingDB.projection_customers by replaying every event in the
+// CustomersDB outbox into a shadow collection and swapping it into place
+func RebuildProjection(ctx context.Context, customersDB, orderingDB *mongo.Database, progress func(outbox.RebuildReport)) (outbox.RebuildReport, error) {
+	rebuild := &outbox.Rebuild[OutboxEvent]{
+		Source: customersDB.Collection("outbox"),
+		Target: orderingDB.Collection("projection_customers"),
+		Apply: func(ctx context.Context, projection *mongo.Collection, event OutboxEvent) error {
+			projector := NewProjector(&MongoProjectionRepository{collection: projection})
+			meta := outbox.Meta{EventID: event.ID.Hex(), Sequence: event.Sequence, Time: event.CreatedAt}
+			return projector.Project(ctx, event.EventType, event.SchemaVersion, meta, event.Payload)
+		},
+		Progress: progress,
+	}
+	return rebuild.Run(ctx)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"app/internal/outbox"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -22,6 +24,32 @@ func NewMongoRepository(db *mongo.Database) *MongoRepository {
 	}
 }
 
+// MongoUnitOfWork implements the UnitOfWork interface with multi-document transactions
+type MongoUnitOfWork struct {
+	client *mongo.Client
+}
+
+// NewMongoUnitOfWork creates a new MongoDB unit of work; the deployment must be a replica set
+func NewMongoUnitOfWork(client *mongo.Client) *MongoUnitOfWork {
+	return &MongoUnitOfWork{
+		client: client,
+	}
+}
+
+// WithTransaction runs fn in a transaction, committing only if it returns nil
+func (u *MongoUnitOfWork) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := u.client.StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
 // Create creates a new customer
 func (r *MongoRepository) Create(ctx context.Context, customer *Customer) error {
 	// Set timestamps if not set
@@ -124,3 +152,56 @@ func (r *MongoRepository) SoftDelete(ctx context.Context, id primitive.ObjectID)
 
 	return nil
 }
+
+// MongoProjectionRepository implements the ProjectionRepository interface
+type MongoProjectionRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoProjectionRepository creates a projection repository on the OrderingDB database
+func NewMongoProjectionRepository(db *mongo.Database) *MongoProjectionRepository {
+	return &MongoProjectionRepository{
+		collection: db.Collection("projection_customers"),
+	}
+}
+
+// Upsert creates or replaces the fields of a customer projection if the event is newer than the stored version
+func (r *MongoProjectionRepository) Upsert(ctx context.Context, projection *CustomerProjection, meta outbox.Meta) error {
+	err := outbox.UpsertVersioned(
+		ctx,
+		r.collection,
+		bson.M{"_id": projection.ID},
+		meta,
+		bson.M{
+			"name":             projection.Name,
+			"email":            projection.Email,
+			"delivery_address": projection.DeliveryAddress,
+			"billing_address":  projection.BillingAddress,
+			"deleted":          projection.Deleted,
+			"created_at":       projection.CreatedAt,
+			"updated_at":       projection.UpdatedAt,
+		},
+	)
+	if err != nil && err != outbox.ErrStaleEvent {
+		return fmt.Errorf("failed to upsert customer projection: %v", err)
+	}
+	return err
+}
+
+// MarkDeleted flags a customer projection as deleted if the event is newer than the stored version
+func (r *MongoProjectionRepository) MarkDeleted(ctx context.Context, id primitive.ObjectID, meta outbox.Meta, deletedAt time.Time) error {
+	err := outbox.UpsertVersioned(
+		ctx,
+		r.collection,
+		bson.M{"_id": id},
+		meta,
+		bson.M{
+			"deleted":    true,
+			"updated_at": deletedAt,
+		},
+	)
+	if err != nil && err != outbox.ErrStaleEvent {
+		return fmt.Errorf("failed to mark customer projection deleted: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package customers
+
+import (
+	"embed"
+
+	"app/internal/schema"
+)
+
+// schemaFiles holds the JSON Schema of every event this context produces, one file per type and version
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+func init() {
+	schema.Default.MustRegisterFS("customers", schemaFiles, "schemas")
+
+	// v2 adds the delivery and billing addresses, unknown for customers written before them
+	for _, eventType := range []string{"CustomerCreated", "CustomerUpdated"} {
+		schema.Default.MustRegisterUpcaster(EventSubject(eventType), 1, schema.ObjectUpcaster(func(fields map[string]any) error {
+			for _, name := range []string{"delivery_address", "billing_address"} {
+				if _, ok := fields[name]; !ok {
+					fields[name] = nil
+				}
+			}
+			return nil
+		}))
+	}
+}
+
+// validateEvent checks the payload of an outbox event against the schema of its type and version
+func validateEvent(event OutboxEvent) error {
+	return schema.Validate(EventSubject(event.EventType), event.SchemaVersion, event.Payload)
+}
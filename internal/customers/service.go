@@ -12,13 +12,15 @@ import (
 type Service struct {
 	repository Repository
 	forwarder  EventForwarder
+	uow        UnitOfWork
 }
 
 // NewService creates a new customer service
-func NewService(repository Repository, forwarder EventForwarder) *Service {
+func NewService(repository Repository, forwarder EventForwarder, uow UnitOfWork) *Service {
 	return &Service{
 		repository: repository,
 		forwarder:  forwarder,
+		uow:        uow,
 	}
 }
 
@@ -29,28 +31,33 @@ func (s *Service) CreateCustomer(ctx context.Context, customer *Customer) error
 	customer.CreatedAt = now
 	customer.UpdatedAt = now
 
-	// Create customer
-	if err := s.repository.Create(ctx, customer); err != nil {
-		return err
-	}
-
-	// Create outbox event
-	payload, err := json.Marshal(customer)
-	if err != nil {
-		return err
-	}
-
-	event := OutboxEvent{
-		ID:         primitive.NewObjectID(),
-		EventType:  "CustomerCreated",
-		Payload:    payload,
-		Status:     "pending",
-		RetryCount: 0,
-		CreatedAt:  now,
-		UpdatedAt:  now,
-	}
-
-	return s.forwarder.Forward(event)
+	// Write the customer and its outbox event atomically
+	return s.uow.WithTransaction(ctx, func(ctx context.Context) error {
+		// Create customer
+		if err := s.repository.Create(ctx, customer); err != nil {
+			return err
+		}
+
+		// Create outbox event
+		payload, err := json.Marshal(customer)
+		if err != nil {
+			return err
+		}
+
+		event := OutboxEvent{
+			ID:            primitive.NewObjectID(),
+			EventType:     "CustomerCreated",
+			AggregateID:   customer.ID.Hex(),
+			Payload:       payload,
+			SchemaVersion: 2,
+			Status:        "pending",
+			RetryCount:    0,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+
+		return s.forwarder.Forward(ctx, event)
+	})
 }
 
 // UpdateCustomer updates an existing customer
@@ -58,28 +65,33 @@ func (s *Service) UpdateCustomer(ctx context.Context, customer *Customer) error
 	// Set update timestamp
 	customer.UpdatedAt = time.Now()
 
-	// Update customer
-	if err := s.repository.Update(ctx, customer); err != nil {
-		return err
-	}
-
-	// Create outbox event
-	payload, err := json.Marshal(customer)
-	if err != nil {
-		return err
-	}
-
-	event := OutboxEvent{
-		ID:         primitive.NewObjectID(),
-		EventType:  "CustomerUpdated",
-		Payload:    payload,
-		Status:     "pending",
-		RetryCount: 0,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-	}
-
-	return s.forwarder.Forward(event)
+	// Write the customer and its outbox event atomically
+	return s.uow.WithTransaction(ctx, func(ctx context.Context) error {
+		// Update customer
+		if err := s.repository.Update(ctx, customer); err != nil {
+			return err
+		}
+
+		// Create outbox event
+		payload, err := json.Marshal(customer)
+		if err != nil {
+			return err
+		}
+
+		event := OutboxEvent{
+			ID:            primitive.NewObjectID(),
+			EventType:     "CustomerUpdated",
+			AggregateID:   customer.ID.Hex(),
+			Payload:       payload,
+			SchemaVersion: 2,
+			Status:        "pending",
+			RetryCount:    0,
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
+		}
+
+		return s.forwarder.Forward(ctx, event)
+	})
 }
 
 // FindCustomerByEmail finds a customer by email
@@ -94,21 +106,26 @@ func (s *Service) FindCustomerByID(ctx context.Context, id primitive.ObjectID, i
 
 // SoftDeleteCustomer soft deletes a customer
 func (s *Service) SoftDeleteCustomer(ctx context.Context, id primitive.ObjectID) error {
-	// Soft delete customer
-	if err := s.repository.SoftDelete(ctx, id); err != nil {
-		return err
-	}
-
-	// Create outbox event
-	event := OutboxEvent{
-		ID:         primitive.NewObjectID(),
-		EventType:  "CustomerDeleted",
-		Payload:    []byte(`{"id":"` + id.Hex() + `"}`),
-		Status:     "pending",
-		RetryCount: 0,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-	}
-
-	return s.forwarder.Forward(event)
+	// Write the deletion and its outbox event atomically
+	return s.uow.WithTransaction(ctx, func(ctx context.Context) error {
+		// Soft delete customer
+		if err := s.repository.SoftDelete(ctx, id); err != nil {
+			return err
+		}
+
+		// Create outbox event
+		event := OutboxEvent{
+			ID:            primitive.NewObjectID(),
+			EventType:     "CustomerDeleted",
+			AggregateID:   id.Hex(),
+			Payload:       []byte(`{"id":"` + id.Hex() + `"}`),
+			SchemaVersion: 1,
+			Status:        "pending",
+			RetryCount:    0,
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
+		}
+
+		return s.forwarder.Forward(ctx, event)
+	})
 }
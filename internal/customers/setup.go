@@ -8,44 +8,39 @@ import (
 	"strings"
 	"time"
 
+	"app/internal/outbox"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // SetupHandler handles test data setup
 type SetupHandler struct {
-	mongoClient *mongo.Client
+	customersDB *mongo.Database
+	orderingDB  *mongo.Database
 }
 
-// NewSetupHandler creates a new setup handler
-func NewSetupHandler(mongoURI string) (*SetupHandler, error) {
-	ctx := context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
-	}
-
+// NewSetupHandler creates a new setup handler on the app's shared client
+func NewSetupHandler(customersDB, orderingDB *mongo.Database) *SetupHandler {
 	return &SetupHandler{
-		mongoClient: client,
-	}, nil
-}
-
-// Close closes the MongoDB connection
-func (h *SetupHandler) Close() error {
-	return h.mongoClient.Disconnect(context.Background())
+		customersDB: customersDB,
+		orderingDB:  orderingDB,
+	}
 }
 
 // SetupTestData sets up test data in MongoDB
 func (h *SetupHandler) SetupTestData(ctx context.Context) error {
 	// Drop existing collections
-	if err := h.mongoClient.Database("CustomersDB").Collection("customers").Drop(ctx); err != nil {
+	if err := h.customersDB.Collection("customers").Drop(ctx); err != nil {
 		return fmt.Errorf("failed to drop customers collection: %v", err)
 	}
-	if err := h.mongoClient.Database("CustomersDB").Collection("outbox").Drop(ctx); err != nil {
+	if err := h.customersDB.Collection("outbox").Drop(ctx); err != nil {
 		return fmt.Errorf("failed to drop outbox collection: %v", err)
 	}
+	if err := h.customersDB.Collection("outbox_sequences").Drop(ctx); err != nil {
+		return fmt.Errorf("failed to drop outbox sequences collection: %v", err)
+	}
 
 	// Create test customers
 	testCustomers := []Customer{
@@ -78,9 +73,22 @@ func (h *SetupHandler) SetupTestData(ctx context.Context) error {
 			UpdatedAt: time.Now(),
 		},
 		{
-			ID:        primitive.NewObjectID(),
-			Name:      "Test User",
-			Email:     "test+label@example.com",
+			ID:    primitive.NewObjectID(),
+			Name:  "Test User",
+			Email: "test+label@example.com",
+			DeliveryAddress: &Address{
+				Line1:      "1 Harbour Street",
+				City:       "Singapore",
+				PostalCode: "049315",
+				Country:    "SG",
+			},
+			BillingAddress: &Address{
+				Line1:      "10 Collyer Quay",
+				Line2:      "#20-01",
+				City:       "Singapore",
+				PostalCode: "049315",
+				Country:    "SG",
+			},
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		},
@@ -124,7 +132,7 @@ func (h *SetupHandler) SetupTestData(ctx context.Context) error {
 	// Insert test customers and create outbox events
 	for _, customer := range testCustomers {
 		// Insert customer
-		_, err := h.mongoClient.Database("CustomersDB").Collection("customers").InsertOne(ctx, customer)
+		_, err := h.customersDB.Collection("customers").InsertOne(ctx, customer)
 		if err != nil {
 			return fmt.Errorf("failed to insert test customer: %v", err)
 		}
@@ -135,17 +143,29 @@ func (h *SetupHandler) SetupTestData(ctx context.Context) error {
 			return fmt.Errorf("failed to marshal customer: %v", err)
 		}
 
+		sequence, err := outbox.NextSequence(ctx, h.customersDB.Collection("outbox_sequences"), customer.ID.Hex())
+		if err != nil {
+			return fmt.Errorf("failed to assign event sequence: %v", err)
+		}
+
 		event := OutboxEvent{
-			ID:         primitive.NewObjectID(),
-			EventType:  "CustomerCreated",
-			Payload:    payload,
-			Status:     "pending",
-			RetryCount: 0,
-			CreatedAt:  time.Now(),
-			UpdatedAt:  time.Now(),
+			ID:            primitive.NewObjectID(),
+			EventType:     "CustomerCreated",
+			AggregateID:   customer.ID.Hex(),
+			Sequence:      sequence,
+			Payload:       payload,
+			SchemaVersion: 2,
+			Status:        "pending",
+			RetryCount:    0,
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
+		}
+
+		if err := validateEvent(event); err != nil {
+			return fmt.Errorf("invalid outbox event: %v", err)
 		}
 
-		_, err = h.mongoClient.Database("CustomersDB").Collection("outbox").InsertOne(ctx, event)
+		_, err = h.customersDB.Collection("outbox").InsertOne(ctx, event)
 		if err != nil {
 			return fmt.Errorf("failed to insert outbox event: %v", err)
 		}
@@ -158,7 +178,7 @@ func (h *SetupHandler) SetupTestData(ctx context.Context) error {
 // GetTestCustomers returns all test customers
 func (h *SetupHandler) GetTestCustomers(ctx context.Context) ([]Customer, error) {
 	var customers []Customer
-	cursor, err := h.mongoClient.Database("CustomersDB").Collection("customers").Find(ctx, bson.M{
+	cursor, err := h.customersDB.Collection("customers").Find(ctx, bson.M{
 		"deleted": bson.M{"$ne": true}, // Filter out deleted customers
 	})
 	if err != nil {
@@ -176,7 +196,7 @@ func (h *SetupHandler) GetTestCustomers(ctx context.Context) ([]Customer, error)
 // GetOutboxEntries returns all outbox entries
 func (h *SetupHandler) GetOutboxEntries(ctx context.Context) ([]OutboxEvent, error) {
 	var entries []OutboxEvent
-	cursor, err := h.mongoClient.Database("CustomersDB").Collection("outbox").Find(ctx, bson.M{})
+	cursor, err := h.customersDB.Collection("outbox").Find(ctx, bson.M{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get outbox entries: %v", err)
 	}
@@ -192,7 +212,7 @@ func (h *SetupHandler) GetOutboxEntries(ctx context.Context) ([]OutboxEvent, err
 // GetCustomerProjections returns all customer projections from OrderingDB
 func (h *SetupHandler) GetCustomerProjections(ctx context.Context) ([]CustomerProjection, error) {
 	var projections []CustomerProjection
-	cursor, err := h.mongoClient.Database("OrderingDB").Collection("projection_customers").Find(ctx, bson.M{})
+	cursor, err := h.orderingDB.Collection("projection_customers").Find(ctx, bson.M{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get customer projections: %v", err)
 	}
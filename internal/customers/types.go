@@ -5,39 +5,73 @@ import (
 	"encoding/json"
 	"time"
 
+	"app/internal/modulith"
+	"app/internal/outbox"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Customer represents a customer in the system
 type Customer struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name      string            `bson:"name" json:"name"`
-	Email     string            `bson:"email" json:"email"`
-	Deleted   bool              `bson:"deleted" json:"deleted"`
-	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time         `bson:"updated_at" json:"updated_at"`
+	ID    primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name  string             `bson:"name" json:"name"`
+	Email string             `bson:"email" json:"email"`
+	// DeliveryAddress and BillingAddress are nil until the customer provides them
+	DeliveryAddress *Address  `bson:"delivery_address" json:"delivery_address"`
+	BillingAddress  *Address  `bson:"billing_address" json:"billing_address"`
+	Deleted         bool      `bson:"deleted" json:"deleted"`
+	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Address is a postal address of a customer
+type Address struct {
+	Line1      string `bson:"line1" json:"line1"`
+	Line2      string `bson:"line2,omitempty" json:"line2,omitempty"`
+	City       string `bson:"city" json:"city"`
+	PostalCode string `bson:"postal_code" json:"postal_code"`
+	// Country is an ISO 3166-1 alpha-2 code
+	Country string `bson:"country" json:"country"`
 }
 
 // OutboxEvent represents an event in the outbox pattern
 type OutboxEvent struct {
-	ID         primitive.ObjectID `bson:"_id" json:"id"`
-	EventType  string            `bson:"event_type" json:"event_type"`
-	Payload    json.RawMessage   `bson:"payload" json:"payload"`
-	Status     string            `bson:"status" json:"status"`
-	RetryCount int32             `bson:"retry_count" json:"retry_count"`
-	Error      string            `bson:"error,omitempty" json:"error,omitempty"`
-	CreatedAt  time.Time         `bson:"created_at" json:"created_at"`
-	UpdatedAt  time.Time         `bson:"updated_at" json:"updated_at"`
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	EventType string             `bson:"event_type" json:"event_type"`
+	// AggregateID is the customer the event belongs to; Sequence orders events per customer
+	AggregateID string `bson:"aggregate_id" json:"aggregate_id"`
+	Sequence    int64  `bson:"sequence" json:"sequence"`
+	// SchemaVersion is the version of the payload's schema for EventType
+	SchemaVersion int             `bson:"schema_version" json:"schema_version"`
+	Payload       json.RawMessage `bson:"payload" json:"payload"`
+	Status        string          `bson:"status" json:"status"`
+	RetryCount    int32           `bson:"retry_count" json:"retry_count"`
+	Error         string          `bson:"error,omitempty" json:"error,omitempty"`
+	// NextAttemptAt is when a failed event becomes eligible for another attempt
+	NextAttemptAt time.Time `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	// LeaseOwner is the forwarder instance currently relaying the event, until LeaseExpiresAt
+	LeaseOwner     string    `bson:"lease_owner,omitempty" json:"lease_owner,omitempty"`
+	LeaseExpiresAt time.Time `bson:"lease_expires_at,omitempty" json:"lease_expires_at,omitempty"`
+	CreatedAt      time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
 }
 
+const (
+	OutboxStatusPending    = "pending"
+	OutboxStatusProcessed  = "processed"
+	OutboxStatusDeadLetter = "dead_letter"
+)
+
 // CustomerProjection represents a customer in the projection store
 type CustomerProjection struct {
-	ID        primitive.ObjectID `bson:"_id" json:"id"`
-	Name      string            `bson:"name" json:"name"`
-	Email     string            `bson:"email" json:"email"`
-	Deleted   bool              `bson:"deleted" json:"deleted"`
-	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time         `bson:"updated_at" json:"updated_at"`
+	ID              primitive.ObjectID `bson:"_id" json:"id"`
+	Name            string             `bson:"name" json:"name"`
+	Email           string             `bson:"email" json:"email"`
+	DeliveryAddress *Address           `bson:"delivery_address" json:"delivery_address"`
+	BillingAddress  *Address           `bson:"billing_address" json:"billing_address"`
+	Deleted         bool               `bson:"deleted" json:"deleted"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
 // Repository defines the interface for customer data operations
@@ -49,9 +83,26 @@ type Repository interface {
 	SoftDelete(ctx context.Context, id primitive.ObjectID) error
 }
 
+// Publisher defines the interface for publishing events to the message broker.
+// It is the host's publisher, shared by every module.
+type Publisher = modulith.Publisher
+
+// ProjectionRepository defines the interface for the customer projection read by other contexts
+// Writes carrying an event that is not newer than the stored version return outbox.ErrStaleEvent.
+type ProjectionRepository interface {
+	Upsert(ctx context.Context, projection *CustomerProjection, meta outbox.Meta) error
+	MarkDeleted(ctx context.Context, id primitive.ObjectID, meta outbox.Meta, deletedAt time.Time) error
+}
+
+// UnitOfWork defines the interface for running operations atomically.
+// Repository and EventForwarder calls made with the context passed to fn take part in the same transaction.
+type UnitOfWork interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
 // EventForwarder defines the interface for forwarding customer events
 type EventForwarder interface {
-	Forward(event OutboxEvent) error
+	Forward(ctx context.Context, event OutboxEvent) error
 	Start()
-	Stop()
+	Stop(ctx context.Context) error
 }
@@ -0,0 +1,62 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"app/internal/money"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrProductNotFound is returned for a product ID missing from the catalogue
+var ErrProductNotFound = errors.New("product not found")
+
+// SaveProduct creates or replaces a catalogue entry
+func (r *Repository) SaveProduct(ctx context.Context, product *Product) error {
+	_, err := r.db.Collection("inventory_products").ReplaceOne(
+		ctx,
+		bson.M{"_id": product.ID},
+		product,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save product: %v", err)
+	}
+	return nil
+}
+
+// GetProduct returns a catalogue entry, or ErrProductNotFound
+func (r *Repository) GetProduct(ctx context.Context, productID string) (*Product, error) {
+	var product Product
+	err := r.db.Collection("inventory_products").FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %v", err)
+	}
+	return &product, nil
+}
+
+// Prices returns the unit price of each of productIDs found in the catalogue; products
+// missing from the catalogue are left out
+func (r *Repository) Prices(ctx context.Context, productIDs []string) (map[string]money.Amount, error) {
+	cursor, err := r.db.Collection("inventory_products").Find(ctx, bson.M{"_id": bson.M{"$in": productIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find prices: %v", err)
+	}
+	var products []Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, fmt.Errorf("failed to decode prices: %v", err)
+	}
+
+	prices := make(map[string]money.Amount, len(products))
+	for _, product := range products {
+		prices[product.ID] = product.UnitPrice
+	}
+	return prices, nil
+}
@@ -3,6 +3,8 @@ package inventory
 import (
 	"time"
 
+	"app/internal/money"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -20,6 +22,14 @@ type OutboxEvent struct {
 	Payload   []byte            `bson:"payload"`
 	CreatedAt time.Time         `bson:"created_at"`
 	Status    string            `bson:"status"`
+	// AggregateID is the product the event belongs to; Sequence orders events per product
+	AggregateID string `bson:"aggregate_id"`
+	Sequence    int64  `bson:"sequence"`
+	// SchemaVersion is the version of the payload schema for EventType
+	SchemaVersion int `bson:"schema_version"`
+	// LeaseOwner is the module instance relaying the event, until LeaseExpiresAt
+	LeaseOwner     string    `bson:"lease_owner,omitempty"`
+	LeaseExpiresAt time.Time `bson:"lease_expires_at,omitempty"`
 }
 
 type InventoryEvent struct {
@@ -43,9 +53,52 @@ type InventoryProjection struct {
 	Quantity  int       `bson:"quantity"`
 	Status    string    `bson:"status"`
 	UpdatedAt time.Time `bson:"updated_at"`
+	// EventID and Version identify the last event applied; Version is its product sequence
+	EventID string `bson:"event_id"`
+	Version int64  `bson:"version"`
 }
 
 const (
 	OutboxStatusPending   = "pending"
 	OutboxStatusProcessed = "processed"
 )
+
+// Reservation holds stock taken out of inventory for an order. The stock returns to inventory
+// when the reservation is released, or when it expires before being committed.
+type Reservation struct {
+	ID        string            `bson:"_id" json:"id"`
+	Items     []ReservationItem `bson:"items" json:"items"`
+	Status    string            `bson:"status" json:"status"`
+	ExpiresAt time.Time         `bson:"expires_at" json:"expires_at"`
+	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+type ReservationItem struct {
+	ProductID string `bson:"product_id" json:"product_id"`
+	Quantity  int    `bson:"quantity" json:"quantity"`
+}
+
+const (
+	ReservationStatusReserved  = "reserved"
+	ReservationStatusCommitted = "committed"
+	ReservationStatusReleased  = "released"
+	ReservationStatusExpired   = "expired"
+)
+
+// Restock records stock returned to inventory, e.g. the items of a refunded order, so a
+// restock ID is only ever applied once
+type Restock struct {
+	ID        string            `bson:"_id" json:"id"`
+	Items     []ReservationItem `bson:"items" json:"items"`
+	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
+}
+
+// Product is an entry of the product catalogue, keyed on its product ID; UnitPrice is the
+// price orders are charged
+type Product struct {
+	ID        string       `json:"product_id" bson:"_id"`
+	Name      string       `json:"name" bson:"name"`
+	UnitPrice money.Amount `json:"unit_price" bson:"unit_price"`
+	UpdatedAt time.Time    `json:"updated_at" bson:"updated_at"`
+}
@@ -1,11 +1,15 @@
 package inventory
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	"app/internal/schema"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -18,34 +22,23 @@ func (m *Module) CreateInventory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	inv.UpdatedAt = time.Now()
-	if err := m.repo.SaveInventory(r.Context(), &inv); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Create outbox event
-	event := InventoryEvent{
-		ProductID: inv.ProductID,
-		Quantity:  inv.Quantity,
-		Status:    inv.Status,
-		UpdatedAt: inv.UpdatedAt,
-	}
 
-	eventData, err := json.Marshal(event)
+	// The event is checked against its schema before anything is written
+	outboxEvent, err := newInventoryOutboxEvent("inventory.created", &inv)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), outboxErrorStatus(err))
 		return
 	}
 
-	outboxEvent := OutboxEvent{
-		EventType: "inventory.created",
-		Payload:   eventData,
-		CreatedAt: time.Now(),
-		Status:    OutboxStatusPending,
-	}
-
-	if err := m.repo.SaveOutboxEvent(r.Context(), outboxEvent); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// The change and its event are written together, so the outbox never misses a change
+	err = m.repo.WithTransaction(r.Context(), func(ctx context.Context) error {
+		if err := m.repo.SaveInventory(ctx, &inv); err != nil {
+			return err
+		}
+		return m.repo.SaveOutboxEvent(ctx, outboxEvent)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), outboxErrorStatus(err))
 		return
 	}
 
@@ -77,34 +70,22 @@ func (m *Module) UpdateInventory(w http.ResponseWriter, r *http.Request) {
 	update.ID = id
 	update.UpdatedAt = time.Now()
 
-	if err := m.repo.SaveInventory(r.Context(), &update); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Create outbox event
-	event := InventoryEvent{
-		ProductID: update.ProductID,
-		Quantity:  update.Quantity,
-		Status:    update.Status,
-		UpdatedAt: update.UpdatedAt,
-	}
-
-	eventData, err := json.Marshal(event)
+	// The event is checked against its schema before anything is written
+	outboxEvent, err := newInventoryOutboxEvent("inventory.updated", &update)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), outboxErrorStatus(err))
 		return
 	}
 
-	outboxEvent := OutboxEvent{
-		EventType: "inventory.updated",
-		Payload:   eventData,
-		CreatedAt: time.Now(),
-		Status:    OutboxStatusPending,
-	}
-
-	if err := m.repo.SaveOutboxEvent(r.Context(), outboxEvent); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// The change and its event are written together, so the outbox never misses a change
+	err = m.repo.WithTransaction(r.Context(), func(ctx context.Context) error {
+		if err := m.repo.SaveInventory(ctx, &update); err != nil {
+			return err
+		}
+		return m.repo.SaveOutboxEvent(ctx, outboxEvent)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), outboxErrorStatus(err))
 		return
 	}
 
@@ -135,3 +116,93 @@ func (m *Module) GetInventory(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(inv)
 }
+
+// SaveProduct handles PUT /products/{id} requests, adding or repricing a catalogue entry
+func (m *Module) SaveProduct(w http.ResponseWriter, r *http.Request) {
+	// Extract ID from path
+	path := strings.Split(r.URL.Path, "/")
+	if len(path) != 3 || path[2] == "" {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	var product Product
+	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if product.Name == "" {
+		http.Error(w, "Missing product name", http.StatusUnprocessableEntity)
+		return
+	}
+	if product.UnitPrice <= 0 {
+		http.Error(w, "Unit price must be positive", http.StatusUnprocessableEntity)
+		return
+	}
+
+	product.ID = path[2]
+	product.UpdatedAt = time.Now()
+	if err := m.repo.SaveProduct(r.Context(), &product); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(product)
+}
+
+// GetProduct handles GET /products/{id} requests
+func (m *Module) GetProduct(w http.ResponseWriter, r *http.Request) {
+	// Extract ID from path
+	path := strings.Split(r.URL.Path, "/")
+	if len(path) != 3 || path[2] == "" {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	product, err := m.repo.GetProduct(r.Context(), path[2])
+	if errors.Is(err, ErrProductNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(product)
+}
+
+// newInventoryOutboxEvent returns the outbox event recording inv, rejecting a payload its schema
+// does not accept with a *schema.ValidationError
+func newInventoryOutboxEvent(eventType string, inv *Inventory) (OutboxEvent, error) {
+	payload, err := json.Marshal(InventoryEvent{
+		ProductID: inv.ProductID,
+		Quantity:  inv.Quantity,
+		Status:    inv.Status,
+		UpdatedAt: inv.UpdatedAt,
+	})
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	if err := schema.Validate(eventType, 1, payload); err != nil {
+		return OutboxEvent{}, err
+	}
+	return OutboxEvent{
+		EventType:     eventType,
+		SchemaVersion: 1,
+		AggregateID:   inv.ProductID,
+		Payload:       payload,
+		CreatedAt:     time.Now(),
+		Status:        OutboxStatusPending,
+	}, nil
+}
+
+// outboxErrorStatus maps an outbox write error to its HTTP status
+func outboxErrorStatus(err error) int {
+	var invalid *schema.ValidationError
+	if errors.As(err, &invalid) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
@@ -10,6 +10,9 @@ import (
 	"testing"
 	"time"
 
+	"app/internal/money"
+	"app/internal/outbox"
+
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/bson"
@@ -112,6 +115,213 @@ func (s *IntegrationTestSuite) TestCreateInventoryIntegration() {
 	s.Equal(inv.Quantity, proj.Quantity)
 }
 
+func (s *IntegrationTestSuite) TestRejectedUpdateLeavesInventoryUnchanged() {
+	ctx := context.Background()
+	inv := &Inventory{ProductID: "PROD123", Quantity: 100, Status: "available", UpdatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	s.Require().NoError(s.repository.SaveInventory(ctx, inv))
+
+	// A negative quantity is rejected by the inventory.updated schema
+	body := `{"product_id":"PROD123","quantity":-5,"status":"available"}`
+	req := httptest.NewRequest(http.MethodPut, "/inventory/"+inv.ID.Hex(), strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.module.UpdateInventory(w, req)
+	s.Equal(http.StatusUnprocessableEntity, w.Code)
+
+	stored, err := s.repository.GetInventory(ctx, inv.ID)
+	s.Require().NoError(err)
+	s.Equal(100, stored.Quantity)
+	count, err := s.mongoConn.Database("inventory_test").Collection("inventory_outbox").CountDocuments(ctx, bson.M{})
+	s.Require().NoError(err)
+	s.Zero(count)
+}
+
+func (s *IntegrationTestSuite) TestRebuildProjectionIntegration() {
+	ctx := context.Background()
+
+	// Two events for the same product, both already processed
+	for i, quantity := range []int{100, 80} {
+		createdAt := time.Now().Add(time.Duration(i) * time.Millisecond)
+		payload, _ := json.Marshal(InventoryEvent{ProductID: "PROD123", Quantity: quantity, Status: "available", UpdatedAt: createdAt})
+		err := s.repository.SaveOutboxEvent(ctx, OutboxEvent{
+			EventType:     "inventory.updated",
+			SchemaVersion: 1,
+			AggregateID:   "PROD123",
+			Payload:       payload,
+			CreatedAt:     createdAt,
+			Status:        OutboxStatusProcessed,
+		})
+		s.Require().NoError(err)
+	}
+
+	report, err := s.repository.RebuildProjection(ctx, nil)
+	s.NoError(err)
+	s.True(report.Done)
+	s.Equal(int64(2), report.Total)
+	s.Equal(int64(2), report.Applied)
+
+	// Verify the rebuilt projection holds the latest version
+	var proj InventoryProjection
+	err = s.mongoConn.Database("inventory_test").Collection("inventory_projections").
+		FindOne(ctx, bson.M{"product_id": "PROD123"}).
+		Decode(&proj)
+	s.NoError(err)
+	s.Equal(80, proj.Quantity)
+	s.Equal(int64(2), proj.Version)
+}
+
+func (s *IntegrationTestSuite) TestIncompleteRebuildLeavesProjection() {
+	ctx := context.Background()
+	db := s.mongoConn.Database("inventory_test")
+	s.Require().NoError(s.repository.EnsureIndexes(ctx))
+	s.Require().NoError(s.repository.UpsertProjection(ctx, &InventoryProjection{ProductID: "PROD123", Quantity: 100, EventID: "event-1", Version: 1}))
+
+	// An event written before its schema was enforced no longer decodes
+	_, err := db.Collection("inventory_outbox").InsertOne(ctx, OutboxEvent{
+		EventType:     "inventory.updated",
+		AggregateID:   "PROD123",
+		Sequence:      1,
+		SchemaVersion: 1,
+		Payload:       []byte(`{"product_id":"PROD123","quantity":-1}`),
+		CreatedAt:     time.Now(),
+		Status:        OutboxStatusProcessed,
+	})
+	s.Require().NoError(err)
+
+	report, err := s.repository.RebuildProjection(ctx, nil)
+	var incomplete *outbox.IncompleteRebuildError
+	s.Require().ErrorAs(err, &incomplete)
+	s.Equal(int64(1), incomplete.Report.Failed)
+	s.False(report.Done)
+
+	// The live projection is untouched and the shadow dropped
+	var proj InventoryProjection
+	s.Require().NoError(db.Collection("inventory_projections").FindOne(ctx, bson.M{"product_id": "PROD123"}).Decode(&proj))
+	s.Equal(100, proj.Quantity)
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": "inventory_projections_rebuild"})
+	s.Require().NoError(err)
+	s.Empty(names)
+}
+
+func (s *IntegrationTestSuite) TestRebuildLockedWhileRunning() {
+	ctx := context.Background()
+	locks := s.mongoConn.Database("inventory_test").Collection("projection_rebuilds")
+
+	// Another rebuild holds the lock
+	_, err := locks.InsertOne(ctx, bson.M{"_id": "inventory_projections", "owner": "other", "expires_at": time.Now().Add(time.Minute)})
+	s.Require().NoError(err)
+	_, err = s.repository.RebuildProjection(ctx, nil)
+	s.ErrorIs(err, outbox.ErrRebuildRunning)
+
+	// Once its lock has lapsed the projection can be rebuilt, and the lock is released after
+	_, err = locks.UpdateByID(ctx, "inventory_projections", bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Second)}})
+	s.Require().NoError(err)
+	report, err := s.repository.RebuildProjection(ctx, nil)
+	s.Require().NoError(err)
+	s.True(report.Done)
+	count, err := locks.CountDocuments(ctx, bson.M{})
+	s.Require().NoError(err)
+	s.Zero(count)
+}
+
+// stock returns the available quantity of a product
+func (s *IntegrationTestSuite) stock(productID string) int {
+	var inv Inventory
+	err := s.mongoConn.Database("inventory_test").Collection("inventory").
+		FindOne(context.Background(), bson.M{"product_id": productID}).
+		Decode(&inv)
+	s.Require().NoError(err)
+	return inv.Quantity
+}
+
+func (s *IntegrationTestSuite) TestReserveReleaseCommitIntegration() {
+	ctx := context.Background()
+	for _, inv := range []*Inventory{{ProductID: "PROD1", Quantity: 5}, {ProductID: "PROD2", Quantity: 1}} {
+		s.Require().NoError(s.repository.SaveInventory(ctx, inv))
+	}
+	expiresAt := time.Now().Add(time.Hour)
+
+	// A short product leaves every product untouched
+	err := s.repository.Reserve(ctx, "reservation-1", map[string]int{"PROD1": 2, "PROD2": 3}, expiresAt)
+	s.ErrorIs(err, ErrInsufficientStock)
+	s.Equal(5, s.stock("PROD1"))
+	s.Equal(1, s.stock("PROD2"))
+
+	// A retried reservation is applied once
+	s.Require().NoError(s.repository.Reserve(ctx, "reservation-2", map[string]int{"PROD1": 2, "PROD2": 1}, expiresAt))
+	s.Require().NoError(s.repository.Reserve(ctx, "reservation-2", map[string]int{"PROD1": 2, "PROD2": 1}, expiresAt))
+	s.Equal(3, s.stock("PROD1"))
+	s.Equal(0, s.stock("PROD2"))
+
+	// Releasing returns the stock, once
+	s.Require().NoError(s.repository.Release(ctx, "reservation-2"))
+	s.Require().NoError(s.repository.Release(ctx, "reservation-2"))
+	s.Equal(5, s.stock("PROD1"))
+	s.Equal(1, s.stock("PROD2"))
+	s.ErrorIs(s.repository.Commit(ctx, "reservation-2"), ErrReservationExpired)
+
+	// A committed reservation outlives its expiry
+	s.Require().NoError(s.repository.Reserve(ctx, "reservation-3", map[string]int{"PROD1": 1}, time.Now().Add(-time.Minute)))
+	s.Require().NoError(s.repository.Commit(ctx, "reservation-3"))
+	s.Require().NoError(s.repository.Commit(ctx, "reservation-3"))
+	expired, err := s.repository.ExpireReservations(ctx, time.Now())
+	s.NoError(err)
+	s.Equal(0, expired)
+	s.Equal(4, s.stock("PROD1"))
+
+	s.ErrorIs(s.repository.Commit(ctx, "reservation-9"), ErrReservationNotFound)
+}
+
+func (s *IntegrationTestSuite) TestRestockIntegration() {
+	ctx := context.Background()
+	s.Require().NoError(s.repository.SaveInventory(ctx, &Inventory{ProductID: "PROD1", Quantity: 5}))
+
+	// A retried restock is applied once
+	s.Require().NoError(s.repository.Restock(ctx, "restock-1", map[string]int{"PROD1": 2}))
+	s.Require().NoError(s.repository.Restock(ctx, "restock-1", map[string]int{"PROD1": 2}))
+	s.Equal(7, s.stock("PROD1"))
+
+	// An unknown product leaves the stock untouched
+	s.Error(s.repository.Restock(ctx, "restock-2", map[string]int{"PROD1": 1, "PROD9": 1}))
+	s.Equal(7, s.stock("PROD1"))
+}
+
+func (s *IntegrationTestSuite) TestExpireReservationsIntegration() {
+	ctx := context.Background()
+	s.Require().NoError(s.repository.SaveInventory(ctx, &Inventory{ProductID: "PROD1", Quantity: 5}))
+
+	s.Require().NoError(s.repository.Reserve(ctx, "reservation-1", map[string]int{"PROD1": 2}, time.Now().Add(-time.Minute)))
+	s.Require().NoError(s.repository.Reserve(ctx, "reservation-2", map[string]int{"PROD1": 1}, time.Now().Add(time.Hour)))
+	s.Equal(2, s.stock("PROD1"))
+
+	// Only the abandoned reservation returns its stock
+	expired, err := s.repository.ExpireReservations(ctx, time.Now())
+	s.NoError(err)
+	s.Equal(1, expired)
+	s.Equal(4, s.stock("PROD1"))
+	s.ErrorIs(s.repository.Commit(ctx, "reservation-1"), ErrReservationExpired)
+
+	// Every stock change is in the outbox for the projections
+	count, err := s.mongoConn.Database("inventory_test").Collection("inventory_outbox").
+		CountDocuments(ctx, bson.M{"aggregate_id": "PROD1", "event_type": "inventory.updated"})
+	s.NoError(err)
+	s.Equal(int64(3), count)
+}
+
+func (s *IntegrationTestSuite) TestCatalogueIntegration() {
+	ctx := context.Background()
+	s.Require().NoError(s.repository.SaveProduct(ctx, &Product{ID: "PROD1", Name: "Widget", UnitPrice: 1050}))
+	s.Require().NoError(s.repository.SaveProduct(ctx, &Product{ID: "PROD2", Name: "Gadget", UnitPrice: 200}))
+	// Repricing replaces the entry
+	s.Require().NoError(s.repository.SaveProduct(ctx, &Product{ID: "PROD2", Name: "Gadget", UnitPrice: 250}))
+
+	prices, err := s.repository.Prices(ctx, []string{"PROD1", "PROD2", "PROD9"})
+	s.NoError(err)
+	s.Equal(map[string]money.Amount{"PROD1": 1050, "PROD2": 250}, prices)
+
+	_, err = s.repository.GetProduct(ctx, "PROD9")
+	s.ErrorIs(err, ErrProductNotFound)
+}
+
 func TestIntegrationSuite(t *testing.T) {
 	suite.Run(t, new(IntegrationTestSuite))
 }
@@ -3,8 +3,18 @@ package inventory
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"app/internal/cloudevents"
+	"app/internal/modulith"
+	"app/internal/outbox"
+	"app/internal/schema"
 
 	"github.com/nats-io/nats.go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,38 +22,84 @@ import (
 )
 
 type RepositoryInterface interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
 	SaveInventory(ctx context.Context, inv *Inventory) error
 	GetInventory(ctx context.Context, id primitive.ObjectID) (*Inventory, error)
 	SaveOutboxEvent(ctx context.Context, event OutboxEvent) error
-	GetPendingOutboxEvents(ctx context.Context) ([]OutboxEvent, error)
+	RelayOutboxEvents(ctx context.Context, owner string, lease time.Duration, limit int, publish func(ctx context.Context, event OutboxEvent) error) error
 	UpdateOutboxEvent(ctx context.Context, event OutboxEvent) error
 	UpsertProjection(ctx context.Context, proj *InventoryProjection) error
+	ExpireReservations(ctx context.Context, now time.Time) (int, error)
+	SaveProduct(ctx context.Context, product *Product) error
+	GetProduct(ctx context.Context, productID string) (*Product, error)
+}
+
+const (
+	// EventSource is the CloudEvents source of every inventory event
+	EventSource = "/inventory"
+
+	// outboxWatchRetry is the pause before a failed change stream is reopened
+	outboxWatchRetry = 5 * time.Second
+)
+
+// RelayConfig controls how the module relays its outbox
+type RelayConfig struct {
+	// Mode adds a change-stream relay to the poll loop when set to outbox.ModeChangeStream
+	Mode         outbox.Mode
+	PollInterval time.Duration
+	// LeaseDuration is how long a claimed event is reserved for this instance
+	LeaseDuration time.Duration
+	// BatchSize is the number of products relayed per run, Concurrency how many at once
+	BatchSize   int
+	Concurrency int
+	// Encoding selects structured or binary CloudEvents messages
+	Encoding cloudevents.Mode
+}
+
+// DefaultRelayConfig returns the relay settings used when none are supplied
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		Mode:          outbox.ModePolling,
+		PollInterval:  5 * time.Second,
+		LeaseDuration: 30 * time.Second,
+		BatchSize:     100,
+		Concurrency:   10,
+		Encoding:      cloudevents.Structured,
+	}
 }
 
 type Module struct {
 	repo      RepositoryInterface
 	natsConn  *nats.Conn
 	publisher Publisher
+	watcher   *outbox.Watcher
+	relay     RelayConfig
+	// instanceID owns the outbox leases taken by this module instance
+	instanceID string
+	// relayMu serialises outbox runs triggered by NATS and by the change stream
+	relayMu sync.Mutex
+	// stopRelay cancels the relay loops started by Start; relaying tracks them until they return
+	stopRelay context.CancelFunc
+	relaying  sync.WaitGroup
 }
 
-type Publisher interface {
-	Publish(subject string, data []byte) error
-}
-
-type HTTPHandler struct {
-	Method  string
-	Path    string
-	Handler http.HandlerFunc
-}
+// Publisher, HTTPHandler and MsgHandler are the host's types, so Module plugs into modulith.Host
+type (
+	Publisher   = modulith.Publisher
+	HTTPHandler = modulith.HTTPHandler
+	MsgHandler  = modulith.MsgHandler
+)
 
-type MsgHandler struct {
-	Subject string
-	Handler nats.MsgHandler
-}
+var (
+	_ modulith.Module    = (*Module)(nil)
+	_ modulith.Lifecycle = (*Module)(nil)
+)
 
 func NewModule(natsConn *nats.Conn) *Module {
 	return &Module{
-		natsConn: natsConn,
+		natsConn:   natsConn,
+		relay:      DefaultRelayConfig(),
+		instanceID: outbox.NewOwnerID(),
 	}
 }
 
@@ -51,10 +107,21 @@ func (m *Module) Name() string {
 	return "inventory"
 }
 
+// Init expects "db" (InventoryDB) on the app's shared client; "relay" (RelayConfig) is optional
 func (m *Module) Init(config map[string]any) error {
+	if relay, ok := config["relay"].(RelayConfig); ok {
+		m.relay = relay
+	}
+
 	// Initialize MongoDB repository
 	if db, ok := config["db"].(*mongo.Database); ok {
-		m.repo = NewRepository(db)
+		repo := NewRepository(db)
+		repo.relayConcurrency = m.relay.Concurrency
+		if err := repo.EnsureIndexes(context.Background()); err != nil {
+			return fmt.Errorf("failed to create inventory indexes: %v", err)
+		}
+		m.repo = repo
+		m.watcher = outbox.NewWatcher("inventory.outbox", db.Collection("inventory_outbox"))
 		return nil
 	}
 	return fmt.Errorf("invalid db configuration")
@@ -78,6 +145,16 @@ func (m *Module) HTTPHandlers(pub Publisher) []HTTPHandler {
 			Path:    "/inventory/{id}",
 			Handler: m.GetInventory,
 		},
+		{
+			Method:  http.MethodPut,
+			Path:    "/products/{id}",
+			Handler: m.SaveProduct,
+		},
+		{
+			Method:  http.MethodGet,
+			Path:    "/products/{id}",
+			Handler: m.GetProduct,
+		},
 	}
 }
 
@@ -108,46 +185,221 @@ func (m *Module) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			m.UpdateInventory(w, r)
 			return
 		}
+		if strings.HasPrefix(r.URL.Path, "/products/") {
+			m.SaveProduct(w, r)
+			return
+		}
 	case http.MethodGet:
 		if len(r.URL.Path) > 10 && r.URL.Path[:10] == "/inventory/" {
 			m.GetInventory(w, r)
 			return
 		}
+		if strings.HasPrefix(r.URL.Path, "/products/") {
+			m.GetProduct(w, r)
+			return
+		}
 	}
 	http.NotFound(w, r)
 }
 
 // ProcessOutboxEvents processes pending events from the outbox
 func (m *Module) ProcessOutboxEvents(msg *nats.Msg) {
-	ctx := context.Background()
-	events, err := m.repo.GetPendingOutboxEvents(ctx)
-	if err != nil {
-		// Log error
-		return
+	m.processOutbox(context.Background())
+}
+
+// WatchOutbox relays outbox events as soon as they are written, resuming from the
+// last persisted change-stream position; it blocks until ctx is cancelled.
+// On a standalone mongod it returns an error matched by outbox.IsChangeStreamUnsupported,
+// leaving the poll loop and the "inventory.outbox" subscription to relay events.
+func (m *Module) WatchOutbox(ctx context.Context) error {
+	if m.watcher == nil {
+		return fmt.Errorf("module not initialised")
 	}
+	return m.watcher.Run(ctx, func(ctx context.Context, _ primitive.ObjectID) error {
+		// Cancelling the watch lets the batch in flight finish
+		m.processOutbox(context.WithoutCancel(ctx))
+		return nil
+	})
+}
 
-	for _, event := range events {
-		// Publish to appropriate NATS subject for projection
-		if err := m.publisher.Publish("inventory.projection.update", event.Payload); err != nil {
-			continue
+// Start relays the outbox in the background until Stop is called: a poll loop always runs,
+// and in change-stream mode a watcher relays new events as soon as they are written
+func (m *Module) Start(ctx context.Context) error {
+	if m.watcher == nil {
+		return fmt.Errorf("module not initialised")
+	}
+
+	relayCtx, cancel := context.WithCancel(context.Background())
+	m.stopRelay = cancel
+
+	m.relaying.Add(1)
+	go func() {
+		defer m.relaying.Done()
+		m.pollOutbox(relayCtx)
+	}()
+
+	if m.relay.Mode == outbox.ModeChangeStream {
+		m.relaying.Add(1)
+		go func() {
+			defer m.relaying.Done()
+			m.watchOutbox(relayCtx)
+		}()
+	}
+	return nil
+}
+
+// Stop ends the relay loops and waits for the outbox batch in flight, whichever trigger
+// started it, returning ctx's error if the deadline passes first
+func (m *Module) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		if m.stopRelay != nil {
+			m.stopRelay()
 		}
+		m.relaying.Wait()
+		m.relayMu.Lock()
+		m.relayMu.Unlock()
+		close(done)
+	}()
 
-		// Mark as processed
-		event.Status = OutboxStatusProcessed
-		m.repo.UpdateOutboxEvent(ctx, event)
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+func (m *Module) pollOutbox(ctx context.Context) {
+	ticker := time.NewTicker(m.relay.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The batch runs to completion even when Stop is called meanwhile
+			m.processOutbox(context.WithoutCancel(ctx))
+			m.expireReservations(context.WithoutCancel(ctx))
+		}
+	}
+}
+
+// watchOutbox restarts the change stream after errors, leaving the poll loop as the only
+// relay when change streams are unavailable
+func (m *Module) watchOutbox(ctx context.Context) {
+	for {
+		err := m.WatchOutbox(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if outbox.IsChangeStreamUnsupported(err) {
+			log.Printf("Change streams unavailable, inventory outbox falling back to polling: %v", err)
+			return
+		}
+		log.Printf("Error watching inventory outbox, restarting: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(outboxWatchRetry):
+		}
+	}
+}
+
+func (m *Module) processOutbox(ctx context.Context) {
+	m.relayMu.Lock()
+	defer m.relayMu.Unlock()
+
+	// Lease events so other instances skip them; products are relayed in parallel, events of one product in order
+	err := m.repo.RelayOutboxEvents(ctx, m.instanceID, m.relay.LeaseDuration, m.relay.BatchSize, m.publishOutboxEvent)
+	if err != nil {
+		log.Printf("Error relaying inventory outbox: %v", err)
+	}
+}
+
+// expireReservations returns the stock of abandoned reservations to inventory; the stock
+// updates are relayed with the next outbox batch
+func (m *Module) expireReservations(ctx context.Context) {
+	expired, err := m.repo.ExpireReservations(ctx, time.Now())
+	if err != nil {
+		log.Printf("Error expiring inventory reservations: %v", err)
+	}
+	if expired > 0 {
+		log.Printf("Expired %d inventory reservations", expired)
+	}
+}
+
+// publishOutboxEvent publishes a leased event; an error holds back later events of the product
+func (m *Module) publishOutboxEvent(ctx context.Context, event OutboxEvent) error {
+	// Publish to appropriate NATS subject for projection as a CloudEvent
+	msg, err := cloudevents.NewMsg("inventory.projection.update", newCloudEvent(event), m.relay.Encoding)
+	if err != nil {
+		return err
+	}
+	if err := m.publisher.PublishMsg(msg); err != nil {
+		// Left leased; it is retried once the lease expires
+		return err
+	}
+
+	// Mark as processed
+	event.Status = OutboxStatusProcessed
+	return m.repo.UpdateOutboxEvent(ctx, event)
+}
+
+// newCloudEvent wraps an outbox event in its CloudEvents envelope
+func newCloudEvent(event OutboxEvent) cloudevents.Event {
+	ce := cloudevents.New(event.ID.Hex(), EventSource, event.EventType, event.Payload)
+	ce.Subject = event.AggregateID
+	ce.Time = event.CreatedAt.UTC()
+	ce.Sequence = event.Sequence
+	ce.SchemaVersion = event.SchemaVersion
+	if ce.SchemaVersion == 0 {
+		// Written before schema versions were recorded
+		ce.SchemaVersion = 1
+	}
+	return ce
+}
+
+// decodeInventoryEvent validates a payload, upcasts it to the current schema version of
+// its event type and decodes it, so consumers and replay never see an old payload shape
+func decodeInventoryEvent(eventType string, schemaVersion int, payload []byte) (InventoryEvent, error) {
+	var event InventoryEvent
+	payload, _, err := schema.Upcast(eventType, schemaVersion, payload)
+	if err != nil {
+		return event, err
+	}
+	err = json.Unmarshal(payload, &event)
+	return event, err
+}
+
 // HandleInventoryProjection updates the inventory projection
 func (m *Module) HandleInventoryProjection(msg *nats.Msg) {
-	var event InventoryEvent
-	if err := json.Unmarshal(msg.Data, &event); err != nil {
+	ce, err := cloudevents.FromMsg(msg)
+	if err == nil {
+		err = ce.Expect(EventSource, "inventory.created", "inventory.updated")
+	}
+	if err != nil {
+		log.Printf("Error reading inventory event on %s: %v", msg.Subject, err)
 		return
 	}
+	event, err := decodeInventoryEvent(ce.Type, ce.SchemaVersion, ce.Data)
+	if err != nil {
+		log.Printf("Error decoding %s event %s: %v", ce.Type, ce.ID, err)
+		return
+	}
+
+	proj := event.ToProjection()
+	proj.EventID = ce.ID
+	proj.Version = ce.Sequence
 
-	ctx := context.Background()
-	if err := m.repo.UpsertProjection(ctx, event.ToProjection()); err != nil {
-		// Log error
+	err = m.repo.UpsertProjection(context.Background(), proj)
+	if errors.Is(err, outbox.ErrStaleEvent) {
+		// Redelivered or overtaken event; the projection is already newer
 		return
 	}
+	if err != nil {
+		log.Printf("Error projecting %s event %s: %v", ce.Type, ce.ID, err)
+	}
 }
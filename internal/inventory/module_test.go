@@ -1,13 +1,22 @@
 package inventory
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"app/internal/cloudevents"
+	"app/internal/money"
+	"app/internal/outbox"
+
+	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,6 +27,11 @@ type MockRepository struct {
 	mock.Mock
 }
 
+// WithTransaction runs fn directly; the mock has no transactions to roll back
+func (m *MockRepository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
 func (m *MockRepository) SaveInventory(ctx context.Context, inv *Inventory) error {
 	args := m.Called(ctx, inv)
 	return args.Error(0)
@@ -36,9 +50,15 @@ func (m *MockRepository) SaveOutboxEvent(ctx context.Context, event OutboxEvent)
 	return args.Error(0)
 }
 
-func (m *MockRepository) GetPendingOutboxEvents(ctx context.Context) ([]OutboxEvent, error) {
-	args := m.Called(ctx)
-	return args.Get(0).([]OutboxEvent), args.Error(1)
+// RelayOutboxEvents hands the events returned by the expectation to publish, in order
+func (m *MockRepository) RelayOutboxEvents(ctx context.Context, owner string, lease time.Duration, limit int, publish func(ctx context.Context, event OutboxEvent) error) error {
+	args := m.Called(ctx, owner, lease, limit)
+	for _, event := range args.Get(0).([]OutboxEvent) {
+		if err := publish(ctx, event); err != nil {
+			break
+		}
+	}
+	return args.Error(1)
 }
 
 func (m *MockRepository) UpdateOutboxEvent(ctx context.Context, event OutboxEvent) error {
@@ -51,6 +71,24 @@ func (m *MockRepository) UpsertProjection(ctx context.Context, proj *InventoryPr
 	return args.Error(0)
 }
 
+func (m *MockRepository) ExpireReservations(ctx context.Context, now time.Time) (int, error) {
+	args := m.Called(ctx, now)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) SaveProduct(ctx context.Context, product *Product) error {
+	args := m.Called(ctx, product)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetProduct(ctx context.Context, productID string) (*Product, error) {
+	args := m.Called(ctx, productID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Product), args.Error(1)
+}
+
 // MockPublisher is a mock implementation of the Publisher interface
 type MockPublisher struct {
 	mock.Mock
@@ -61,6 +99,11 @@ func (m *MockPublisher) Publish(subject string, data []byte) error {
 	return args.Error(0)
 }
 
+func (m *MockPublisher) PublishMsg(msg *nats.Msg) error {
+	args := m.Called(msg.Subject, msg.Data)
+	return args.Error(0)
+}
+
 func TestCreateInventory(t *testing.T) {
 	mockRepo := &MockRepository{}
 	mockPub := &MockPublisher{}
@@ -111,13 +154,33 @@ func TestCreateInventory(t *testing.T) {
 	})
 }
 
+func TestRejectedInventoryEventWritesNothing(t *testing.T) {
+	mockRepo := &MockRepository{}
+	module := &Module{repo: mockRepo}
+
+	// A negative quantity is rejected by the event schemas
+	body := `{"product_id":"PROD123","quantity":-1,"status":"available"}`
+	w := httptest.NewRecorder()
+	module.CreateInventory(w, httptest.NewRequest(http.MethodPost, "/inventory", strings.NewReader(body)))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	module.UpdateInventory(w, httptest.NewRequest(http.MethodPut, "/inventory/"+primitive.NewObjectID().Hex(), strings.NewReader(body)))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	mockRepo.AssertNotCalled(t, "SaveInventory", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "SaveOutboxEvent", mock.Anything, mock.Anything)
+}
+
 func TestProcessOutboxEvents(t *testing.T) {
 	mockRepo := &MockRepository{}
 	mockPub := &MockPublisher{}
 
 	module := &Module{
-		repo:      mockRepo,
-		publisher: mockPub,
+		repo:       mockRepo,
+		publisher:  mockPub,
+		relay:      DefaultRelayConfig(),
+		instanceID: "instance-1",
 	}
 
 	t.Run("successful processing", func(t *testing.T) {
@@ -131,8 +194,8 @@ func TestProcessOutboxEvents(t *testing.T) {
 		}
 
 		// Setup expectations
-		mockRepo.On("GetPendingOutboxEvents", mock.Anything).Return(events, nil)
-		mockPub.On("Publish", "inventory.projection.update", mock.Anything).Return(nil)
+		mockRepo.On("RelayOutboxEvents", mock.Anything, "instance-1", 30*time.Second, 100).Return(events, nil)
+		mockPub.On("PublishMsg", "inventory.projection.update", mock.Anything).Return(nil)
 		mockRepo.On("UpdateOutboxEvent", mock.Anything, mock.MatchedBy(func(e OutboxEvent) bool {
 			return e.Status == OutboxStatusProcessed
 		})).Return(nil)
@@ -145,3 +208,262 @@ func TestProcessOutboxEvents(t *testing.T) {
 		mockPub.AssertExpectations(t)
 	})
 }
+
+func TestPublishOutboxEventEncoding(t *testing.T) {
+	event := OutboxEvent{
+		ID:            primitive.NewObjectID(),
+		EventType:     "inventory.updated",
+		AggregateID:   "PROD123",
+		Sequence:      1,
+		SchemaVersion: 1,
+		Payload:       []byte(`{"product_id":"PROD123","quantity":90}`),
+		LeaseOwner:    "instance-1",
+	}
+
+	for _, mode := range []cloudevents.Mode{cloudevents.Structured, cloudevents.Binary} {
+		mockRepo := &MockRepository{}
+		mockPub := &MockPublisher{}
+		relay := DefaultRelayConfig()
+		relay.Encoding = mode
+		module := &Module{repo: mockRepo, publisher: mockPub, relay: relay}
+
+		// Binary messages carry only the payload in their body
+		mockPub.On("PublishMsg", "inventory.projection.update", mock.MatchedBy(func(data []byte) bool {
+			return (string(data) == string(event.Payload)) == (mode == cloudevents.Binary)
+		})).Return(nil)
+		mockRepo.On("UpdateOutboxEvent", mock.Anything, mock.Anything).Return(nil)
+
+		assert.NoError(t, module.publishOutboxEvent(context.Background(), event), mode)
+		mockPub.AssertExpectations(t)
+	}
+}
+
+func TestProcessOutboxEventsHoldsBackAggregate(t *testing.T) {
+	mockRepo := &MockRepository{}
+	mockPub := &MockPublisher{}
+
+	module := &Module{
+		repo:       mockRepo,
+		publisher:  mockPub,
+		relay:      DefaultRelayConfig(),
+		instanceID: "instance-1",
+	}
+
+	events := []OutboxEvent{
+		{
+			ID:          primitive.NewObjectID(),
+			EventType:   "inventory.created",
+			AggregateID: "PROD123",
+			Sequence:    1,
+			Payload:     []byte(`{"product_id":"PROD123","quantity":100}`),
+			Status:      OutboxStatusPending,
+		},
+		{
+			ID:          primitive.NewObjectID(),
+			EventType:   "inventory.updated",
+			AggregateID: "PROD123",
+			Sequence:    2,
+			Payload:     []byte(`{"product_id":"PROD123","quantity":90}`),
+			Status:      OutboxStatusPending,
+		},
+	}
+
+	// Setup expectations: the first event is not acknowledged
+	mockRepo.On("RelayOutboxEvents", mock.Anything, "instance-1", 30*time.Second, 100).Return(events, nil)
+	mockPub.On("PublishMsg", "inventory.projection.update", mock.Anything).Return(assert.AnError).Once()
+
+	// Execute
+	module.ProcessOutboxEvents(nil)
+
+	// Assert the second event was neither published nor marked processed
+	mockPub.AssertNumberOfCalls(t, "PublishMsg", 1)
+	mockRepo.AssertNotCalled(t, "UpdateOutboxEvent", mock.Anything, mock.Anything)
+}
+
+func TestHandleInventoryProjection(t *testing.T) {
+	mockRepo := &MockRepository{}
+	module := &Module{repo: mockRepo}
+
+	t.Run("applies event version", func(t *testing.T) {
+		event := cloudevents.New("event-2", EventSource, "inventory.updated", []byte(`{"product_id":"PROD123","quantity":90,"status":"in_stock","updated_at":"2024-01-01T00:00:00Z"}`))
+		event.Sequence = 2
+		event.SchemaVersion = 1
+		msg, err := cloudevents.NewMsg("inventory.projection.update", event, cloudevents.Structured)
+		assert.NoError(t, err)
+
+		mockRepo.On("UpsertProjection", mock.Anything, mock.MatchedBy(func(p *InventoryProjection) bool {
+			return p.ProductID == "PROD123" && p.Quantity == 90 && p.EventID == "event-2" && p.Version == 2
+		})).Return(nil).Once()
+
+		module.HandleInventoryProjection(msg)
+
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("skips stale event", func(t *testing.T) {
+		// The projection already reflects sequence 2, so the redelivered event is dropped
+		event := cloudevents.New("event-1", EventSource, "inventory.updated", []byte(`{"product_id":"PROD123","quantity":100,"status":"in_stock","updated_at":"2024-01-01T00:00:00Z"}`))
+		event.Sequence = 1
+		event.SchemaVersion = 1
+		msg, err := cloudevents.NewMsg("inventory.projection.update", event, cloudevents.Structured)
+		assert.NoError(t, err)
+
+		mockRepo.On("UpsertProjection", mock.Anything, mock.MatchedBy(func(p *InventoryProjection) bool {
+			return p.EventID == "event-1" && p.Version == 1
+		})).Return(outbox.ErrStaleEvent).Once()
+
+		module.HandleInventoryProjection(msg)
+
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNumberOfCalls(t, "UpsertProjection", 2)
+	})
+
+	t.Run("drops event from another source", func(t *testing.T) {
+		event := cloudevents.New("event-3", "/customers", "inventory.updated", []byte(`{"product_id":"PROD123","quantity":80,"status":"in_stock","updated_at":"2024-01-01T00:00:00Z"}`))
+		event.Sequence = 3
+		event.SchemaVersion = 1
+		msg, err := cloudevents.NewMsg("inventory.projection.update", event, cloudevents.Binary)
+		assert.NoError(t, err)
+
+		module.HandleInventoryProjection(msg)
+
+		mockRepo.AssertNumberOfCalls(t, "UpsertProjection", 2)
+	})
+
+	t.Run("drops payload that breaks its schema", func(t *testing.T) {
+		event := cloudevents.New("event-4", EventSource, "inventory.updated", []byte(`{"product_id":"PROD123","quantity":-5}`))
+		event.Sequence = 4
+		event.SchemaVersion = 1
+		msg, err := cloudevents.NewMsg("inventory.projection.update", event, cloudevents.Structured)
+		assert.NoError(t, err)
+
+		module.HandleInventoryProjection(msg)
+
+		mockRepo.AssertNumberOfCalls(t, "UpsertProjection", 2)
+	})
+
+	t.Run("drops message without an envelope", func(t *testing.T) {
+		module.HandleInventoryProjection(&nats.Msg{
+			Subject: "inventory.projection.update",
+			Data:    []byte(`{"product_id":"PROD123","quantity":90}`),
+		})
+
+		mockRepo.AssertNumberOfCalls(t, "UpsertProjection", 2)
+	})
+
+	t.Run("logs failed write", func(t *testing.T) {
+		var logged bytes.Buffer
+		log.SetOutput(&logged)
+		defer log.SetOutput(os.Stderr)
+
+		event := cloudevents.New("event-5", EventSource, "inventory.updated", []byte(`{"product_id":"PROD123","quantity":70,"status":"in_stock","updated_at":"2024-01-01T00:00:00Z"}`))
+		event.Sequence = 5
+		event.SchemaVersion = 1
+		msg, err := cloudevents.NewMsg("inventory.projection.update", event, cloudevents.Structured)
+		assert.NoError(t, err)
+
+		mockRepo.On("UpsertProjection", mock.Anything, mock.MatchedBy(func(p *InventoryProjection) bool {
+			return p.EventID == "event-5"
+		})).Return(assert.AnError).Once()
+
+		module.HandleInventoryProjection(msg)
+
+		assert.Contains(t, logged.String(), "Error projecting inventory.updated event event-5")
+	})
+}
+
+func TestStopWaitsForRelayBatch(t *testing.T) {
+	module := &Module{}
+
+	// A relay batch is in flight
+	module.relayMu.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, module.Stop(ctx), context.DeadlineExceeded)
+
+	// Once the batch finishes Stop returns
+	module.relayMu.Unlock()
+	assert.NoError(t, module.Stop(context.Background()))
+}
+
+func TestPollLoopExpiresReservations(t *testing.T) {
+	mockRepo := &MockRepository{}
+	relay := DefaultRelayConfig()
+	relay.PollInterval = time.Millisecond
+	module := &Module{repo: mockRepo, relay: relay, instanceID: "instance-1"}
+
+	expired := make(chan struct{}, 1)
+	mockRepo.On("RelayOutboxEvents", mock.Anything, "instance-1", 30*time.Second, 100).Return([]OutboxEvent{}, nil)
+	mockRepo.On("ExpireReservations", mock.Anything, mock.AnythingOfType("time.Time")).Return(2, nil).Run(func(mock.Arguments) {
+		select {
+		case expired <- struct{}{}:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		module.pollOutbox(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("reservations were not expired")
+	}
+	cancel()
+	<-done
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProductCatalogue(t *testing.T) {
+	mockRepo := &MockRepository{}
+	module := &Module{repo: mockRepo}
+
+	t.Run("saves a product", func(t *testing.T) {
+		mockRepo.On("SaveProduct", mock.Anything, mock.MatchedBy(func(p *Product) bool {
+			return p.ID == "PROD123" && p.Name == "Widget" && p.UnitPrice == money.Amount(1050)
+		})).Return(nil).Once()
+
+		req := httptest.NewRequest(http.MethodPut, "/products/PROD123", strings.NewReader(`{"name":"Widget","unit_price":1050}`))
+		w := httptest.NewRecorder()
+		module.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"product_id":"PROD123"`)
+	})
+
+	t.Run("rejects a product without a price", func(t *testing.T) {
+		for _, body := range []string{`{"name":"Widget"}`, `{"name":"Widget","unit_price":-1}`, `{"unit_price":1050}`} {
+			req := httptest.NewRequest(http.MethodPut, "/products/PROD123", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			module.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, body)
+		}
+		// Prices are whole minor units
+		req := httptest.NewRequest(http.MethodPut, "/products/PROD123", strings.NewReader(`{"name":"Widget","unit_price":10.50}`))
+		w := httptest.NewRecorder()
+		module.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("gets a product", func(t *testing.T) {
+		mockRepo.On("GetProduct", mock.Anything, "PROD123").Return(&Product{ID: "PROD123", Name: "Widget", UnitPrice: 1050}, nil).Once()
+		mockRepo.On("GetProduct", mock.Anything, "PROD999").Return(nil, ErrProductNotFound).Once()
+
+		w := httptest.NewRecorder()
+		module.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/PROD123", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"unit_price":1050`)
+
+		w = httptest.NewRecorder()
+		module.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/PROD999", nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	mockRepo.AssertExpectations(t)
+}
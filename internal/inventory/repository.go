@@ -2,6 +2,10 @@ package inventory
 
 import (
 	"context"
+	"time"
+
+	"app/internal/outbox"
+	"app/internal/schema"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,10 +15,12 @@ import (
 
 type Repository struct {
 	db *mongo.Database
+	// relayConcurrency is the number of products RelayOutboxEvents relays at once
+	relayConcurrency int
 }
 
 func NewRepository(db *mongo.Database) *Repository {
-	return &Repository{db: db}
+	return &Repository{db: db, relayConcurrency: DefaultRelayConfig().Concurrency}
 }
 
 // SaveInventory creates or updates an inventory item
@@ -42,49 +48,112 @@ func (r *Repository) GetInventory(ctx context.Context, id primitive.ObjectID) (*
 	return &inv, nil
 }
 
-// SaveOutboxEvent saves an event to the outbox
+// SaveOutboxEvent saves an event to the outbox with the next sequence number of its product.
+// The sequence is taken in the transaction that inserts the event, joining the caller's when
+// there is one, so a product's events become visible to the relay in sequence order.
 func (r *Repository) SaveOutboxEvent(ctx context.Context, event OutboxEvent) error {
+	if err := schema.Validate(event.EventType, event.SchemaVersion, event.Payload); err != nil {
+		return err
+	}
 	if event.ID.IsZero() {
 		event.ID = primitive.NewObjectID()
 	}
-	_, err := r.db.Collection("inventory_outbox").InsertOne(ctx, event)
-	return err
-}
 
-// GetPendingOutboxEvents retrieves all pending events from the outbox
-func (r *Repository) GetPendingOutboxEvents(ctx context.Context) ([]OutboxEvent, error) {
-	cursor, err := r.db.Collection("inventory_outbox").Find(ctx, bson.M{
-		"status": OutboxStatusPending,
+	return r.WithTransaction(ctx, func(ctx context.Context) error {
+		sequence, err := outbox.NextSequence(ctx, r.db.Collection("inventory_outbox_sequences"), event.AggregateID)
+		if err != nil {
+			return err
+		}
+		event.Sequence = sequence
+
+		_, err = r.db.Collection("inventory_outbox").InsertOne(ctx, event)
+		return err
 	})
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
+}
 
-	var events []OutboxEvent
-	if err := cursor.All(ctx, &events); err != nil {
-		return nil, err
+// RelayOutboxEvents leases pending events to owner and hands them to publish in sequence
+// order per product, for up to limit products; expired leases are reclaimed
+func (r *Repository) RelayOutboxEvents(ctx context.Context, owner string, lease time.Duration, limit int, publish func(ctx context.Context, event OutboxEvent) error) error {
+	relay := &outbox.OrderedRelay[OutboxEvent]{
+		Collection: r.db.Collection("inventory_outbox"),
+		Lease: outbox.Lease{
+			Owner:    owner,
+			Duration: lease,
+		},
+		Unfinished:  []string{OutboxStatusPending},
+		Concurrency: r.relayConcurrency,
+		Process:     publish,
 	}
-	return events, nil
+	return relay.RunOnce(ctx, limit)
 }
 
-// UpdateOutboxEvent updates the status of an outbox event
+// UpdateOutboxEvent updates the status of an outbox event and releases its lease;
+// it is a no-op if the lease has since passed to another owner
 func (r *Repository) UpdateOutboxEvent(ctx context.Context, event OutboxEvent) error {
 	_, err := r.db.Collection("inventory_outbox").UpdateOne(
 		ctx,
-		bson.M{"_id": event.ID},
-		bson.M{"$set": bson.M{"status": event.Status}},
+		outbox.OwnedFilter(event.ID, event.LeaseOwner),
+		bson.M{
+			"$set":   bson.M{"status": event.Status},
+			"$unset": outbox.ReleaseLease(),
+		},
 	)
 	return err
 }
 
-// UpsertProjection updates or creates an inventory projection
+// EnsureIndexes creates the indexes the repository relies on
+func (r *Repository) EnsureIndexes(ctx context.Context) error {
+	return ensureProjectionIndexes(ctx, r.db.Collection("inventory_projections"))
+}
+
+// UpsertProjection updates or creates an inventory projection; it returns outbox.ErrStaleEvent
+// when the projection already reflects proj.Version or a later event
 func (r *Repository) UpsertProjection(ctx context.Context, proj *InventoryProjection) error {
-	_, err := r.db.Collection("inventory_projections").UpdateOne(
+	return upsertProjection(ctx, r.db.Collection("inventory_projections"), proj)
+}
+
+// RebuildProjection regenerates inventory_projections by replaying every outbox event
+// into a shadow collection and swapping it into place
+func (r *Repository) RebuildProjection(ctx context.Context, progress func(outbox.RebuildReport)) (outbox.RebuildReport, error) {
+	rebuild := &outbox.Rebuild[OutboxEvent]{
+		Source:  r.db.Collection("inventory_outbox"),
+		Target:  r.db.Collection("inventory_projections"),
+		Prepare: ensureProjectionIndexes,
+		Apply: func(ctx context.Context, projection *mongo.Collection, event OutboxEvent) error {
+			inventoryEvent, err := decodeInventoryEvent(event.EventType, event.SchemaVersion, event.Payload)
+			if err != nil {
+				return err
+			}
+			proj := inventoryEvent.ToProjection()
+			proj.EventID = event.ID.Hex()
+			proj.Version = event.Sequence
+			return upsertProjection(ctx, projection, proj)
+		},
+		Progress: progress,
+	}
+	return rebuild.Run(ctx)
+}
+
+// ensureProjectionIndexes gives version-guarded upserts the unique key they need,
+// so a rejected write cannot insert a duplicate
+func ensureProjectionIndexes(ctx context.Context, projections *mongo.Collection) error {
+	_, err := projections.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func upsertProjection(ctx context.Context, projections *mongo.Collection, proj *InventoryProjection) error {
+	return outbox.UpsertVersioned(
 		ctx,
+		projections,
 		bson.M{"product_id": proj.ProductID},
-		bson.M{"$set": proj},
-		options.Update().SetUpsert(true),
+		outbox.Meta{EventID: proj.EventID, Sequence: proj.Version},
+		bson.M{
+			"quantity":   proj.Quantity,
+			"status":     proj.Status,
+			"updated_at": proj.UpdatedAt,
+		},
 	)
-	return err
 }
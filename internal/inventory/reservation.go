@@ -0,0 +1,267 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrInsufficientStock is returned when a product has less stock available than a reservation needs
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrReservationNotFound is returned for a reservation ID that was never reserved
+	ErrReservationNotFound = errors.New("reservation not found")
+	// ErrReservationExpired is returned when committing a reservation that expired or was released
+	ErrReservationExpired = errors.New("reservation expired")
+)
+
+// expireBatchSize is the number of reservations expired per sweep
+const expireBatchSize = 100
+
+// Reserve takes quantities, keyed by product ID, out of the available stock until expiresAt.
+// Every product is decremented in one transaction, so a single short product leaves the stock
+// untouched and returns ErrInsufficientStock. Reserving an ID already reserved changes nothing.
+func (r *Repository) Reserve(ctx context.Context, reservationID string, quantities map[string]int, expiresAt time.Time) error {
+	for productID, quantity := range quantities {
+		if quantity <= 0 {
+			return fmt.Errorf("invalid quantity %d of product %s", quantity, productID)
+		}
+	}
+
+	now := time.Now()
+	reservation := Reservation{
+		ID:        reservationID,
+		Items:     reservationItems(quantities),
+		Status:    ReservationStatusReserved,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	return r.WithTransaction(ctx, func(ctx context.Context) error {
+		err := r.db.Collection("inventory_reservations").FindOne(ctx, bson.M{"_id": reservationID}).Err()
+		if err == nil {
+			// Reserved by an earlier attempt
+			return nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return fmt.Errorf("failed to find reservation: %v", err)
+		}
+
+		if _, err := r.db.Collection("inventory_reservations").InsertOne(ctx, reservation); err != nil {
+			return fmt.Errorf("failed to create reservation: %v", err)
+		}
+		for _, item := range reservation.Items {
+			if err := r.adjustStock(ctx, item.ProductID, -item.Quantity, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Release returns the stock of a reserved or committed reservation to inventory. Releasing a
+// reservation that was already released, has expired or was never made changes nothing.
+func (r *Repository) Release(ctx context.Context, reservationID string) error {
+	return r.closeReservation(ctx, bson.M{
+		"_id":    reservationID,
+		"status": bson.M{"$in": []string{ReservationStatusReserved, ReservationStatusCommitted}},
+	}, ReservationStatusReleased)
+}
+
+// Commit keeps the stock of a reservation for good, so it no longer expires; committing twice
+// changes nothing. It returns ErrReservationExpired once the stock has returned to inventory.
+func (r *Repository) Commit(ctx context.Context, reservationID string) error {
+	collection := r.db.Collection("inventory_reservations")
+	result, err := collection.UpdateOne(
+		ctx,
+		bson.M{"_id": reservationID, "status": ReservationStatusReserved},
+		bson.M{"$set": bson.M{"status": ReservationStatusCommitted, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to commit reservation: %v", err)
+	}
+	if result.MatchedCount == 1 {
+		return nil
+	}
+
+	var reservation Reservation
+	err = collection.FindOne(ctx, bson.M{"_id": reservationID}).Decode(&reservation)
+	if err == mongo.ErrNoDocuments {
+		return ErrReservationNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find reservation: %v", err)
+	}
+	if reservation.Status != ReservationStatusCommitted {
+		return fmt.Errorf("%w: reservation %s is %s", ErrReservationExpired, reservationID, reservation.Status)
+	}
+	return nil
+}
+
+// Restock returns quantities, keyed by product ID, to the available stock in one transaction.
+// Restocking an ID already restocked changes nothing.
+func (r *Repository) Restock(ctx context.Context, restockID string, quantities map[string]int) error {
+	for productID, quantity := range quantities {
+		if quantity <= 0 {
+			return fmt.Errorf("invalid quantity %d of product %s", quantity, productID)
+		}
+	}
+
+	now := time.Now()
+	restock := Restock{ID: restockID, Items: reservationItems(quantities), CreatedAt: now}
+
+	return r.WithTransaction(ctx, func(ctx context.Context) error {
+		err := r.db.Collection("inventory_restocks").FindOne(ctx, bson.M{"_id": restockID}).Err()
+		if err == nil {
+			// Restocked by an earlier attempt
+			return nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return fmt.Errorf("failed to find restock: %v", err)
+		}
+
+		if _, err := r.db.Collection("inventory_restocks").InsertOne(ctx, restock); err != nil {
+			return fmt.Errorf("failed to create restock: %v", err)
+		}
+		for _, item := range restock.Items {
+			if err := r.adjustStock(ctx, item.ProductID, item.Quantity, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExpireReservations returns the stock of reservations not committed by their expiry to
+// inventory, for up to expireBatchSize reservations, and reports how many it expired
+func (r *Repository) ExpireReservations(ctx context.Context, now time.Time) (int, error) {
+	due := bson.M{"status": ReservationStatusReserved, "expires_at": bson.M{"$lte": now}}
+	cursor, err := r.db.Collection("inventory_reservations").Find(ctx, due, options.Find().
+		SetProjection(bson.M{"_id": 1}).
+		SetLimit(expireBatchSize))
+	if err != nil {
+		return 0, fmt.Errorf("failed to find expired reservations: %v", err)
+	}
+	var expired []Reservation
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, fmt.Errorf("failed to decode expired reservations: %v", err)
+	}
+
+	for i, reservation := range expired {
+		// Committed or released since it was found, the reservation is skipped
+		filter := bson.M{"_id": reservation.ID, "status": ReservationStatusReserved, "expires_at": bson.M{"$lte": now}}
+		if err := r.closeReservation(ctx, filter, ReservationStatusExpired); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+// closeReservation moves the reservation matching filter to status and returns its stock to
+// inventory in one transaction; it does nothing when no reservation matches
+func (r *Repository) closeReservation(ctx context.Context, filter bson.M, status string) error {
+	return r.WithTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now()
+		var reservation Reservation
+		err := r.db.Collection("inventory_reservations").FindOneAndUpdate(
+			ctx,
+			filter,
+			bson.M{"$set": bson.M{"status": status, "updated_at": now}},
+		).Decode(&reservation)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update reservation: %v", err)
+		}
+
+		for _, item := range reservation.Items {
+			if err := r.adjustStock(ctx, item.ProductID, item.Quantity, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// adjustStock adds delta to a product's available quantity, refusing to take it below zero,
+// and records the new quantity in the outbox so the projections follow
+func (r *Repository) adjustStock(ctx context.Context, productID string, delta int, now time.Time) error {
+	filter := bson.M{"product_id": productID}
+	if delta < 0 {
+		filter["quantity"] = bson.M{"$gte": -delta}
+	}
+
+	var inv Inventory
+	err := r.db.Collection("inventory").FindOneAndUpdate(
+		ctx,
+		filter,
+		bson.M{"$inc": bson.M{"quantity": delta}, "$set": bson.M{"updated_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&inv)
+	if err == mongo.ErrNoDocuments {
+		if delta < 0 {
+			return fmt.Errorf("%w: product %s", ErrInsufficientStock, productID)
+		}
+		return fmt.Errorf("product %s not found", productID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update stock of product %s: %v", productID, err)
+	}
+
+	payload, err := json.Marshal(InventoryEvent{
+		ProductID: inv.ProductID,
+		Quantity:  inv.Quantity,
+		Status:    inv.Status,
+		UpdatedAt: inv.UpdatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	return r.SaveOutboxEvent(ctx, OutboxEvent{
+		EventType:     "inventory.updated",
+		SchemaVersion: 1,
+		AggregateID:   inv.ProductID,
+		Payload:       payload,
+		CreatedAt:     now,
+		Status:        OutboxStatusPending,
+	})
+}
+
+// WithTransaction runs fn in a transaction, committing only if it returns nil. Called within a
+// transaction, fn joins it instead, so repository methods compose into one.
+func (r *Repository) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := r.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// reservationItems lists quantities in product order, so concurrent reservations touch
+// products in the same order
+func reservationItems(quantities map[string]int) []ReservationItem {
+	items := make([]ReservationItem, 0, len(quantities))
+	for productID, quantity := range quantities {
+		items = append(items, ReservationItem{ProductID: productID, Quantity: quantity})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ProductID < items[j].ProductID })
+	return items
+}
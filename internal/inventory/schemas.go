@@ -0,0 +1,16 @@
+package inventory
+
+import (
+	"embed"
+
+	"app/internal/schema"
+)
+
+// schemaFiles holds the JSON Schema of every event this context produces, one file per type and version
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+func init() {
+	schema.Default.MustRegisterFS("inventory", schemaFiles, "schemas")
+}
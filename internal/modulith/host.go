@@ -0,0 +1,211 @@
+// Package modulith hosts the bounded contexts as modules of a single process. Every
+// module is initialised in registration order, gets its HTTP routes mounted on the
+// shared mux and its message handlers subscribed on the shared NATS connection.
+// Shutdown runs in the reverse direction: subscriptions are drained first, then the
+// modules' background work is stopped, leaving the connections to be closed last.
+package modulith
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Module is a bounded context plugged into the host
+type Module interface {
+	// Name identifies the module; it must be unique within a host
+	Name() string
+	// Init receives the module's configuration, e.g. its database, before any handler is requested
+	Init(config map[string]any) error
+	// HTTPHandlers returns the routes to mount; pub publishes on the shared connection
+	HTTPHandlers(pub Publisher) []HTTPHandler
+	// MsgHandlers returns the subjects to subscribe; pub publishes on the shared connection
+	MsgHandlers(pub Publisher) []MsgHandler
+}
+
+// Lifecycle is implemented by modules that run background work, such as outbox relays
+type Lifecycle interface {
+	// Start launches the background work; ctx bounds startup only, not the work itself
+	Start(ctx context.Context) error
+	// Stop lets in-flight work, e.g. a relay batch, finish and returns once it has,
+	// or with ctx's error when the deadline passes first
+	Stop(ctx context.Context) error
+}
+
+// Publisher sends messages on the shared NATS connection
+type Publisher interface {
+	Publish(subject string, data []byte) error
+	PublishMsg(msg *nats.Msg) error
+}
+
+// HTTPHandler is a route of a module; Path may use ServeMux wildcards such as {id}
+type HTTPHandler struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+}
+
+// MsgHandler is a NATS subscription of a module
+type MsgHandler struct {
+	Subject string
+	Handler nats.MsgHandler
+}
+
+// Subscriber subscribes message handlers; *nats.Conn implements it
+type Subscriber interface {
+	Subscribe(subject string, handler nats.MsgHandler) (*nats.Subscription, error)
+}
+
+// Host wires registered modules onto a mux and a NATS connection
+type Host struct {
+	mux        *http.ServeMux
+	subscriber Subscriber
+	publisher  Publisher
+	modules    []registration
+	subs       []subscription
+	running    []registration
+	started    bool
+}
+
+type registration struct {
+	module Module
+	config map[string]any
+}
+
+type subscription struct {
+	module string
+	sub    *nats.Subscription
+}
+
+// NewHost creates a host that mounts routes on mux, subscribes through subscriber and
+// hands publisher to every module
+func NewHost(mux *http.ServeMux, subscriber Subscriber, publisher Publisher) *Host {
+	return &Host{
+		mux:        mux,
+		subscriber: subscriber,
+		publisher:  publisher,
+	}
+}
+
+// Register adds a module with its configuration; modules start in the order they are registered
+func (h *Host) Register(module Module, config map[string]any) error {
+	if h.started {
+		return fmt.Errorf("module %s registered after the host started", module.Name())
+	}
+	for _, r := range h.modules {
+		if r.module.Name() == module.Name() {
+			return fmt.Errorf("module %s is already registered", module.Name())
+		}
+	}
+	h.modules = append(h.modules, registration{module: module, config: config})
+	return nil
+}
+
+// Modules returns the names of the registered modules in startup order
+func (h *Host) Modules() []string {
+	names := make([]string, len(h.modules))
+	for i, r := range h.modules {
+		names[i] = r.module.Name()
+	}
+	return names
+}
+
+// Start initialises every module in registration order, mounting its routes and subscribing
+// its handlers before moving on to the next. Once every module is subscribed the Lifecycle
+// modules are started in the same order, so no relay publishes before its consumers listen.
+// Start stops at the first failure; Shutdown still stops the modules already started.
+func (h *Host) Start(ctx context.Context) error {
+	if h.started {
+		return errors.New("host already started")
+	}
+	h.started = true
+
+	for _, r := range h.modules {
+		name := r.module.Name()
+		if err := r.module.Init(r.config); err != nil {
+			return fmt.Errorf("module %s: init: %w", name, err)
+		}
+
+		for _, route := range r.module.HTTPHandlers(h.publisher) {
+			pattern := route.Path
+			if route.Method != "" {
+				pattern = route.Method + " " + route.Path
+			}
+			h.mux.HandleFunc(pattern, route.Handler)
+		}
+
+		for _, handler := range r.module.MsgHandlers(h.publisher) {
+			sub, err := h.subscriber.Subscribe(handler.Subject, handler.Handler)
+			if err != nil {
+				return fmt.Errorf("module %s: subscribe %s: %w", name, handler.Subject, err)
+			}
+			h.subs = append(h.subs, subscription{module: name, sub: sub})
+		}
+	}
+
+	for _, r := range h.modules {
+		if lifecycle, ok := r.module.(Lifecycle); ok {
+			if err := lifecycle.Start(ctx); err != nil {
+				return fmt.Errorf("module %s: start: %w", r.module.Name(), err)
+			}
+		}
+		h.running = append(h.running, r)
+		log.Printf("Module %s started", r.module.Name())
+	}
+	return nil
+}
+
+// drainPollInterval is how often Shutdown checks whether a draining subscription has finished
+const drainPollInterval = 10 * time.Millisecond
+
+// Shutdown drains every subscription, so messages already delivered are handled, then stops
+// the running modules in reverse startup order. It gives up waiting once ctx is done; the
+// caller closes the NATS connection and the database clients afterwards.
+func (h *Host) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	for i := len(h.subs) - 1; i >= 0; i-- {
+		if err := h.subs[i].sub.Drain(); err != nil {
+			log.Printf("Error draining %s subscription to %s: %v", h.subs[i].module, h.subs[i].sub.Subject, err)
+		}
+	}
+	for _, s := range h.subs {
+		if err := waitDrained(ctx, s.sub); err != nil {
+			errs = append(errs, fmt.Errorf("module %s: drain %s: %w", s.module, s.sub.Subject, err))
+		}
+	}
+	h.subs = nil
+
+	for i := len(h.running) - 1; i >= 0; i-- {
+		module := h.running[i].module
+		lifecycle, ok := module.(Lifecycle)
+		if !ok {
+			continue
+		}
+		if err := lifecycle.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("module %s: stop: %w", module.Name(), err))
+			continue
+		}
+		log.Printf("Module %s stopped", module.Name())
+	}
+	h.running = nil
+
+	return errors.Join(errs...)
+}
+
+// waitDrained returns once a draining subscription has handled its pending messages and closed
+func waitDrained(ctx context.Context, sub *nats.Subscription) error {
+	for sub.IsValid() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(drainPollInterval):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package modulith
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeModule records the calls the host makes in the shared log
+type fakeModule struct {
+	name     string
+	initErr  error
+	startErr error
+	// stopBlock makes Stop wait for the deadline, like a relay stuck in its batch
+	stopBlock bool
+	config    map[string]any
+	pub       Publisher
+	log       *[]string
+}
+
+func (m *fakeModule) Name() string { return m.name }
+
+func (m *fakeModule) Init(config map[string]any) error {
+	*m.log = append(*m.log, "init "+m.name)
+	m.config = config
+	return m.initErr
+}
+
+func (m *fakeModule) HTTPHandlers(pub Publisher) []HTTPHandler {
+	m.pub = pub
+	return []HTTPHandler{{
+		Method: http.MethodGet,
+		Path:   "/" + m.name + "/{id}",
+		Handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(m.name + ":" + r.PathValue("id")))
+		},
+	}}
+}
+
+func (m *fakeModule) MsgHandlers(pub Publisher) []MsgHandler {
+	return []MsgHandler{{Subject: m.name + ".>", Handler: func(*nats.Msg) {}}}
+}
+
+func (m *fakeModule) Start(ctx context.Context) error {
+	*m.log = append(*m.log, "start "+m.name)
+	return m.startErr
+}
+
+func (m *fakeModule) Stop(ctx context.Context) error {
+	*m.log = append(*m.log, "stop "+m.name)
+	if m.stopBlock {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+// passiveModule has no lifecycle hooks
+type passiveModule struct {
+	name string
+}
+
+func (m *passiveModule) Name() string                             { return m.name }
+func (m *passiveModule) Init(map[string]any) error                { return nil }
+func (m *passiveModule) HTTPHandlers(pub Publisher) []HTTPHandler { return nil }
+func (m *passiveModule) MsgHandlers(pub Publisher) []MsgHandler   { return nil }
+
+type fakeSubscriber struct {
+	subjects []string
+	err      error
+}
+
+func (s *fakeSubscriber) Subscribe(subject string, handler nats.MsgHandler) (*nats.Subscription, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.subjects = append(s.subjects, subject)
+	return &nats.Subscription{Subject: subject}, nil
+}
+
+type fakePublisher struct{}
+
+func (fakePublisher) Publish(string, []byte) error { return nil }
+func (fakePublisher) PublishMsg(*nats.Msg) error   { return nil }
+
+func TestHostStartsModulesInOrder(t *testing.T) {
+	var calls []string
+	mux := http.NewServeMux()
+	subscriber := &fakeSubscriber{}
+	host := NewHost(mux, subscriber, fakePublisher{})
+
+	customers := &fakeModule{name: "customers", log: &calls}
+	inventory := &fakeModule{name: "inventory", log: &calls}
+	require.NoError(t, host.Register(customers, map[string]any{"db": "CustomersDB"}))
+	require.NoError(t, host.Register(inventory, map[string]any{"db": "InventoryDB"}))
+
+	require.NoError(t, host.Start(context.Background()))
+
+	assert.Equal(t, []string{"customers", "inventory"}, host.Modules())
+	// Start hooks run only once every module is subscribed
+	assert.Equal(t, []string{"init customers", "init inventory", "start customers", "start inventory"}, calls)
+	assert.Equal(t, "InventoryDB", inventory.config["db"])
+	assert.Equal(t, fakePublisher{}, customers.pub)
+	assert.Equal(t, []string{"customers.>", "inventory.>"}, subscriber.subjects)
+
+	// Routes are mounted with their method and wildcards
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/inventory/42", nil))
+	assert.Equal(t, "inventory:42", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/inventory/42", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	// Modules are stopped in reverse startup order
+	calls = nil
+	require.NoError(t, host.Shutdown(context.Background()))
+	assert.Equal(t, []string{"stop inventory", "stop customers"}, calls)
+}
+
+func TestHostShutdownStopsStartedModulesOnly(t *testing.T) {
+	var calls []string
+	host := NewHost(http.NewServeMux(), &fakeSubscriber{}, fakePublisher{})
+	require.NoError(t, host.Register(&fakeModule{name: "customers", log: &calls}, nil))
+	require.NoError(t, host.Register(&fakeModule{name: "inventory", startErr: errors.New("no change streams"), log: &calls}, nil))
+	require.NoError(t, host.Register(&fakeModule{name: "ordering", log: &calls}, nil))
+
+	err := host.Start(context.Background())
+	assert.ErrorContains(t, err, "module inventory: start: no change streams")
+
+	calls = nil
+	require.NoError(t, host.Shutdown(context.Background()))
+	assert.Equal(t, []string{"stop customers"}, calls)
+}
+
+func TestHostShutdownHonoursDeadline(t *testing.T) {
+	var calls []string
+	host := NewHost(http.NewServeMux(), &fakeSubscriber{}, fakePublisher{})
+	require.NoError(t, host.Register(&fakeModule{name: "customers", log: &calls}, nil))
+	require.NoError(t, host.Register(&fakeModule{name: "inventory", stopBlock: true, log: &calls}, nil))
+	require.NoError(t, host.Register(&passiveModule{name: "ordering"}, nil))
+	require.NoError(t, host.Start(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	calls = nil
+	err := host.Shutdown(ctx)
+
+	// The stuck module reports the deadline; the others are still stopped
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "module inventory: stop")
+	assert.Equal(t, []string{"stop inventory", "stop customers"}, calls)
+}
+
+func TestHostRejectsDuplicateModule(t *testing.T) {
+	var calls []string
+	host := NewHost(http.NewServeMux(), &fakeSubscriber{}, fakePublisher{})
+
+	require.NoError(t, host.Register(&fakeModule{name: "customers", log: &calls}, nil))
+	assert.Error(t, host.Register(&fakeModule{name: "customers", log: &calls}, nil))
+}
+
+func TestHostStopsAtFailingModule(t *testing.T) {
+	var calls []string
+	host := NewHost(http.NewServeMux(), &fakeSubscriber{}, fakePublisher{})
+	require.NoError(t, host.Register(&fakeModule{name: "customers", initErr: errors.New("no database"), log: &calls}, nil))
+	require.NoError(t, host.Register(&fakeModule{name: "inventory", log: &calls}, nil))
+
+	err := host.Start(context.Background())
+
+	assert.ErrorContains(t, err, "module customers: init: no database")
+	assert.Equal(t, []string{"init customers"}, calls)
+}
+
+func TestHostReportsSubscribeFailure(t *testing.T) {
+	var calls []string
+	host := NewHost(http.NewServeMux(), &fakeSubscriber{err: nats.ErrConnectionClosed}, fakePublisher{})
+	require.NoError(t, host.Register(&fakeModule{name: "inventory", log: &calls}, nil))
+
+	err := host.Start(context.Background())
+
+	assert.ErrorIs(t, err, nats.ErrConnectionClosed)
+}
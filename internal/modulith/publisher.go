@@ -0,0 +1,29 @@
+package modulith
+
+import "github.com/nats-io/nats.go"
+
+// NATSPublisher adapts a NATS connection to the modules' Publisher interface
+type NATSPublisher struct {
+	conn *nats.Conn
+}
+
+// NewNATSPublisher creates a publisher on conn
+func NewNATSPublisher(conn *nats.Conn) *NATSPublisher {
+	return &NATSPublisher{conn: conn}
+}
+
+// Publish sends the message and flushes, so a nil error means the server has received it
+func (p *NATSPublisher) Publish(subject string, data []byte) error {
+	if err := p.conn.Publish(subject, data); err != nil {
+		return err
+	}
+	return p.conn.Flush()
+}
+
+// PublishMsg sends the message with its headers and flushes like Publish
+func (p *NATSPublisher) PublishMsg(msg *nats.Msg) error {
+	if err := p.conn.PublishMsg(msg); err != nil {
+		return err
+	}
+	return p.conn.Flush()
+}
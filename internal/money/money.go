@@ -0,0 +1,50 @@
+// Package money holds sums of money as whole minor units, so prices and totals add up exactly.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// MinorUnits is the number of minor units in a major unit, e.g. cents in a dollar
+const MinorUnits = 100
+
+// ErrOverflow is returned when a sum does not fit in an Amount
+var ErrOverflow = errors.New("amount out of range")
+
+// Amount is a sum of money in minor units: 1050 is 10.50. It is stored and sent as that
+// integer in BSON and JSON.
+type Amount int64
+
+// Times multiplies a unit price by a non-negative quantity, returning ErrOverflow if the
+// result does not fit
+func (a Amount) Times(quantity int) (Amount, error) {
+	if quantity < 0 {
+		return 0, fmt.Errorf("negative quantity %d", quantity)
+	}
+	if quantity != 0 && (a > math.MaxInt64/Amount(quantity) || a < math.MinInt64/Amount(quantity)) {
+		return 0, ErrOverflow
+	}
+	return a * Amount(quantity), nil
+}
+
+// Add adds b to a, returning ErrOverflow if the result does not fit
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, ErrOverflow
+	}
+	return sum, nil
+}
+
+// String formats the amount in major units with two decimals, e.g. "10.50"
+func (a Amount) String() string {
+	sign := ""
+	minor := uint64(a)
+	if a < 0 {
+		sign = "-"
+		minor = uint64(-(a + 1)) + 1
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/MinorUnits, minor%MinorUnits)
+}
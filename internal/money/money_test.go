@@ -0,0 +1,60 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAmountArithmetic(t *testing.T) {
+	t.Run("multiplies a unit price", func(t *testing.T) {
+		total, err := Amount(1050).Times(3)
+		require.NoError(t, err)
+		assert.Equal(t, Amount(3150), total)
+	})
+
+	t.Run("adds exactly", func(t *testing.T) {
+		// 0.10 + 0.20 is 0.30, unlike with float64
+		sum, err := Amount(10).Add(20)
+		require.NoError(t, err)
+		assert.Equal(t, Amount(30), sum)
+	})
+
+	t.Run("rejects overflow", func(t *testing.T) {
+		_, err := Amount(math.MaxInt64 / 2).Times(3)
+		assert.ErrorIs(t, err, ErrOverflow)
+
+		_, err = Amount(math.MaxInt64).Add(1)
+		assert.ErrorIs(t, err, ErrOverflow)
+
+		_, err = Amount(math.MinInt64).Add(-1)
+		assert.ErrorIs(t, err, ErrOverflow)
+	})
+}
+
+func TestAmountString(t *testing.T) {
+	assert.Equal(t, "10.50", Amount(1050).String())
+	assert.Equal(t, "0.05", Amount(5).String())
+	assert.Equal(t, "-0.05", Amount(-5).String())
+	assert.Equal(t, "-92233720368547758.08", Amount(math.MinInt64).String())
+}
+
+func TestAmountJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Price Amount `json:"price"`
+	}{Price: 1050})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"price":1050}`, string(data))
+
+	var decoded struct {
+		Price Amount `json:"price"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"price":1999}`), &decoded))
+	assert.Equal(t, Amount(1999), decoded.Price)
+
+	// Fractions of a minor unit are rejected rather than rounded
+	assert.Error(t, json.Unmarshal([]byte(`{"price":19.99}`), &decoded))
+}
@@ -4,26 +4,115 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
 )
 
-// Activity function signatures
-func CreateOrder(ctx context.Context, input OrderWorkflowInput) (string, error) {
-	if input.OrderID == "" {
-		return "", errors.New("invalid order: missing order ID")
+// Activities are the activities of OrderWorkflow, registered together by Register
+type Activities struct {
+	orders    OrderRepository
+	customers CustomerRepository
+	inventory Inventory
+	catalogue Catalogue
+}
+
+// NewActivities creates the activities, storing orders in orders, looking customers up in
+// customers, reserving stock in inventory and pricing items from catalogue
+func NewActivities(orders OrderRepository, customers CustomerRepository, inventory Inventory, catalogue Catalogue) *Activities {
+	return &Activities{orders: orders, customers: customers, inventory: inventory, catalogue: catalogue}
+}
+
+// CreateOrder prices the order from the catalogue and stores it with its items, totals,
+// pending status and a snapshot of the customer; a retried attempt finds the order already
+// stored and leaves it as it is. Invalid and tampered orders, and orders of unknown or deleted
+// customers, are rejected without retrying.
+func (a *Activities) CreateOrder(ctx context.Context, input OrderWorkflowInput) (string, error) {
+	if err := input.Validate(); err != nil {
+		return "", temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypeInvalidOrder, err)
+	}
+
+	prices, err := a.catalogue.Prices(ctx, productIDs(input.Items))
+	if err != nil {
+		return "", err
+	}
+	input, err = priceOrder(input, prices)
+	if err != nil {
+		return "", temporal.NewNonRetryableApplicationError(err.Error(), pricingErrorType(err), err)
+	}
+
+	customer, err := a.customers.FindCustomer(ctx, input.CustomerID)
+	if errors.Is(err, ErrCustomerNotFound) {
+		return "", temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("customer %s not found", input.CustomerID), ErrorTypeCustomerNotFound, err)
 	}
-	if input.CustomerID == "" {
-		return "", errors.New("invalid order: missing customer ID")
+	if err != nil {
+		return "", err
 	}
-	if len(input.Items) == 0 {
-		return "", errors.New("invalid order: no items")
+	if customer.Deleted {
+		return "", temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("customer %s is deleted", input.CustomerID), ErrorTypeCustomerDeleted, nil)
 	}
 
-	// In a real implementation, we would save the order to a database
-	// For now, just return the order ID
+	now := time.Now().UTC()
+	order := &Order{
+		ID:         input.OrderID,
+		CustomerID: input.CustomerID,
+		Customer: CustomerSnapshot{
+			Name:            customer.Name,
+			Email:           customer.Email,
+			DeliveryAddress: customer.DeliveryAddress,
+			BillingAddress:  customer.BillingAddress,
+		},
+		Items:         input.Items,
+		TotalAmount:   input.TotalAmount,
+		Status:        StatusPending,
+		StatusHistory: []StatusChange{{Status: StatusPending, At: now}},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := a.orders.CreateOrder(ctx, order); err != nil {
+		return "", err
+	}
 	return input.OrderID, nil
 }
 
-func ProcessPayment(ctx context.Context, orderID string) (string, error) {
+// RecordOrderStatus writes a workflow state transition back to the stored order
+func (a *Activities) RecordOrderStatus(ctx context.Context, update StatusUpdate) error {
+	return a.orders.RecordStatus(ctx, update)
+}
+
+// ReserveInventory takes the ordered quantity of every item out of the available stock until
+// ReservationTTL has passed. A product short of stock fails the whole reservation without
+// retrying; the reservation ID derives from the order ID, so a retried attempt reserves once.
+func (a *Activities) ReserveInventory(ctx context.Context, input OrderWorkflowInput) (string, error) {
+	reservationID := fmt.Sprintf("reservation-%s", input.OrderID)
+	err := a.inventory.Reserve(ctx, reservationID, reservationQuantities(input.Items), time.Now().Add(ReservationTTL))
+	if errors.Is(err, ErrInsufficientStock) {
+		return "", temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypeInsufficientStock, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return reservationID, nil
+}
+
+// ReleaseReservation compensates ReserveInventory; releasing twice is harmless
+func (a *Activities) ReleaseReservation(ctx context.Context, reservationID string) error {
+	return a.inventory.Release(ctx, reservationID)
+}
+
+// CommitReservation keeps the reserved stock of a paid order, so the reservation no longer
+// expires. A reservation that expired before payment fails without retrying.
+func (a *Activities) CommitReservation(ctx context.Context, reservationID string) error {
+	err := a.inventory.Commit(ctx, reservationID)
+	if errors.Is(err, ErrReservationExpired) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypeReservationExpired, err)
+	}
+	return err
+}
+
+func (a *Activities) ProcessPayment(ctx context.Context, orderID string, payment PaymentInitiated) (string, error) {
 	if orderID == "order-2" {
 		return "", errors.New("insufficient funds")
 	}
@@ -32,13 +121,60 @@ func ProcessPayment(ctx context.Context, orderID string) (string, error) {
 	return fmt.Sprintf("payment-%s", orderID), nil
 }
 
-func ProcessFulfillment(ctx context.Context, orderID string) (string, error) {
+// RefundPayment compensates ProcessPayment; the payment ID keeps a retried refund idempotent
+func (a *Activities) RefundPayment(ctx context.Context, orderID string, paymentID string) (string, error) {
+	// In a real implementation, we would refund the payment and issue a correcting statement
+	// For now, just return a dummy refund ID
+	return fmt.Sprintf("refund-%s", paymentID), nil
+}
+
+// RequestRefund records a refund on the order. Refunds of orders not completed, exceeding the
+// captured amount or the ordered items, or reusing a refund ID for a different refund are
+// rejected without retrying.
+func (a *Activities) RequestRefund(ctx context.Context, request RefundRequest) (Refund, error) {
+	refund, err := a.orders.RequestRefund(ctx, request, time.Now().UTC())
+	for _, rejected := range []error{ErrOrderNotFound, ErrRefundExists, ErrOrderNotRefundable, ErrRefundExceedsCaptured, ErrRefundExceedsOrder} {
+		if errors.Is(err, rejected) {
+			return Refund{}, temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypeRefundRejected, err)
+		}
+	}
+	return refund, err
+}
+
+// ProcessRefund pays a refund back to the customer; the refund ID keeps a retried refund idempotent
+func (a *Activities) ProcessRefund(ctx context.Context, orderID string, refund Refund) (string, error) {
+	// In a real implementation, we would refund refund.Amount of the payment
+	// For now, just return a dummy refund ID
+	return fmt.Sprintf("payment-refund-%s", refund.ID), nil
+}
+
+// RestockItems returns a refund's items to inventory; the restock ID derives from the refund
+// ID, so a retried attempt restocks once
+func (a *Activities) RestockItems(ctx context.Context, refundID string, items []RefundItem) error {
+	quantities := make(map[string]int, len(items))
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	return a.inventory.Restock(ctx, fmt.Sprintf("restock-%s", refundID), quantities)
+}
+
+// CompleteRefund marks a refund as paid out and emits OrderRefunded
+func (a *Activities) CompleteRefund(ctx context.Context, completion RefundCompletion) error {
+	return a.orders.CompleteRefund(ctx, completion)
+}
+
+// FailRefund releases the amount of a refund that could not be paid out
+func (a *Activities) FailRefund(ctx context.Context, failure RefundFailure) error {
+	return a.orders.FailRefund(ctx, failure)
+}
+
+func (a *Activities) ProcessFulfillment(ctx context.Context, orderID string) (string, error) {
 	// In a real implementation, we would process the fulfillment
 	// For now, just return a dummy fulfillment ID
 	return fmt.Sprintf("fulfillment-%s", orderID), nil
 }
 
-func ProcessDelivery(ctx context.Context, orderID string) (string, error) {
+func (a *Activities) ProcessDelivery(ctx context.Context, orderID string) (string, error) {
 	// In a real implementation, we would process the delivery
 	// For now, just return a dummy delivery ID
 	return fmt.Sprintf("delivery-%s", orderID), nil
@@ -3,8 +3,12 @@ package ordering
 import (
 	"strings"
 	"testing"
+	"time"
+
+	"app/internal/money"
 
 	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
 )
 
@@ -12,15 +16,18 @@ type OrderActivitiesTestSuite struct {
 	suite.Suite
 	testsuite.WorkflowTestSuite
 
-	env *testsuite.TestActivityEnvironment
+	env        *testsuite.TestActivityEnvironment
+	orders     *memoryOrderRepository
+	inventory  *memoryInventory
+	activities *Activities
 }
 
 func (s *OrderActivitiesTestSuite) SetupTest() {
 	s.env = s.NewTestActivityEnvironment()
-	s.env.RegisterActivity(CreateOrder)
-	s.env.RegisterActivity(ProcessPayment)
-	s.env.RegisterActivity(ProcessFulfillment)
-	s.env.RegisterActivity(ProcessDelivery)
+	s.orders = newMemoryOrderRepository()
+	s.inventory = newTestInventory()
+	s.activities = NewActivities(s.orders, newTestCustomers(), s.inventory, newTestCatalogue())
+	s.env.RegisterActivity(s.activities)
 }
 
 func TestOrderActivitiesTestSuite(t *testing.T) {
@@ -36,57 +43,236 @@ func (s *OrderActivitiesTestSuite) Test_CreateOrder() {
 			{
 				ProductID:  "test-product-1",
 				Quantity:   1,
-				UnitPrice:  10.00,
-				TotalPrice: 10.00,
+				UnitPrice:  1000,
+				TotalPrice: 1000,
 			},
 		},
-		TotalAmount: 10.00,
+		TotalAmount: 1000,
 	}
 
 	var orderID string
-	result, err := s.env.ExecuteActivity(CreateOrder, input)
+	result, err := s.env.ExecuteActivity(s.activities.CreateOrder, input)
 	s.NoError(err)
 	s.NoError(result.Get(&orderID))
 	s.Equal("test-order-1", orderID)
+	s.Equal([]string{StatusPending}, s.orders.statuses("test-order-1"))
+
+	s.Equal(CustomerSnapshot{Name: "Test User", Email: "test@example.com"}, s.orders.orders["test-order-1"].Customer)
+
+	// A retried attempt leaves the stored order as it is
+	_, err = s.env.ExecuteActivity(s.activities.CreateOrder, input)
+	s.NoError(err)
+	s.Len(s.orders.orders, 1)
 
 	// Test invalid order (missing order ID)
 	input.OrderID = ""
-	_, err = s.env.ExecuteActivity(CreateOrder, input)
+	_, err = s.env.ExecuteActivity(s.activities.CreateOrder, input)
 	s.Error(err)
 	s.True(strings.Contains(err.Error(), "missing order ID"))
 
 	// Test invalid order (missing customer ID)
 	input.OrderID = "test-order-1"
 	input.CustomerID = ""
-	_, err = s.env.ExecuteActivity(CreateOrder, input)
+	_, err = s.env.ExecuteActivity(s.activities.CreateOrder, input)
 	s.Error(err)
 	s.True(strings.Contains(err.Error(), "missing customer ID"))
 
 	// Test invalid order (no items)
 	input.CustomerID = "test-customer-1"
 	input.Items = nil
-	_, err = s.env.ExecuteActivity(CreateOrder, input)
+	_, err = s.env.ExecuteActivity(s.activities.CreateOrder, input)
 	s.Error(err)
 	s.True(strings.Contains(err.Error(), "no items"))
 }
 
+func (s *OrderActivitiesTestSuite) Test_CreateOrderRejectsCustomer() {
+	input := OrderWorkflowInput{
+		OrderID:     "order-1",
+		CustomerID:  "customer-9",
+		Items:       []OrderItem{{ProductID: "prod-1", Quantity: 1, UnitPrice: 1000, TotalPrice: 1000}},
+		TotalAmount: 1000,
+	}
+
+	for customerID, errorType := range map[string]string{
+		"customer-9":       ErrorTypeCustomerNotFound,
+		"deleted-customer": ErrorTypeCustomerDeleted,
+	} {
+		input.CustomerID = customerID
+		_, err := s.env.ExecuteActivity(s.activities.CreateOrder, input)
+
+		var appErr *temporal.ApplicationError
+		s.Require().ErrorAs(err, &appErr, customerID)
+		s.Equal(errorType, appErr.Type())
+		s.True(appErr.NonRetryable())
+	}
+	s.Empty(s.orders.orders)
+}
+
+func (s *OrderActivitiesTestSuite) Test_CreateOrderPricesOrder() {
+	// Prices left out by the client are taken from the catalogue
+	input := OrderWorkflowInput{
+		OrderID:    "order-1",
+		CustomerID: "customer-1",
+		Items:      []OrderItem{{ProductID: "prod-1", Quantity: 3}},
+	}
+	_, err := s.env.ExecuteActivity(s.activities.CreateOrder, input)
+	s.Require().NoError(err)
+
+	order := s.orders.orders["order-1"]
+	s.Equal([]OrderItem{{ProductID: "prod-1", Quantity: 3, UnitPrice: 1000, TotalPrice: 3000}}, order.Items)
+	s.Equal(money.Amount(3000), order.TotalAmount)
+}
+
+func (s *OrderActivitiesTestSuite) Test_CreateOrderRejectsOrder() {
+	for name, tc := range map[string]struct {
+		item        OrderItem
+		totalAmount money.Amount
+		errorType   string
+	}{
+		"tampered price":  {OrderItem{ProductID: "prod-1", Quantity: 2, UnitPrice: 1, TotalPrice: 2}, 2, ErrorTypePriceMismatch},
+		"tampered total":  {OrderItem{ProductID: "prod-1", Quantity: 2, UnitPrice: 1000, TotalPrice: 2000}, 1000, ErrorTypePriceMismatch},
+		"unknown product": {OrderItem{ProductID: "prod-9", Quantity: 1}, 0, ErrorTypeUnknownProduct},
+		"zero quantity":   {OrderItem{ProductID: "prod-1", Quantity: 0}, 0, ErrorTypeInvalidOrder},
+		"excess quantity": {OrderItem{ProductID: "prod-1", Quantity: MaxItemQuantity + 1}, 0, ErrorTypeInvalidOrder},
+	} {
+		input := OrderWorkflowInput{
+			OrderID:     "order-1",
+			CustomerID:  "customer-1",
+			Items:       []OrderItem{tc.item},
+			TotalAmount: tc.totalAmount,
+		}
+		_, err := s.env.ExecuteActivity(s.activities.CreateOrder, input)
+
+		var appErr *temporal.ApplicationError
+		s.Require().ErrorAs(err, &appErr, name)
+		s.Equal(tc.errorType, appErr.Type(), name)
+		s.True(appErr.NonRetryable(), name)
+	}
+	s.Empty(s.orders.orders)
+}
+
+func (s *OrderActivitiesTestSuite) Test_RecordOrderStatus() {
+	input := OrderWorkflowInput{
+		OrderID:     "order-1",
+		CustomerID:  "customer-1",
+		Items:       []OrderItem{{ProductID: "prod-1", Quantity: 1, UnitPrice: 1000, TotalPrice: 1000}},
+		TotalAmount: 1000,
+	}
+	_, err := s.env.ExecuteActivity(s.activities.CreateOrder, input)
+	s.Require().NoError(err)
+
+	update := StatusUpdate{
+		State: OrderWorkflowState{OrderID: "order-1", Status: StatusAwaitingPayment, ReservationID: "reservation-order-1"},
+		At:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	_, err = s.env.ExecuteActivity(s.activities.RecordOrderStatus, update)
+	s.NoError(err)
+	// A retried write is recorded once
+	_, err = s.env.ExecuteActivity(s.activities.RecordOrderStatus, update)
+	s.NoError(err)
+
+	s.Equal([]string{StatusPending, StatusAwaitingPayment}, s.orders.statuses("order-1"))
+	order := s.orders.orders["order-1"]
+	s.Equal(StatusAwaitingPayment, order.Status)
+	s.Equal("reservation-order-1", order.ReservationID)
+	s.Equal(update.At, order.UpdatedAt)
+}
+
+func (s *OrderActivitiesTestSuite) Test_ReserveInventory() {
+	input := OrderWorkflowInput{
+		OrderID:    "order-1",
+		CustomerID: "customer-1",
+		Items: []OrderItem{
+			{ProductID: "prod-1", Quantity: 2, UnitPrice: 1000, TotalPrice: 2000},
+			{ProductID: "prod-2", Quantity: 1, UnitPrice: 500, TotalPrice: 500},
+		},
+		TotalAmount: 2500,
+	}
+
+	var reservationID string
+	result, err := s.env.ExecuteActivity(s.activities.ReserveInventory, input)
+	s.Require().NoError(err)
+	s.NoError(result.Get(&reservationID))
+	s.Equal("reservation-order-1", reservationID)
+	s.Equal(98, s.inventory.available("prod-1"))
+	s.Equal(0, s.inventory.available("prod-2"))
+
+	// A retried attempt reserves once
+	_, err = s.env.ExecuteActivity(s.activities.ReserveInventory, input)
+	s.NoError(err)
+	s.Equal(98, s.inventory.available("prod-1"))
+
+	// prod-2 is sold out, so the whole order is rejected without retrying
+	input.OrderID = "order-2"
+	_, err = s.env.ExecuteActivity(s.activities.ReserveInventory, input)
+	var appErr *temporal.ApplicationError
+	s.Require().ErrorAs(err, &appErr)
+	s.Equal(ErrorTypeInsufficientStock, appErr.Type())
+	s.True(appErr.NonRetryable())
+	s.Contains(err.Error(), "product prod-2")
+	s.Equal(98, s.inventory.available("prod-1"))
+
+	// Releasing returns the stock, once
+	_, err = s.env.ExecuteActivity(s.activities.ReleaseReservation, "reservation-order-1")
+	s.NoError(err)
+	_, err = s.env.ExecuteActivity(s.activities.ReleaseReservation, "reservation-order-1")
+	s.NoError(err)
+	s.Equal(100, s.inventory.available("prod-1"))
+	s.Equal(1, s.inventory.available("prod-2"))
+}
+
+func (s *OrderActivitiesTestSuite) Test_CommitReservation() {
+	input := OrderWorkflowInput{
+		OrderID:     "order-1",
+		CustomerID:  "customer-1",
+		Items:       []OrderItem{{ProductID: "prod-1", Quantity: 1, UnitPrice: 1000, TotalPrice: 1000}},
+		TotalAmount: 1000,
+	}
+	_, err := s.env.ExecuteActivity(s.activities.ReserveInventory, input)
+	s.Require().NoError(err)
+	input.OrderID = "order-2"
+	_, err = s.env.ExecuteActivity(s.activities.ReserveInventory, input)
+	s.Require().NoError(err)
+
+	_, err = s.env.ExecuteActivity(s.activities.CommitReservation, "reservation-order-1")
+	s.NoError(err)
+
+	// Only the uncommitted reservation expires
+	s.inventory.expire(time.Now().Add(ReservationTTL))
+	s.Equal(99, s.inventory.available("prod-1"))
+
+	_, err = s.env.ExecuteActivity(s.activities.CommitReservation, "reservation-order-2")
+	var appErr *temporal.ApplicationError
+	s.Require().ErrorAs(err, &appErr)
+	s.Equal(ErrorTypeReservationExpired, appErr.Type())
+	s.True(appErr.NonRetryable())
+}
+
 func (s *OrderActivitiesTestSuite) Test_ProcessPayment() {
 	// Test successful payment
 	var paymentID string
-	result, err := s.env.ExecuteActivity(ProcessPayment, "order-1")
+	result, err := s.env.ExecuteActivity(s.activities.ProcessPayment, "order-1", PaymentInitiated{Method: "card", Reference: "psp-1"})
 	s.NoError(err)
 	s.NoError(result.Get(&paymentID))
 	s.Equal("payment-order-1", paymentID)
 
 	// Test failed payment (insufficient funds)
-	_, err = s.env.ExecuteActivity(ProcessPayment, "order-2")
+	_, err = s.env.ExecuteActivity(s.activities.ProcessPayment, "order-2", PaymentInitiated{Method: "card", Reference: "psp-2"})
 	s.Error(err)
 	s.True(strings.Contains(err.Error(), "insufficient funds"))
 }
 
+func (s *OrderActivitiesTestSuite) Test_RefundPayment() {
+	var refundID string
+	result, err := s.env.ExecuteActivity(s.activities.RefundPayment, "order-1", "payment-order-1")
+	s.NoError(err)
+	s.NoError(result.Get(&refundID))
+	s.Equal("refund-payment-order-1", refundID)
+}
+
 func (s *OrderActivitiesTestSuite) Test_ProcessFulfillment() {
 	var fulfillmentID string
-	result, err := s.env.ExecuteActivity(ProcessFulfillment, "order-1")
+	result, err := s.env.ExecuteActivity(s.activities.ProcessFulfillment, "order-1")
 	s.NoError(err)
 	s.NoError(result.Get(&fulfillmentID))
 	s.Equal("fulfillment-order-1", fulfillmentID)
@@ -94,8 +280,39 @@ func (s *OrderActivitiesTestSuite) Test_ProcessFulfillment() {
 
 func (s *OrderActivitiesTestSuite) Test_ProcessDelivery() {
 	var deliveryID string
-	result, err := s.env.ExecuteActivity(ProcessDelivery, "order-1")
+	result, err := s.env.ExecuteActivity(s.activities.ProcessDelivery, "order-1")
 	s.NoError(err)
 	s.NoError(result.Get(&deliveryID))
 	s.Equal("delivery-order-1", deliveryID)
 }
+
+func (s *OrderActivitiesTestSuite) Test_RequestRefund() {
+	s.orders.orders["order-1"] = completedOrder()
+	request := RefundRequest{RefundID: "refund-1", OrderID: "order-1", Amount: 500}
+
+	var refund Refund
+	result, err := s.env.ExecuteActivity(s.activities.RequestRefund, request)
+	s.Require().NoError(err)
+	s.NoError(result.Get(&refund))
+	s.Equal(money.Amount(500), refund.Amount)
+	s.Equal("payment-order-1", refund.PaymentID)
+
+	// A retried attempt finds the refund recorded
+	_, err = s.env.ExecuteActivity(s.activities.RequestRefund, request)
+	s.NoError(err)
+	s.Equal(money.Amount(500), s.orders.orders["order-1"].RefundedAmount)
+
+	for name, request := range map[string]RefundRequest{
+		"unknown order":    {RefundID: "refund-2", OrderID: "order-9"},
+		"refund ID reused": {RefundID: "refund-1", OrderID: "order-1", Amount: 400},
+		"exceeds captured": {RefundID: "refund-2", OrderID: "order-1", Amount: 1501},
+		"exceeds order":    {RefundID: "refund-2", OrderID: "order-1", Items: []RefundItem{{ProductID: "prod-2", Quantity: 2}}},
+	} {
+		_, err := s.env.ExecuteActivity(s.activities.RequestRefund, request)
+
+		var appErr *temporal.ApplicationError
+		s.Require().ErrorAs(err, &appErr, name)
+		s.Equal(ErrorTypeRefundRejected, appErr.Type(), name)
+		s.True(appErr.NonRetryable(), name)
+	}
+}
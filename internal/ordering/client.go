@@ -0,0 +1,229 @@
+package ordering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+)
+
+var (
+	// ErrOrderExists is returned when an order ID has already been used
+	ErrOrderExists = errors.New("order already exists")
+	// ErrOrderNotFound is returned for an order ID no workflow was started for
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderClosed is returned when cancelling an order whose workflow has already finished
+	ErrOrderClosed = errors.New("order already closed")
+)
+
+// OrderClient starts and inspects order workflows. The handlers only depend on this interface,
+// so they can be tested against the SDK's test workflow environment.
+type OrderClient interface {
+	// StartOrder starts OrderWorkflow with the order ID as its workflow ID
+	StartOrder(ctx context.Context, input OrderWorkflowInput) error
+	// GetOrder returns the current state of an order
+	GetOrder(ctx context.Context, orderID string) (OrderWorkflowState, error)
+	// ListOrders returns the orders currently in status
+	ListOrders(ctx context.Context, status string) ([]OrderSummary, error)
+	// InitiatePayment sends the paymentInitiated signal to a running order
+	InitiatePayment(ctx context.Context, orderID string, payment PaymentInitiated) error
+	// CancelOrder requests cancellation of a running order
+	CancelOrder(ctx context.Context, orderID string) error
+	// StartRefund starts RefundWorkflow for a refund of an order
+	StartRefund(ctx context.Context, request RefundRequest) error
+	// GetRefund returns the current state of a refund of an order
+	GetRefund(ctx context.Context, orderID, refundID string) (RefundWorkflowState, error)
+}
+
+// OrderSummary is an order as listed by status
+type OrderSummary struct {
+	OrderID   string    `json:"order_id"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// TemporalOrderClient implements OrderClient with a Temporal client
+type TemporalOrderClient struct {
+	client    client.Client
+	taskQueue string
+}
+
+var _ OrderClient = (*TemporalOrderClient)(nil)
+
+// NewTemporalOrderClient creates an OrderClient starting workflows on taskQueue
+func NewTemporalOrderClient(c client.Client, taskQueue string) *TemporalOrderClient {
+	return &TemporalOrderClient{client: c, taskQueue: taskQueue}
+}
+
+func (c *TemporalOrderClient) StartOrder(ctx context.Context, input OrderWorkflowInput) error {
+	_, err := c.client.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        input.OrderID,
+		TaskQueue: c.taskQueue,
+		// An order ID is never reused, even once its workflow has finished
+		WorkflowIDReusePolicy:                    enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}, WorkflowName, input)
+
+	var started *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &started) {
+		return ErrOrderExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start order workflow: %w", err)
+	}
+	return nil
+}
+
+// GetOrder queries a running order for its live state and reads a finished order's result
+func (c *TemporalOrderClient) GetOrder(ctx context.Context, orderID string) (OrderWorkflowState, error) {
+	var state OrderWorkflowState
+	if err := c.workflowState(ctx, orderID, &state); err != nil {
+		return OrderWorkflowState{}, err
+	}
+	return state, nil
+}
+
+// workflowState decodes into state the live state of a running workflow, queried with
+// QueryGetState, or the result of a finished one
+func (c *TemporalOrderClient) workflowState(ctx context.Context, workflowID string, state any) error {
+	status, err := c.workflowStatus(ctx, workflowID)
+	if err != nil {
+		return err
+	}
+
+	if status == enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		value, err := c.client.QueryWorkflow(ctx, workflowID, "", QueryGetState)
+		if err != nil {
+			return fmt.Errorf("failed to query workflow: %w", err)
+		}
+		if err := value.Get(state); err != nil {
+			return fmt.Errorf("failed to decode workflow state: %w", err)
+		}
+		return nil
+	}
+
+	if err := c.client.GetWorkflow(ctx, workflowID, "").Get(ctx, state); err != nil {
+		return fmt.Errorf("failed to get workflow result: %w", err)
+	}
+	return nil
+}
+
+// ListOrders lists the order workflows whose OrderStatus search attribute is status
+func (c *TemporalOrderClient) ListOrders(ctx context.Context, status string) ([]OrderSummary, error) {
+	query := fmt.Sprintf("WorkflowType = '%s' AND %s = '%s'", WorkflowName, OrderStatusSearchAttribute.GetName(), escapeQuery(status))
+
+	orders := []OrderSummary{}
+	var pageToken []byte
+	for {
+		resp, err := c.client.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			Query:         query,
+			NextPageToken: pageToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list order workflows: %w", err)
+		}
+		for _, execution := range resp.GetExecutions() {
+			orders = append(orders, OrderSummary{
+				OrderID:   execution.GetExecution().GetWorkflowId(),
+				Status:    status,
+				StartedAt: execution.GetStartTime().AsTime(),
+			})
+		}
+		pageToken = resp.GetNextPageToken()
+		if len(pageToken) == 0 {
+			return orders, nil
+		}
+	}
+}
+
+// escapeQuery escapes a value quoted in a visibility query
+func escapeQuery(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+}
+
+func (c *TemporalOrderClient) InitiatePayment(ctx context.Context, orderID string, payment PaymentInitiated) error {
+	if err := c.requireRunning(ctx, orderID); err != nil {
+		return err
+	}
+
+	if err := c.client.SignalWorkflow(ctx, orderID, "", SignalPaymentInitiated, payment); err != nil {
+		return fmt.Errorf("failed to signal order workflow: %w", err)
+	}
+	return nil
+}
+
+func (c *TemporalOrderClient) CancelOrder(ctx context.Context, orderID string) error {
+	if err := c.requireRunning(ctx, orderID); err != nil {
+		return err
+	}
+
+	if err := c.client.CancelWorkflow(ctx, orderID, ""); err != nil {
+		return fmt.Errorf("failed to cancel order workflow: %w", err)
+	}
+	return nil
+}
+
+// StartRefund starts RefundWorkflow with an ID derived from the order and refund IDs, so a
+// refund ID is used once per order
+func (c *TemporalOrderClient) StartRefund(ctx context.Context, request RefundRequest) error {
+	_, err := c.client.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:                                       RefundWorkflowID(request.OrderID, request.RefundID),
+		TaskQueue:                                c.taskQueue,
+		WorkflowIDReusePolicy:                    enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}, RefundWorkflowName, request)
+
+	var started *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &started) {
+		return ErrRefundExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start refund workflow: %w", err)
+	}
+	return nil
+}
+
+// GetRefund queries a running refund for its live state and reads a finished refund's result
+func (c *TemporalOrderClient) GetRefund(ctx context.Context, orderID, refundID string) (RefundWorkflowState, error) {
+	var state RefundWorkflowState
+	err := c.workflowState(ctx, RefundWorkflowID(orderID, refundID), &state)
+	if errors.Is(err, ErrOrderNotFound) {
+		return RefundWorkflowState{}, ErrRefundNotFound
+	}
+	if err != nil {
+		return RefundWorkflowState{}, err
+	}
+	return state, nil
+}
+
+// requireRunning returns ErrOrderClosed unless the order's workflow is still running
+func (c *TemporalOrderClient) requireRunning(ctx context.Context, orderID string) error {
+	status, err := c.workflowStatus(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if status != enums.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		return ErrOrderClosed
+	}
+	return nil
+}
+
+// workflowStatus returns the execution status of the latest run of a workflow, or
+// ErrOrderNotFound
+func (c *TemporalOrderClient) workflowStatus(ctx context.Context, workflowID string) (enums.WorkflowExecutionStatus, error) {
+	resp, err := c.client.DescribeWorkflowExecution(ctx, workflowID, "")
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return enums.WORKFLOW_EXECUTION_STATUS_UNSPECIFIED, ErrOrderNotFound
+	}
+	if err != nil {
+		return enums.WORKFLOW_EXECUTION_STATUS_UNSPECIFIED, fmt.Errorf("failed to describe order workflow: %w", err)
+	}
+	return resp.GetWorkflowExecutionInfo().GetStatus(), nil
+}
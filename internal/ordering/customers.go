@@ -0,0 +1,85 @@
+package ordering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrCustomerNotFound is returned for a customer ID missing from the customers projection
+var ErrCustomerNotFound = errors.New("customer not found")
+
+// Application error types of customers rejected by CreateOrder; neither is retried
+const (
+	ErrorTypeCustomerNotFound = "CustomerNotFound"
+	ErrorTypeCustomerDeleted  = "CustomerDeleted"
+)
+
+// Address is a postal address as published by the customers context
+type Address struct {
+	Line1      string `bson:"line1" json:"line1"`
+	Line2      string `bson:"line2,omitempty" json:"line2,omitempty"`
+	City       string `bson:"city" json:"city"`
+	PostalCode string `bson:"postal_code" json:"postal_code"`
+	Country    string `bson:"country" json:"country"`
+}
+
+// CustomerProjection is a customer as kept in OrderingDB.projection_customers by the customers
+// context's projector
+type CustomerProjection struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Name            string             `bson:"name"`
+	Email           string             `bson:"email"`
+	DeliveryAddress *Address           `bson:"delivery_address"`
+	BillingAddress  *Address           `bson:"billing_address"`
+	Deleted         bool               `bson:"deleted"`
+}
+
+// CustomerSnapshot is the customer as it was when the order was placed. Later changes to the
+// customer do not alter orders already placed.
+type CustomerSnapshot struct {
+	Name            string   `bson:"name" json:"name"`
+	Email           string   `bson:"email" json:"email"`
+	DeliveryAddress *Address `bson:"delivery_address,omitempty" json:"delivery_address,omitempty"`
+	BillingAddress  *Address `bson:"billing_address,omitempty" json:"billing_address,omitempty"`
+}
+
+// CustomerRepository reads the customers projection
+type CustomerRepository interface {
+	// FindCustomer returns a projected customer, deleted or not, or ErrCustomerNotFound
+	FindCustomer(ctx context.Context, customerID string) (*CustomerProjection, error)
+}
+
+// MongoCustomerRepository implements CustomerRepository on OrderingDB.projection_customers
+type MongoCustomerRepository struct {
+	collection *mongo.Collection
+}
+
+var _ CustomerRepository = (*MongoCustomerRepository)(nil)
+
+// NewMongoCustomerRepository creates a customer repository on db's projection_customers collection
+func NewMongoCustomerRepository(db *mongo.Database) *MongoCustomerRepository {
+	return &MongoCustomerRepository{collection: db.Collection("projection_customers")}
+}
+
+func (r *MongoCustomerRepository) FindCustomer(ctx context.Context, customerID string) (*CustomerProjection, error) {
+	id, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		// Customer IDs are ObjectIDs, so no customer can have this one
+		return nil, ErrCustomerNotFound
+	}
+
+	var customer CustomerProjection
+	err = r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&customer)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCustomerNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find customer: %v", err)
+	}
+	return &customer, nil
+}
@@ -0,0 +1,92 @@
+package ordering
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// memoryCustomerRepository is a customers projection keyed on customer ID
+type memoryCustomerRepository map[string]*CustomerProjection
+
+func (r memoryCustomerRepository) FindCustomer(ctx context.Context, customerID string) (*CustomerProjection, error) {
+	customer, ok := r[customerID]
+	if !ok {
+		return nil, ErrCustomerNotFound
+	}
+	return customer, nil
+}
+
+var testDeliveryAddress = &Address{Line1: "1 Harbour Street", City: "Singapore", PostalCode: "049315", Country: "SG"}
+
+// newTestCustomers returns the customers placing orders in the tests: customer-1 and
+// test-customer-1 are active, deleted-customer has been deleted
+func newTestCustomers() memoryCustomerRepository {
+	return memoryCustomerRepository{
+		"customer-1":       {Name: "Ada Lovelace", Email: "ada@example.com", DeliveryAddress: testDeliveryAddress},
+		"test-customer-1":  {Name: "Test User", Email: "test@example.com"},
+		"deleted-customer": {Name: "Gone User", Email: "gone@example.com", Deleted: true},
+	}
+}
+
+type CustomerRepositoryIntegrationTestSuite struct {
+	suite.Suite
+	client     *mongo.Client
+	db         *mongo.Database
+	repository *MongoCustomerRepository
+}
+
+func (s *CustomerRepositoryIntegrationTestSuite) SetupSuite() {
+	if !*integration {
+		s.T().Skip("Skipping integration tests")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		s.T().Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	s.client = client
+	s.db = client.Database("ordering_customers_test")
+	s.repository = NewMongoCustomerRepository(s.db)
+}
+
+func (s *CustomerRepositoryIntegrationTestSuite) TearDownSuite() {
+	if s.client != nil {
+		s.db.Drop(context.Background())
+		s.client.Disconnect(context.Background())
+	}
+}
+
+func TestCustomerRepositoryIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(CustomerRepositoryIntegrationTestSuite))
+}
+
+func (s *CustomerRepositoryIntegrationTestSuite) TestFindCustomer() {
+	ctx := context.Background()
+	id := primitive.NewObjectID()
+	// Written as the customers projector writes it
+	_, err := s.db.Collection("projection_customers").InsertOne(ctx, CustomerProjection{
+		ID:              id,
+		Name:            "Ada Lovelace",
+		Email:           "ada@example.com",
+		DeliveryAddress: testDeliveryAddress,
+	})
+	s.Require().NoError(err)
+
+	customer, err := s.repository.FindCustomer(ctx, id.Hex())
+	s.Require().NoError(err)
+	s.Equal("Ada Lovelace", customer.Name)
+	s.Equal(testDeliveryAddress, customer.DeliveryAddress)
+	s.Nil(customer.BillingAddress)
+	s.False(customer.Deleted)
+
+	_, err = s.repository.FindCustomer(ctx, primitive.NewObjectID().Hex())
+	s.ErrorIs(err, ErrCustomerNotFound)
+
+	_, err = s.repository.FindCustomer(ctx, "customer-1")
+	s.ErrorIs(err, ErrCustomerNotFound)
+}
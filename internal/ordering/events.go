@@ -0,0 +1,183 @@
+package ordering
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"app/internal/cloudevents"
+	"app/internal/money"
+	"app/internal/outbox"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// EventSubjectPrefix is prepended to the event type to form the NATS subject and CloudEvents type
+	EventSubjectPrefix = "ordering."
+	// EventSource is the CloudEvents source of every ordering event
+	EventSource = "/ordering"
+	// EventOrderRefunded is emitted once a refund of an order has been paid out
+	EventOrderRefunded = "OrderRefunded"
+)
+
+// OutboxEvent is an event written to OrderingDB.ordering_outbox in the transaction that
+// changed its order, and relayed to NATS by the ordering module
+type OutboxEvent struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	EventType string             `bson:"event_type" json:"event_type"`
+	// AggregateID is the order the event belongs to; Sequence orders events per order
+	AggregateID   string          `bson:"aggregate_id" json:"aggregate_id"`
+	Sequence      int64           `bson:"sequence" json:"sequence"`
+	SchemaVersion int             `bson:"schema_version" json:"schema_version"`
+	Payload       json.RawMessage `bson:"payload" json:"payload"`
+	Status        string          `bson:"status" json:"status"`
+	// LeaseOwner is the module instance currently relaying the event, until LeaseExpiresAt
+	LeaseOwner     string    `bson:"lease_owner,omitempty" json:"lease_owner,omitempty"`
+	LeaseExpiresAt time.Time `bson:"lease_expires_at,omitempty" json:"lease_expires_at,omitempty"`
+	CreatedAt      time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+const (
+	OutboxStatusPending   = "pending"
+	OutboxStatusProcessed = "processed"
+)
+
+// OrderRefunded is the payload of an OrderRefunded event
+type OrderRefunded struct {
+	OrderID         string       `json:"order_id"`
+	RefundID        string       `json:"refund_id"`
+	CustomerID      string       `json:"customer_id"`
+	PaymentRefundID string       `json:"payment_refund_id,omitempty"`
+	Amount          money.Amount `json:"amount"`
+	// RefundedAmount is the total of the order's completed refunds, this one included
+	RefundedAmount money.Amount `json:"refunded_amount"`
+	CapturedAmount money.Amount `json:"captured_amount"`
+	Items          []RefundItem `json:"items,omitempty"`
+	Reason         string       `json:"reason,omitempty"`
+	// Status is the order's status after the refund, partially_refunded or refunded
+	Status     string    `json:"status"`
+	RefundedAt time.Time `json:"refunded_at"`
+}
+
+// EventSubject returns the NATS subject an event type is published on
+func EventSubject(eventType string) string {
+	return EventSubjectPrefix + eventType
+}
+
+// newOrderRefunded returns the outbox event of a refund paid out, taking the order's refunds to
+// refunded and its status to status; the sequence is assigned when the event is written
+func newOrderRefunded(order *Order, refund Refund, refunded money.Amount, status string, at time.Time) (OutboxEvent, error) {
+	payload, err := json.Marshal(OrderRefunded{
+		OrderID:         order.ID,
+		RefundID:        refund.ID,
+		CustomerID:      order.CustomerID,
+		PaymentRefundID: refund.PaymentRefundID,
+		Amount:          refund.Amount,
+		RefundedAmount:  refunded,
+		CapturedAmount:  order.TotalAmount,
+		Items:           refund.Items,
+		Reason:          refund.Reason,
+		Status:          status,
+		RefundedAt:      at,
+	})
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+
+	event := OutboxEvent{
+		ID:            primitive.NewObjectID(),
+		EventType:     EventOrderRefunded,
+		AggregateID:   order.ID,
+		SchemaVersion: 1,
+		Payload:       payload,
+		Status:        OutboxStatusPending,
+		CreatedAt:     at,
+		UpdatedAt:     at,
+	}
+	if err := validateEvent(event); err != nil {
+		return OutboxEvent{}, err
+	}
+	return event, nil
+}
+
+// newCloudEvent wraps an outbox event in its CloudEvents envelope
+func newCloudEvent(event OutboxEvent) cloudevents.Event {
+	ce := cloudevents.New(event.ID.Hex(), EventSource, EventSubject(event.EventType), event.Payload)
+	ce.Subject = event.AggregateID
+	ce.Time = event.CreatedAt.UTC()
+	ce.Sequence = event.Sequence
+	ce.SchemaVersion = event.SchemaVersion
+	return ce
+}
+
+// RelayConfig controls how the module relays its outbox
+type RelayConfig struct {
+	PollInterval time.Duration
+	// LeaseDuration is how long a claimed event is reserved for this instance
+	LeaseDuration time.Duration
+	// BatchSize is the number of orders relayed per poll, Concurrency how many at once
+	BatchSize   int
+	Concurrency int
+}
+
+// DefaultRelayConfig returns the relay settings used when none are supplied
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		PollInterval:  5 * time.Second,
+		LeaseDuration: 30 * time.Second,
+		BatchSize:     100,
+		Concurrency:   10,
+	}
+}
+
+// pollOutbox relays the outbox every PollInterval until ctx is cancelled
+func (m *Module) pollOutbox(ctx context.Context) {
+	ticker := time.NewTicker(m.relayConfig.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The batch runs to completion even when Stop is called meanwhile
+			if err := m.relay.RunOnce(context.WithoutCancel(ctx), m.relayConfig.BatchSize); err != nil {
+				log.Printf("Error relaying ordering outbox: %v", err)
+			}
+		}
+	}
+}
+
+// publishOutboxEvent publishes a leased event and marks it processed; an error leaves it
+// leased, holding back later events of the order until the lease expires
+func (m *Module) publishOutboxEvent(ctx context.Context, event OutboxEvent) error {
+	msg, err := cloudevents.NewMsg(EventSubject(event.EventType), newCloudEvent(event), cloudevents.Structured)
+	if err != nil {
+		return err
+	}
+	if err := m.publisher.PublishMsg(msg); err != nil {
+		log.Printf("Error publishing ordering event %s: %v", event.ID.Hex(), err)
+		return err
+	}
+
+	result, err := m.relay.Collection.UpdateOne(
+		ctx,
+		outbox.OwnedFilter(event.ID, m.relay.Lease.Owner),
+		bson.M{
+			"$set":   bson.M{"status": OutboxStatusProcessed, "updated_at": time.Now()},
+			"$unset": outbox.ReleaseLease(),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark ordering event processed: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("lease on event %s lost", event.ID.Hex())
+	}
+	return nil
+}
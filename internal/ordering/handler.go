@@ -0,0 +1,208 @@
+package ordering
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// orderAccepted is the body of a 202 response to POST /orders
+type orderAccepted struct {
+	OrderID   string `json:"order_id"`
+	StatusURL string `json:"status_url"`
+}
+
+// refundAccepted is the body of a 202 response to POST /orders/{id}/refunds
+type refundAccepted struct {
+	OrderID   string `json:"order_id"`
+	RefundID  string `json:"refund_id"`
+	StatusURL string `json:"status_url"`
+}
+
+// errorStatus maps an order client error to its HTTP status
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrOrderNotFound), errors.Is(err, ErrRefundNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrOrderExists), errors.Is(err, ErrOrderClosed),
+		errors.Is(err, ErrRefundExists), errors.Is(err, ErrOrderNotRefundable):
+		return http.StatusConflict
+	case errors.Is(err, ErrRefundExceedsCaptured), errors.Is(err, ErrRefundExceedsOrder):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CreateOrder handles POST /orders requests; the order is processed asynchronously and its
+// progress is reported at the returned status URL
+func (m *Module) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	var input OrderWorkflowInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := input.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := m.orders.StartOrder(r.Context(), input); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to place order: %v", err), errorStatus(err))
+		return
+	}
+
+	statusURL := "/orders/" + input.OrderID
+	w.Header().Set("Location", statusURL)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(orderAccepted{OrderID: input.OrderID, StatusURL: statusURL})
+}
+
+// GetOrder handles GET /orders/{id} requests
+func (m *Module) GetOrder(w http.ResponseWriter, r *http.Request) {
+	state, err := m.orders.GetOrder(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get order: %v", err), errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(state)
+}
+
+// orderStatuses are the statuses orders can be listed by
+var orderStatuses = map[string]bool{
+	StatusPending: true, StatusCreationFailed: true, StatusReservationFailed: true,
+	StatusAwaitingPayment: true, StatusCancelledUnpaid: true, StatusPaymentFailed: true,
+	StatusPaymentProcessed: true, StatusReservationExpired: true, StatusFulfillmentFailed: true, StatusFulfillmentProcessed: true,
+	StatusDeliveryFailed: true, StatusCompleted: true, StatusCancelled: true,
+}
+
+// ListOrders handles GET /orders?status= requests, e.g. to find orders stuck in payment_processed
+func (m *Module) ListOrders(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if !orderStatuses[status] {
+		http.Error(w, fmt.Sprintf("Invalid status %q", status), http.StatusBadRequest)
+		return
+	}
+
+	orders, err := m.orders.ListOrders(r.Context(), status)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list orders: %v", err), errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(orders)
+}
+
+// InitiatePayment handles POST /orders/{id}/payment requests, releasing an order waiting for payment
+func (m *Module) InitiatePayment(w http.ResponseWriter, r *http.Request) {
+	var payment PaymentInitiated
+	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := payment.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	orderID := r.PathValue("id")
+	if err := m.orders.InitiatePayment(r.Context(), orderID, payment); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to initiate payment: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Location", "/orders/"+orderID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// CancelOrder handles POST /orders/{id}/cancel requests; the workflow stops at its current step
+func (m *Module) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+	if err := m.orders.CancelOrder(r.Context(), orderID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to cancel order: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Location", "/orders/"+orderID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RequestRefund handles POST /orders/{id}/refunds requests, refunding all or part of a
+// completed order. The refund ID is generated unless the client sends one; resending the
+// request that took a refund ID is answered like it, so retries are safe, while reusing the
+// ID for a different refund is rejected.
+// A refund exceeding what is left of the captured amount is rejected here, and again by
+// RefundWorkflow against refunds started meanwhile.
+func (m *Module) RequestRefund(w http.ResponseWriter, r *http.Request) {
+	var request RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	request.OrderID = r.PathValue("id")
+	if request.RefundID == "" {
+		request.RefundID = primitive.NewObjectID().Hex()
+	}
+	if err := request.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	order, err := m.repository.GetOrder(r.Context(), request.OrderID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get order: %v", err), errorStatus(err))
+		return
+	}
+
+	// A refund already recorded on the order, or whose workflow has already started, was
+	// accepted by an earlier attempt if that attempt asked for the same refund
+	recorded, err := recordedRefund(order, request)
+	if err == nil && recorded == nil {
+		_, err = newRefund(order, request, time.Now())
+		if err == nil {
+			err = m.orders.StartRefund(r.Context(), request)
+		}
+		if errors.Is(err, ErrRefundExists) {
+			err = m.startedRefund(r.Context(), request)
+		}
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to refund order: %v", err), errorStatus(err))
+		return
+	}
+
+	statusURL := fmt.Sprintf("/orders/%s/refunds/%s", request.OrderID, request.RefundID)
+	w.Header().Set("Location", statusURL)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(refundAccepted{OrderID: request.OrderID, RefundID: request.RefundID, StatusURL: statusURL})
+}
+
+// startedRefund checks a request against the refund workflow already started for its refund
+// ID, which may not have recorded the refund on the order yet
+func (m *Module) startedRefund(ctx context.Context, request RefundRequest) error {
+	state, err := m.orders.GetRefund(ctx, request.OrderID, request.RefundID)
+	if err != nil {
+		return err
+	}
+	if !sameRefund(state.Request, request) {
+		return refundConflict(request.RefundID)
+	}
+	return nil
+}
+
+// GetRefund handles GET /orders/{id}/refunds/{refundID} requests
+func (m *Module) GetRefund(w http.ResponseWriter, r *http.Request) {
+	state, err := m.orders.GetRefund(r.Context(), r.PathValue("id"), r.PathValue("refundID"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get refund: %v", err), errorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(state)
+}
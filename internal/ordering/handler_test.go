@@ -0,0 +1,347 @@
+package ordering
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"app/internal/money"
+
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// envOrderClient runs orders in the SDK's test workflow environment, one order per environment.
+// Every refund runs in an environment of its own, created by newEnv.
+type envOrderClient struct {
+	env     *testsuite.TestWorkflowEnvironment
+	orderID string
+	newEnv  func() *testsuite.TestWorkflowEnvironment
+	refunds map[string]*testsuite.TestWorkflowEnvironment
+}
+
+func (c *envOrderClient) StartOrder(ctx context.Context, input OrderWorkflowInput) error {
+	if c.orderID != "" {
+		return ErrOrderExists
+	}
+	c.orderID = input.OrderID
+	// Runs the workflow to completion on the environment's clock
+	c.env.ExecuteWorkflow(OrderWorkflow{}.Execute, input)
+	return nil
+}
+
+func (c *envOrderClient) GetOrder(ctx context.Context, orderID string) (OrderWorkflowState, error) {
+	if orderID != c.orderID {
+		return OrderWorkflowState{}, ErrOrderNotFound
+	}
+	var state OrderWorkflowState
+	if !c.env.IsWorkflowCompleted() {
+		value, err := c.env.QueryWorkflow(QueryGetState)
+		if err != nil {
+			return state, err
+		}
+		err = value.Get(&state)
+		return state, err
+	}
+	err := c.env.GetWorkflowResult(&state)
+	return state, err
+}
+
+func (c *envOrderClient) ListOrders(ctx context.Context, status string) ([]OrderSummary, error) {
+	orders := []OrderSummary{}
+	if c.orderID == "" {
+		return orders, nil
+	}
+	state, err := c.GetOrder(ctx, c.orderID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Status == status {
+		orders = append(orders, OrderSummary{OrderID: state.OrderID, Status: state.Status})
+	}
+	return orders, nil
+}
+
+func (c *envOrderClient) InitiatePayment(ctx context.Context, orderID string, payment PaymentInitiated) error {
+	if orderID != c.orderID {
+		return ErrOrderNotFound
+	}
+	if c.env.IsWorkflowCompleted() {
+		return ErrOrderClosed
+	}
+	c.env.SignalWorkflow(SignalPaymentInitiated, payment)
+	return nil
+}
+
+func (c *envOrderClient) CancelOrder(ctx context.Context, orderID string) error {
+	if orderID != c.orderID {
+		return ErrOrderNotFound
+	}
+	if c.env.IsWorkflowCompleted() {
+		return ErrOrderClosed
+	}
+	c.env.CancelWorkflow()
+	return nil
+}
+
+func (c *envOrderClient) StartRefund(ctx context.Context, request RefundRequest) error {
+	id := RefundWorkflowID(request.OrderID, request.RefundID)
+	if _, ok := c.refunds[id]; ok {
+		return ErrRefundExists
+	}
+	env := c.newEnv()
+	c.refunds[id] = env
+	env.ExecuteWorkflow(RefundWorkflow{}.Execute, request)
+	return nil
+}
+
+func (c *envOrderClient) GetRefund(ctx context.Context, orderID, refundID string) (RefundWorkflowState, error) {
+	env, ok := c.refunds[RefundWorkflowID(orderID, refundID)]
+	if !ok {
+		return RefundWorkflowState{}, ErrRefundNotFound
+	}
+	var state RefundWorkflowState
+	err := env.GetWorkflowResult(&state)
+	return state, err
+}
+
+type OrderHandlerTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env       *testsuite.TestWorkflowEnvironment
+	orders    *memoryOrderRepository
+	inventory *memoryInventory
+	mux       *http.ServeMux
+}
+
+func (s *OrderHandlerTestSuite) SetupTest() {
+	s.orders = newMemoryOrderRepository()
+	s.inventory = newTestInventory()
+	activities := NewActivities(s.orders, newTestCustomers(), s.inventory, newTestCatalogue())
+	newEnv := func() *testsuite.TestWorkflowEnvironment {
+		env := s.NewTestWorkflowEnvironment()
+		env.RegisterActivity(activities)
+		return env
+	}
+	s.env = newEnv()
+
+	orders := &envOrderClient{env: s.env, newEnv: newEnv, refunds: make(map[string]*testsuite.TestWorkflowEnvironment)}
+	module := &Module{orders: orders, repository: s.orders}
+	s.mux = http.NewServeMux()
+	for _, route := range module.HTTPHandlers(nil) {
+		s.mux.HandleFunc(route.Method+" "+route.Path, route.Handler)
+	}
+}
+
+func TestOrderHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(OrderHandlerTestSuite))
+}
+
+func (s *OrderHandlerTestSuite) serve(method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func (s *OrderHandlerTestSuite) getOrder(orderID string) OrderWorkflowState {
+	rec := s.serve(http.MethodGet, "/orders/"+orderID, "")
+	s.Require().Equal(http.StatusOK, rec.Code)
+
+	var state OrderWorkflowState
+	s.Require().NoError(json.NewDecoder(rec.Body).Decode(&state))
+	return state
+}
+
+// payAfter pays for order-1 over HTTP once the workflow has run for after
+func (s *OrderHandlerTestSuite) payAfter(after time.Duration) {
+	s.env.RegisterDelayedCallback(func() {
+		rec := s.serve(http.MethodPost, "/orders/order-1/payment", `{"method":"card","reference":"psp-123"}`)
+		s.Equal(http.StatusAccepted, rec.Code)
+	}, after)
+}
+
+const orderBody = `{"order_id":"order-1","customer_id":"customer-1","items":[{"product_id":"prod-1","quantity":2,"unit_price":1000,"total_price":2000}],"total_amount":2000}`
+
+func (s *OrderHandlerTestSuite) Test_PlaceOrder() {
+	s.payAfter(time.Minute)
+	rec := s.serve(http.MethodPost, "/orders", orderBody)
+
+	s.Equal(http.StatusAccepted, rec.Code)
+	s.Equal("/orders/order-1", rec.Header().Get("Location"))
+	s.JSONEq(`{"order_id":"order-1","status_url":"/orders/order-1"}`, rec.Body.String())
+
+	state := s.getOrder("order-1")
+	s.Equal(StatusCompleted, state.Status)
+	s.Equal("payment-order-1", state.PaymentID)
+	s.Equal("psp-123", state.PaymentReference)
+
+	// The order ID is the workflow ID, so it cannot be placed twice
+	rec = s.serve(http.MethodPost, "/orders", orderBody)
+	s.Equal(http.StatusConflict, rec.Code)
+}
+
+func (s *OrderHandlerTestSuite) Test_RejectsInvalidOrder() {
+	rec := s.serve(http.MethodPost, "/orders", `{"order_id":"order-1","customer_id":"customer-1","items":[]}`)
+	s.Equal(http.StatusUnprocessableEntity, rec.Code)
+	s.Contains(rec.Body.String(), "no items")
+
+	rec = s.serve(http.MethodPost, "/orders", `{"order_id":"order-1","customer_id":"customer-1","items":[{"product_id":"prod-1","quantity":0}]}`)
+	s.Equal(http.StatusUnprocessableEntity, rec.Code)
+	s.Contains(rec.Body.String(), "quantity")
+
+	rec = s.serve(http.MethodPost, "/orders", `{"order_id":`)
+	s.Equal(http.StatusBadRequest, rec.Code)
+
+	s.False(s.env.IsWorkflowCompleted())
+}
+
+func (s *OrderHandlerTestSuite) Test_CancelOrder() {
+	// The order is cancelled while it waits for payment
+	s.env.RegisterDelayedCallback(func() {
+		// The live state is queried from the running workflow
+		s.Equal(StatusAwaitingPayment, s.getOrder("order-1").Status)
+		rec := s.serve(http.MethodGet, "/orders?status=awaiting_payment", "")
+		s.Contains(rec.Body.String(), `"order_id":"order-1","status":"awaiting_payment"`)
+
+		rec = s.serve(http.MethodPost, "/orders/order-1/cancel", "")
+		s.Equal(http.StatusAccepted, rec.Code)
+	}, time.Minute)
+
+	rec := s.serve(http.MethodPost, "/orders", orderBody)
+	s.Require().Equal(http.StatusAccepted, rec.Code)
+
+	state := s.getOrder("order-1")
+	s.Equal(StatusCancelled, state.Status)
+	s.Empty(state.PaymentID)
+	s.Equal([]Compensation{{Name: CompensationReleaseReservation}}, state.Compensations)
+
+	// A closed order cannot be cancelled again
+	rec = s.serve(http.MethodPost, "/orders/order-1/cancel", "")
+	s.Equal(http.StatusConflict, rec.Code)
+}
+
+func (s *OrderHandlerTestSuite) Test_ListOrdersByStatus() {
+	s.payAfter(time.Minute)
+	rec := s.serve(http.MethodPost, "/orders", orderBody)
+	s.Require().Equal(http.StatusAccepted, rec.Code)
+
+	rec = s.serve(http.MethodGet, "/orders?status=completed", "")
+	s.Equal(http.StatusOK, rec.Code)
+	s.Contains(rec.Body.String(), `"order_id":"order-1"`)
+
+	rec = s.serve(http.MethodGet, "/orders?status=payment_processed", "")
+	s.JSONEq(`[]`, rec.Body.String())
+
+	rec = s.serve(http.MethodGet, "/orders?status=lost", "")
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (s *OrderHandlerTestSuite) Test_UnpaidOrder() {
+	s.env.RegisterDelayedCallback(func() {
+		rec := s.serve(http.MethodPost, "/orders/order-1/payment", `{"method":"card"}`)
+		s.Equal(http.StatusUnprocessableEntity, rec.Code)
+	}, time.Minute)
+
+	rec := s.serve(http.MethodPost, "/orders", orderBody)
+	s.Require().Equal(http.StatusAccepted, rec.Code)
+
+	// An invalid payment is never signalled, so the order times out
+	s.Equal(StatusCancelledUnpaid, s.getOrder("order-1").Status)
+
+	rec = s.serve(http.MethodPost, "/orders/order-1/payment", `{"method":"card","reference":"psp-123"}`)
+	s.Equal(http.StatusConflict, rec.Code)
+}
+
+func (s *OrderHandlerTestSuite) Test_UnknownOrder() {
+	s.Equal(http.StatusNotFound, s.serve(http.MethodGet, "/orders/order-9", "").Code)
+	s.Equal(http.StatusNotFound, s.serve(http.MethodPost, "/orders/order-9/cancel", "").Code)
+	s.Equal(http.StatusNotFound, s.serve(http.MethodPost, "/orders/order-9/payment", `{"method":"card","reference":"psp-123"}`).Code)
+}
+
+func (s *OrderHandlerTestSuite) Test_RefundOrder() {
+	s.payAfter(time.Minute)
+	rec := s.serve(http.MethodPost, "/orders", orderBody)
+	s.Require().Equal(http.StatusAccepted, rec.Code)
+	s.Require().Equal(StatusCompleted, s.getOrder("order-1").Status)
+	s.Equal(98, s.inventory.available("prod-1"))
+
+	// A partial refund returning one of the two items ordered
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{"refund_id":"refund-1","amount":500,"items":[{"product_id":"prod-1","quantity":1}],"reason":"damaged"}`)
+	s.Require().Equal(http.StatusAccepted, rec.Code)
+	s.Equal("/orders/order-1/refunds/refund-1", rec.Header().Get("Location"))
+	s.JSONEq(`{"order_id":"order-1","refund_id":"refund-1","status_url":"/orders/order-1/refunds/refund-1"}`, rec.Body.String())
+
+	rec = s.serve(http.MethodGet, "/orders/order-1/refunds/refund-1", "")
+	s.Equal(http.StatusOK, rec.Code)
+	s.Contains(rec.Body.String(), `"status":"completed"`)
+	s.Equal(99, s.inventory.available("prod-1"))
+	s.Equal(StatusPartiallyRefunded, s.orders.orders["order-1"].Status)
+
+	// Refunds never exceed the captured amount
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{"refund_id":"refund-2","amount":1501}`)
+	s.Equal(http.StatusUnprocessableEntity, rec.Code)
+	s.Contains(rec.Body.String(), "refund exceeds captured amount")
+	// Nor return more items than were ordered
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{"refund_id":"refund-2","items":[{"product_id":"prod-1","quantity":2}]}`)
+	s.Equal(http.StatusUnprocessableEntity, rec.Code)
+	// Resending a refund ID is accepted again without refunding twice
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{"refund_id":"refund-1","amount":500,"items":[{"product_id":"prod-1","quantity":1}],"reason":"damaged"}`)
+	s.Equal(http.StatusAccepted, rec.Code)
+	s.Equal("/orders/order-1/refunds/refund-1", rec.Header().Get("Location"))
+	s.Len(s.orders.orders["order-1"].Refunds, 1)
+	s.Equal(money.Amount(500), s.orders.orders["order-1"].RefundedAmount)
+	s.Equal(99, s.inventory.available("prod-1"))
+	// Reusing it for a different refund is a conflict
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{"refund_id":"refund-1","amount":100}`)
+	s.Equal(http.StatusConflict, rec.Code)
+	s.Contains(rec.Body.String(), "refund already exists")
+	s.Len(s.orders.orders["order-1"].Refunds, 1)
+	s.Equal(money.Amount(500), s.orders.orders["order-1"].RefundedAmount)
+
+	// Without an amount the rest of the captured amount is refunded
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{}`)
+	s.Require().Equal(http.StatusAccepted, rec.Code)
+	var accepted refundAccepted
+	s.Require().NoError(json.NewDecoder(rec.Body).Decode(&accepted))
+	s.NotEmpty(accepted.RefundID)
+
+	order := s.orders.orders["order-1"]
+	s.Equal(StatusRefunded, order.Status)
+	s.Equal(order.TotalAmount, order.RefundedAmount)
+	s.Len(s.orders.events, 2)
+
+	// A fully refunded order cannot be refunded again
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{"amount":1}`)
+	s.Equal(http.StatusConflict, rec.Code)
+}
+
+func (s *OrderHandlerTestSuite) Test_RejectsInvalidRefund() {
+	rec := s.serve(http.MethodPost, "/orders/order-9/refunds", `{}`)
+	s.Equal(http.StatusNotFound, rec.Code)
+
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{"amount":`)
+	s.Equal(http.StatusBadRequest, rec.Code)
+
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{"amount":-1}`)
+	s.Equal(http.StatusUnprocessableEntity, rec.Code)
+
+	// An order that was never paid has nothing to refund
+	s.env.RegisterDelayedCallback(func() {
+		rec := s.serve(http.MethodPost, "/orders/order-1/cancel", "")
+		s.Equal(http.StatusAccepted, rec.Code)
+	}, time.Minute)
+	rec = s.serve(http.MethodPost, "/orders", orderBody)
+	s.Require().Equal(http.StatusAccepted, rec.Code)
+
+	rec = s.serve(http.MethodPost, "/orders/order-1/refunds", `{}`)
+	s.Equal(http.StatusConflict, rec.Code)
+	s.Contains(rec.Body.String(), "order not refundable")
+
+	rec = s.serve(http.MethodGet, "/orders/order-1/refunds/refund-1", "")
+	s.Equal(http.StatusNotFound, rec.Code)
+}
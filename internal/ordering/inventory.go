@@ -0,0 +1,49 @@
+package ordering
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrInsufficientStock is returned by Inventory when a product is short of the quantity ordered
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrReservationExpired is returned by Inventory when committing a reservation whose stock
+	// has returned to inventory
+	ErrReservationExpired = errors.New("reservation expired")
+)
+
+// Application error types of reservations rejected by the inventory; neither is retried
+const (
+	ErrorTypeInsufficientStock  = "InsufficientStock"
+	ErrorTypeReservationExpired = "ReservationExpired"
+)
+
+// ReservationTTL is how long reserved stock is held. It outlasts the wait for payment, so only
+// reservations of orders abandoned without compensation, such as terminated workflows, expire.
+const ReservationTTL = PaymentTimeout + 24*time.Hour
+
+// Inventory holds stock for orders. The inventory module implements it; main adapts it, so
+// ordering does not depend on the inventory package.
+type Inventory interface {
+	// Reserve takes quantities, keyed by product ID, out of the available stock until
+	// expiresAt, all or nothing. Reserving a reservation ID again changes nothing.
+	Reserve(ctx context.Context, reservationID string, quantities map[string]int, expiresAt time.Time) error
+	// Release returns a reservation's stock; releasing twice, or after expiry, changes nothing
+	Release(ctx context.Context, reservationID string) error
+	// Commit keeps a reservation's stock for good, so it no longer expires
+	Commit(ctx context.Context, reservationID string) error
+	// Restock returns quantities, keyed by product ID, to the available stock, e.g. the items
+	// of a refund. Restocking a restock ID again changes nothing.
+	Restock(ctx context.Context, restockID string, quantities map[string]int) error
+}
+
+// reservationQuantities totals the quantity ordered of each product
+func reservationQuantities(items []OrderItem) map[string]int {
+	quantities := make(map[string]int, len(items))
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	return quantities
+}
@@ -0,0 +1,113 @@
+package ordering
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memoryInventory holds stock in memory with the same rules as the inventory module
+type memoryInventory struct {
+	mu           sync.Mutex
+	stock        map[string]int
+	reservations map[string]*memoryReservation
+	restocks     map[string]bool
+}
+
+type memoryReservation struct {
+	quantities map[string]int
+	status     string
+	expiresAt  time.Time
+}
+
+// newTestInventory stocks the products ordered in the tests
+func newTestInventory() *memoryInventory {
+	return &memoryInventory{
+		stock:        map[string]int{"prod-1": 100, "prod-2": 1, "test-product-1": 100},
+		reservations: make(map[string]*memoryReservation),
+		restocks:     make(map[string]bool),
+	}
+}
+
+func (i *memoryInventory) Reserve(ctx context.Context, reservationID string, quantities map[string]int, expiresAt time.Time) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.reservations[reservationID]; ok {
+		return nil
+	}
+	for productID, quantity := range quantities {
+		if i.stock[productID] < quantity {
+			return fmt.Errorf("%w: product %s", ErrInsufficientStock, productID)
+		}
+	}
+	for productID, quantity := range quantities {
+		i.stock[productID] -= quantity
+	}
+	i.reservations[reservationID] = &memoryReservation{quantities: quantities, status: "reserved", expiresAt: expiresAt}
+	return nil
+}
+
+func (i *memoryInventory) Release(ctx context.Context, reservationID string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.close(reservationID, "released")
+	return nil
+}
+
+func (i *memoryInventory) Commit(ctx context.Context, reservationID string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	reservation, ok := i.reservations[reservationID]
+	if !ok {
+		return fmt.Errorf("reservation %s not found", reservationID)
+	}
+	switch reservation.status {
+	case "reserved", "committed":
+		reservation.status = "committed"
+		return nil
+	default:
+		return fmt.Errorf("%w: reservation %s is %s", ErrReservationExpired, reservationID, reservation.status)
+	}
+}
+
+func (i *memoryInventory) Restock(ctx context.Context, restockID string, quantities map[string]int) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.restocks[restockID] {
+		return nil
+	}
+	for productID, quantity := range quantities {
+		i.stock[productID] += quantity
+	}
+	i.restocks[restockID] = true
+	return nil
+}
+
+// expire returns the stock of reservations not committed by now
+func (i *memoryInventory) expire(now time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for id, reservation := range i.reservations {
+		if reservation.status == "reserved" && !reservation.expiresAt.After(now) {
+			i.close(id, "expired")
+		}
+	}
+}
+
+func (i *memoryInventory) close(reservationID, status string) {
+	reservation, ok := i.reservations[reservationID]
+	if !ok || (reservation.status != "reserved" && reservation.status != "committed") {
+		return
+	}
+	for productID, quantity := range reservation.quantities {
+		i.stock[productID] += quantity
+	}
+	reservation.status = status
+}
+
+func (i *memoryInventory) available(productID string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stock[productID]
+}
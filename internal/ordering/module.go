@@ -0,0 +1,130 @@
+package ordering
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"app/internal/modulith"
+	"app/internal/outbox"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	_ modulith.Module    = (*Module)(nil)
+	_ modulith.Lifecycle = (*Module)(nil)
+)
+
+// Module plugs the ordering context into the modulith host. Orders are processed by
+// OrderWorkflow and refunded by RefundWorkflow; the module owns OrderingDB, where the
+// customer projection is kept, and relays the events the workflows write to its outbox.
+type Module struct {
+	db     *mongo.Database
+	orders OrderClient
+	// repository reads stored orders, so refunds are checked before a workflow is started
+	repository  OrderRepository
+	publisher   modulith.Publisher
+	relay       *outbox.OrderedRelay[OutboxEvent]
+	relayConfig RelayConfig
+	// stopRelay cancels the poll loop started by Start; relaying tracks it until it returns
+	stopRelay context.CancelFunc
+	relaying  sync.WaitGroup
+}
+
+// NewModule creates an uninitialised ordering module
+func NewModule() *Module {
+	return &Module{relayConfig: DefaultRelayConfig()}
+}
+
+func (m *Module) Name() string {
+	return "ordering"
+}
+
+// Init expects "db" (OrderingDB) and "orders" (OrderClient), through which orders are
+// started on the ordering worker's task queue; "relay" (RelayConfig) is optional
+func (m *Module) Init(config map[string]any) error {
+	db, ok := config["db"].(*mongo.Database)
+	if !ok {
+		return fmt.Errorf("invalid db configuration")
+	}
+	orders, ok := config["orders"].(OrderClient)
+	if !ok {
+		return fmt.Errorf("invalid orders configuration")
+	}
+	if relay, ok := config["relay"].(RelayConfig); ok {
+		m.relayConfig = relay
+	}
+	m.db = db
+	m.orders = orders
+	m.repository = NewMongoOrderRepository(db)
+	m.relay = &outbox.OrderedRelay[OutboxEvent]{
+		Collection: db.Collection("ordering_outbox"),
+		Lease: outbox.Lease{
+			Owner:    outbox.NewOwnerID(),
+			Duration: m.relayConfig.LeaseDuration,
+		},
+		Unfinished:  []string{OutboxStatusPending},
+		Concurrency: m.relayConfig.Concurrency,
+		Process:     m.publishOutboxEvent,
+	}
+	return nil
+}
+
+// HTTPHandlers returns the order routes
+func (m *Module) HTTPHandlers(pub modulith.Publisher) []modulith.HTTPHandler {
+	m.publisher = pub
+	return []modulith.HTTPHandler{
+		{Method: http.MethodPost, Path: "/orders", Handler: m.CreateOrder},
+		{Method: http.MethodGet, Path: "/orders", Handler: m.ListOrders},
+		{Method: http.MethodGet, Path: "/orders/{id}", Handler: m.GetOrder},
+		{Method: http.MethodPost, Path: "/orders/{id}/payment", Handler: m.InitiatePayment},
+		{Method: http.MethodPost, Path: "/orders/{id}/cancel", Handler: m.CancelOrder},
+		{Method: http.MethodPost, Path: "/orders/{id}/refunds", Handler: m.RequestRefund},
+		{Method: http.MethodGet, Path: "/orders/{id}/refunds/{refundID}", Handler: m.GetRefund},
+	}
+}
+
+// MsgHandlers returns no subscriptions yet
+func (m *Module) MsgHandlers(pub modulith.Publisher) []modulith.MsgHandler {
+	return nil
+}
+
+// Start relays the outbox in the background until Stop is called; the workflows write events
+// from the worker process, so the relay polls for them
+func (m *Module) Start(ctx context.Context) error {
+	if m.relay == nil {
+		return fmt.Errorf("module not initialised")
+	}
+
+	relayCtx, cancel := context.WithCancel(context.Background())
+	m.stopRelay = cancel
+
+	m.relaying.Add(1)
+	go func() {
+		defer m.relaying.Done()
+		m.pollOutbox(relayCtx)
+	}()
+	return nil
+}
+
+// Stop ends the poll loop and waits for the outbox batch in flight, returning ctx's error if
+// the deadline passes first
+func (m *Module) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		if m.stopRelay != nil {
+			m.stopRelay()
+		}
+		m.relaying.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
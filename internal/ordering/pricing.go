@@ -0,0 +1,90 @@
+package ordering
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"app/internal/money"
+)
+
+var (
+	// ErrUnknownProduct is returned when an order item names a product missing from the catalogue
+	ErrUnknownProduct = errors.New("unknown product")
+	// ErrPriceMismatch is returned when an order's prices disagree with the catalogue
+	ErrPriceMismatch = errors.New("price mismatch")
+)
+
+// Application error types of orders rejected by CreateOrder before they are stored; none is retried
+const (
+	ErrorTypeInvalidOrder   = "InvalidOrder"
+	ErrorTypeUnknownProduct = "UnknownProduct"
+	ErrorTypePriceMismatch  = "PriceMismatch"
+)
+
+// Catalogue prices products. The inventory module's product catalogue implements it.
+type Catalogue interface {
+	// Prices returns the unit price of each product found in the catalogue; unknown products are left out
+	Prices(ctx context.Context, productIDs []string) (map[string]money.Amount, error)
+}
+
+// priceOrder recomputes every line total and the order total from the catalogue's unit prices.
+// Prices the client left at zero are filled in; any other price that disagrees with the
+// recomputed one means the order was tampered with and returns ErrPriceMismatch.
+func priceOrder(input OrderWorkflowInput, prices map[string]money.Amount) (OrderWorkflowInput, error) {
+	priced := input
+	priced.Items = make([]OrderItem, len(input.Items))
+	var total money.Amount
+	for i, item := range input.Items {
+		unitPrice, ok := prices[item.ProductID]
+		if !ok {
+			return input, fmt.Errorf("%w: %s", ErrUnknownProduct, item.ProductID)
+		}
+		lineTotal, err := unitPrice.Times(item.Quantity)
+		if err != nil {
+			return input, fmt.Errorf("pricing product %s: %w", item.ProductID, err)
+		}
+		if item.UnitPrice != 0 && item.UnitPrice != unitPrice {
+			return input, fmt.Errorf("%w: unit price of product %s is %s, not %s",
+				ErrPriceMismatch, item.ProductID, unitPrice, item.UnitPrice)
+		}
+		if item.TotalPrice != 0 && item.TotalPrice != lineTotal {
+			return input, fmt.Errorf("%w: total price of %d x product %s is %s, not %s",
+				ErrPriceMismatch, item.Quantity, item.ProductID, lineTotal, item.TotalPrice)
+		}
+		if total, err = total.Add(lineTotal); err != nil {
+			return input, fmt.Errorf("pricing order: %w", err)
+		}
+		priced.Items[i] = OrderItem{ProductID: item.ProductID, Quantity: item.Quantity, UnitPrice: unitPrice, TotalPrice: lineTotal}
+	}
+	if input.TotalAmount != 0 && input.TotalAmount != total {
+		return input, fmt.Errorf("%w: order total is %s, not %s", ErrPriceMismatch, total, input.TotalAmount)
+	}
+	priced.TotalAmount = total
+	return priced, nil
+}
+
+// pricingErrorType returns the application error type of an error of priceOrder
+func pricingErrorType(err error) string {
+	switch {
+	case errors.Is(err, ErrUnknownProduct):
+		return ErrorTypeUnknownProduct
+	case errors.Is(err, ErrPriceMismatch):
+		return ErrorTypePriceMismatch
+	default:
+		return ErrorTypeInvalidOrder
+	}
+}
+
+// productIDs lists the distinct products of an order
+func productIDs(items []OrderItem) []string {
+	seen := make(map[string]bool, len(items))
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		if !seen[item.ProductID] {
+			seen[item.ProductID] = true
+			ids = append(ids, item.ProductID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,113 @@
+package ordering
+
+import (
+	"context"
+	"testing"
+
+	"app/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryCatalogue prices products from a map of unit prices
+type memoryCatalogue map[string]money.Amount
+
+// newTestCatalogue prices the products ordered in the tests
+func newTestCatalogue() memoryCatalogue {
+	return memoryCatalogue{"prod-1": 1000, "prod-2": 500, "test-product-1": 1000}
+}
+
+func (c memoryCatalogue) Prices(ctx context.Context, productIDs []string) (map[string]money.Amount, error) {
+	prices := make(map[string]money.Amount, len(productIDs))
+	for _, id := range productIDs {
+		if price, ok := c[id]; ok {
+			prices[id] = price
+		}
+	}
+	return prices, nil
+}
+
+func TestPriceOrder(t *testing.T) {
+	prices := newTestCatalogue()
+	order := func() OrderWorkflowInput {
+		return OrderWorkflowInput{
+			OrderID:    "order-1",
+			CustomerID: "customer-1",
+			Items: []OrderItem{
+				{ProductID: "prod-1", Quantity: 2, UnitPrice: 1000, TotalPrice: 2000},
+				{ProductID: "prod-2", Quantity: 1, UnitPrice: 500, TotalPrice: 500},
+			},
+			TotalAmount: 2500,
+		}
+	}
+
+	t.Run("accepts matching prices", func(t *testing.T) {
+		priced, err := priceOrder(order(), prices)
+		require.NoError(t, err)
+		assert.Equal(t, order(), priced)
+	})
+
+	t.Run("fills in missing prices", func(t *testing.T) {
+		input := order()
+		input.Items[0].UnitPrice, input.Items[0].TotalPrice = 0, 0
+		input.Items[1].TotalPrice = 0
+		input.TotalAmount = 0
+
+		priced, err := priceOrder(input, prices)
+		require.NoError(t, err)
+		assert.Equal(t, order(), priced)
+		assert.Zero(t, input.Items[0].UnitPrice, "the input is left as it was")
+	})
+
+	t.Run("rejects a tampered unit price", func(t *testing.T) {
+		input := order()
+		input.Items[0].UnitPrice = 1
+		input.Items[0].TotalPrice = 2
+		input.TotalAmount = 502
+
+		_, err := priceOrder(input, prices)
+		assert.ErrorIs(t, err, ErrPriceMismatch)
+		assert.Equal(t, ErrorTypePriceMismatch, pricingErrorType(err))
+	})
+
+	t.Run("rejects a tampered line total", func(t *testing.T) {
+		input := order()
+		input.Items[0].TotalPrice = 1000
+		input.TotalAmount = 1500
+
+		_, err := priceOrder(input, prices)
+		assert.ErrorIs(t, err, ErrPriceMismatch)
+	})
+
+	t.Run("rejects a tampered order total", func(t *testing.T) {
+		input := order()
+		input.TotalAmount = 100
+
+		_, err := priceOrder(input, prices)
+		assert.ErrorIs(t, err, ErrPriceMismatch)
+	})
+
+	t.Run("rejects an unknown product", func(t *testing.T) {
+		input := order()
+		input.Items[1].ProductID = "prod-unknown"
+
+		_, err := priceOrder(input, prices)
+		assert.ErrorIs(t, err, ErrUnknownProduct)
+		assert.Equal(t, ErrorTypeUnknownProduct, pricingErrorType(err))
+	})
+
+	t.Run("rejects an overflowing total", func(t *testing.T) {
+		input := order()
+		input.Items[0].UnitPrice, input.Items[0].TotalPrice, input.TotalAmount = 0, 0, 0
+
+		_, err := priceOrder(input, memoryCatalogue{"prod-1": money.Amount(1) << 62, "prod-2": 500})
+		assert.ErrorIs(t, err, money.ErrOverflow)
+		assert.Equal(t, ErrorTypeInvalidOrder, pricingErrorType(err))
+	})
+}
+
+func TestProductIDs(t *testing.T) {
+	items := []OrderItem{{ProductID: "prod-1"}, {ProductID: "prod-2"}, {ProductID: "prod-1"}}
+	assert.Equal(t, []string{"prod-1", "prod-2"}, productIDs(items))
+}
@@ -0,0 +1,355 @@
+package ordering
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"app/internal/money"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Statuses of a completed order once it has been refunded, in part or in full
+const (
+	StatusPartiallyRefunded = "partially_refunded"
+	StatusRefunded          = "refunded"
+)
+
+// Refund statuses recorded on the order and in RefundWorkflowState
+const (
+	// RefundStatusRequested holds the refund's amount against the captured amount until it is paid out
+	RefundStatusRequested = "requested"
+	RefundStatusCompleted = "completed"
+	RefundStatusFailed    = "failed"
+	// RefundStatusRejected refunds were never recorded on the order
+	RefundStatusRejected = "rejected"
+)
+
+var (
+	// ErrRefundNotFound is returned for a refund ID no workflow was started for
+	ErrRefundNotFound = errors.New("refund not found")
+	// ErrRefundExists is returned when a refund ID has already been used for the order; retrying
+	// the request that used it is not an error
+	ErrRefundExists = errors.New("refund already exists")
+	// ErrOrderNotRefundable is returned when refunding an order that has not been completed
+	ErrOrderNotRefundable = errors.New("order not refundable")
+	// ErrRefundExceedsCaptured is returned when a refund would take the refunds of an order
+	// past the amount captured from the customer
+	ErrRefundExceedsCaptured = errors.New("refund exceeds captured amount")
+	// ErrRefundExceedsOrder is returned when a refund returns items the order does not hold
+	ErrRefundExceedsOrder = errors.New("refund exceeds ordered items")
+)
+
+// ErrorTypeRefundRejected is the application error type of refunds RequestRefund turns down;
+// it is not retried
+const ErrorTypeRefundRejected = "RefundRejected"
+
+// RefundItem is a quantity of an ordered product returned to inventory by a refund
+type RefundItem struct {
+	ProductID string `bson:"product_id" json:"product_id"`
+	Quantity  int    `bson:"quantity" json:"quantity"`
+}
+
+// RefundRequest is the input of RefundWorkflow
+type RefundRequest struct {
+	RefundID string `json:"refund_id"`
+	OrderID  string `json:"order_id"`
+	// Amount is paid back to the customer; zero refunds all of the captured amount not yet refunded
+	Amount money.Amount `json:"amount"`
+	// Items are returned to inventory; a refund need not return any
+	Items  []RefundItem `json:"items,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+}
+
+// Validate rejects a refund request that cannot be refunded whatever the order
+func (r RefundRequest) Validate() error {
+	if r.RefundID == "" {
+		return errors.New("invalid refund: missing refund ID")
+	}
+	if r.OrderID == "" {
+		return errors.New("invalid refund: missing order ID")
+	}
+	if r.Amount < 0 {
+		return errors.New("invalid refund: negative amount")
+	}
+	for _, item := range r.Items {
+		if item.ProductID == "" {
+			return errors.New("invalid refund: item without product ID")
+		}
+		if item.Quantity <= 0 {
+			return fmt.Errorf("invalid refund: quantity %d of product %s is not positive", item.Quantity, item.ProductID)
+		}
+	}
+	return nil
+}
+
+// Refund is a refund of an order, as stored on the order
+type Refund struct {
+	ID string `bson:"refund_id" json:"refund_id"`
+	// PaymentID is the captured payment the refund is paid back from
+	PaymentID string       `bson:"payment_id" json:"payment_id"`
+	Amount    money.Amount `bson:"amount" json:"amount"`
+	// RequestedAmount is the amount the refund was requested with; zero when it asked for the
+	// rest of the captured amount, which Amount then holds
+	RequestedAmount money.Amount `bson:"requested_amount,omitempty" json:"requested_amount,omitempty"`
+	Items           []RefundItem `bson:"items,omitempty" json:"items,omitempty"`
+	Reason          string       `bson:"reason,omitempty" json:"reason,omitempty"`
+	Status          string       `bson:"status" json:"status"`
+	// PaymentRefundID identifies the refund with the payment provider once it is paid out
+	PaymentRefundID string    `bson:"payment_refund_id,omitempty" json:"payment_refund_id,omitempty"`
+	Error           string    `bson:"error,omitempty" json:"error,omitempty"`
+	RequestedAt     time.Time `bson:"requested_at" json:"requested_at"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// RefundCompletion marks a requested refund as paid out
+type RefundCompletion struct {
+	OrderID         string
+	RefundID        string
+	PaymentRefundID string
+	// At is the workflow time of the completion, so a retried write records the same time
+	At time.Time
+}
+
+// RefundFailure marks a requested refund as failed, releasing its amount
+type RefundFailure struct {
+	OrderID  string
+	RefundID string
+	Error    string
+	At       time.Time
+}
+
+// refund returns the refund of an order with the given ID, or nil
+func (o *Order) refund(refundID string) *Refund {
+	for i := range o.Refunds {
+		if o.Refunds[i].ID == refundID {
+			return &o.Refunds[i]
+		}
+	}
+	return nil
+}
+
+// request returns the request the refund was recorded for
+func (r *Refund) request(orderID string) RefundRequest {
+	return RefundRequest{RefundID: r.ID, OrderID: orderID, Amount: r.RequestedAmount, Items: r.Items, Reason: r.Reason}
+}
+
+// recordedRefund returns the refund recorded on the order under the request's refund ID, or
+// nil. A refund recorded for a different request returns ErrRefundExists, so a retry is told
+// apart from a refund ID reused for another refund.
+func recordedRefund(order *Order, request RefundRequest) (*Refund, error) {
+	recorded := order.refund(request.RefundID)
+	if recorded == nil {
+		return nil, nil
+	}
+	if !sameRefund(recorded.request(order.ID), request) {
+		return nil, refundConflict(request.RefundID)
+	}
+	return recorded, nil
+}
+
+// sameRefund reports whether two requests ask for the same refund: the same amount, items
+// and reason, whatever the order of the items
+func sameRefund(a, b RefundRequest) bool {
+	if a.RefundID != b.RefundID || a.OrderID != b.OrderID || a.Amount != b.Amount || a.Reason != b.Reason {
+		return false
+	}
+	quantities := make(map[string]int, len(a.Items))
+	for _, item := range a.Items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	for _, item := range b.Items {
+		quantities[item.ProductID] -= item.Quantity
+	}
+	for _, quantity := range quantities {
+		if quantity != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// refundConflict returns the error of a refund ID already used for a different refund
+func refundConflict(refundID string) error {
+	return fmt.Errorf("%w: %s was requested with a different amount, items or reason", ErrRefundExists, refundID)
+}
+
+// refundable reports whether the order's payment has been captured and the order completed
+func (o *Order) refundable() bool {
+	return o.PaymentID != "" && (o.Status == StatusCompleted || o.Status == StatusPartiallyRefunded)
+}
+
+// newRefund checks a refund request against the order and returns the refund to record. The
+// amount may not exceed what was captured less the refunds requested or completed so far, and
+// the items may not exceed what was ordered less the items already returned.
+func newRefund(order *Order, request RefundRequest, at time.Time) (Refund, error) {
+	if order.refund(request.RefundID) != nil {
+		return Refund{}, fmt.Errorf("%w: %s", ErrRefundExists, request.RefundID)
+	}
+	if !order.refundable() {
+		return Refund{}, fmt.Errorf("%w: order %s is %s", ErrOrderNotRefundable, order.ID, order.Status)
+	}
+
+	remaining := order.TotalAmount - order.RefundedAmount
+	amount := request.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount == 0 || amount > remaining {
+		return Refund{}, fmt.Errorf("%w: %s of %s captured is left to refund, not %s",
+			ErrRefundExceedsCaptured, remaining, order.TotalAmount, amount)
+	}
+
+	returnable := make(map[string]int, len(order.Items))
+	for _, item := range order.Items {
+		returnable[item.ProductID] += item.Quantity
+	}
+	for _, refund := range order.Refunds {
+		if refund.Status == RefundStatusFailed {
+			continue
+		}
+		for _, item := range refund.Items {
+			returnable[item.ProductID] -= item.Quantity
+		}
+	}
+	for _, item := range request.Items {
+		if item.Quantity > returnable[item.ProductID] {
+			return Refund{}, fmt.Errorf("%w: %d of product %s can be returned, not %d",
+				ErrRefundExceedsOrder, returnable[item.ProductID], item.ProductID, item.Quantity)
+		}
+		returnable[item.ProductID] -= item.Quantity
+	}
+
+	return Refund{
+		ID:              request.RefundID,
+		PaymentID:       order.PaymentID,
+		Amount:          amount,
+		RequestedAmount: request.Amount,
+		Items:           request.Items,
+		Reason:          request.Reason,
+		Status:          RefundStatusRequested,
+		RequestedAt:     at,
+		UpdatedAt:       at,
+	}, nil
+}
+
+// refundedAmount returns the total of the order's completed refunds once refund is paid out
+func refundedAmount(order *Order, refund Refund) money.Amount {
+	refunded := refund.Amount
+	for _, r := range order.Refunds {
+		if r.ID != refund.ID && r.Status == RefundStatusCompleted {
+			refunded += r.Amount
+		}
+	}
+	return refunded
+}
+
+// refundedStatus returns the order's status once refunded has been paid back: refunded when it
+// adds up to the captured amount, partially refunded otherwise
+func refundedStatus(order *Order, refunded money.Amount) string {
+	if refunded >= order.TotalAmount {
+		return StatusRefunded
+	}
+	return StatusPartiallyRefunded
+}
+
+// RefundWorkflowState is the progress of a refund, returned by RefundWorkflow
+type RefundWorkflowState struct {
+	RefundID string `json:"refund_id"`
+	OrderID  string `json:"order_id"`
+	Status   string `json:"status"`
+	// Request is the request the workflow was started with, so a resent refund ID can be
+	// compared with it before the refund is recorded on the order
+	Request         RefundRequest `json:"request"`
+	Amount          money.Amount  `json:"amount,omitempty"`
+	PaymentRefundID string        `json:"payment_refund_id,omitempty"`
+	// Restocked is set once the refund's items are back in inventory
+	Restocked    bool   `json:"restocked,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// RefundWorkflowID returns the workflow ID of a refund of an order; refund IDs are only
+// unique within their order
+func RefundWorkflowID(orderID, refundID string) string {
+	return fmt.Sprintf("%s-refund-%s", orderID, refundID)
+}
+
+type RefundWorkflow struct{}
+
+// Execute refunds a completed order: the refund is recorded on the order, holding its amount
+// against the captured amount, then paid out, its items returned to inventory and the order
+// marked refunded, which emits OrderRefunded. A refund the payment provider declines releases
+// the amount it held.
+func (w RefundWorkflow) Execute(ctx workflow.Context, request RefundRequest) (RefundWorkflowState, error) {
+	state := RefundWorkflowState{
+		RefundID: request.RefundID,
+		OrderID:  request.OrderID,
+		Status:   RefundStatusRequested,
+		Request:  request,
+	}
+
+	err := workflow.SetQueryHandler(ctx, QueryGetState, func() (RefundWorkflowState, error) {
+		return state, nil
+	})
+	if err != nil {
+		return state, err
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    3,
+		},
+	})
+
+	// Hold the amount against the order, so concurrent refunds cannot exceed what was captured
+	var refund Refund
+	err = workflow.ExecuteActivity(ctx, activities.RequestRefund, request).Get(ctx, &refund)
+	if err != nil {
+		state.Status = RefundStatusRejected
+		state.ErrorMessage = err.Error()
+		return state, nil
+	}
+	state.Amount = refund.Amount
+
+	// Once the refund has been requested its outcome is always recorded
+	recordCtx := workflow.WithActivityOptions(ctx, recordOptions)
+
+	var paymentRefundID string
+	err = workflow.ExecuteActivity(ctx, activities.ProcessRefund, request.OrderID, refund).Get(ctx, &paymentRefundID)
+	if err != nil {
+		state.Status = RefundStatusFailed
+		state.ErrorMessage = err.Error()
+		failure := RefundFailure{OrderID: request.OrderID, RefundID: request.RefundID, Error: err.Error(), At: workflow.Now(ctx).UTC()}
+		if err := workflow.ExecuteActivity(recordCtx, activities.FailRefund, failure).Get(ctx, nil); err != nil {
+			workflow.GetLogger(ctx).Error("Failed to record refund failure", "OrderID", request.OrderID, "RefundID", request.RefundID, "Error", err)
+		}
+		return state, nil
+	}
+	state.PaymentRefundID = paymentRefundID
+
+	// The customer has been paid back, so returning the items is retried until it succeeds
+	if len(refund.Items) > 0 {
+		if err := workflow.ExecuteActivity(recordCtx, activities.RestockItems, refund.ID, refund.Items).Get(ctx, nil); err != nil {
+			return state, err
+		}
+		state.Restocked = true
+	}
+
+	completion := RefundCompletion{
+		OrderID:         request.OrderID,
+		RefundID:        request.RefundID,
+		PaymentRefundID: paymentRefundID,
+		At:              workflow.Now(ctx).UTC(),
+	}
+	if err := workflow.ExecuteActivity(recordCtx, activities.CompleteRefund, completion).Get(ctx, nil); err != nil {
+		return state, err
+	}
+	state.Status = RefundStatusCompleted
+	return state, nil
+}
@@ -0,0 +1,246 @@
+package ordering
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"app/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// completedOrder returns an order paid 2000 for two of prod-1 and one of prod-2
+func completedOrder() *Order {
+	return &Order{
+		ID:         "order-1",
+		CustomerID: "customer-1",
+		Items: []OrderItem{
+			{ProductID: "prod-1", Quantity: 2, UnitPrice: 500, TotalPrice: 1000},
+			{ProductID: "prod-2", Quantity: 1, UnitPrice: 1000, TotalPrice: 1000},
+		},
+		TotalAmount:   2000,
+		Status:        StatusCompleted,
+		PaymentID:     "payment-order-1",
+		StatusHistory: []StatusChange{{Status: StatusCompleted}},
+	}
+}
+
+func TestNewRefund(t *testing.T) {
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("defaults to the amount left to refund", func(t *testing.T) {
+		order := completedOrder()
+		order.RefundedAmount = 500
+		order.Refunds = []Refund{{ID: "refund-1", Amount: 500, Status: RefundStatusCompleted}}
+
+		refund, err := newRefund(order, RefundRequest{RefundID: "refund-2", OrderID: "order-1", Reason: "lost"}, at)
+		require.NoError(t, err)
+		assert.Equal(t, Refund{
+			ID:          "refund-2",
+			PaymentID:   "payment-order-1",
+			Amount:      1500,
+			Reason:      "lost",
+			Status:      RefundStatusRequested,
+			RequestedAt: at,
+			UpdatedAt:   at,
+		}, refund)
+	})
+
+	t.Run("rejects more than the captured amount", func(t *testing.T) {
+		order := completedOrder()
+		_, err := newRefund(order, RefundRequest{RefundID: "refund-1", OrderID: "order-1", Amount: 2001}, at)
+		assert.ErrorIs(t, err, ErrRefundExceedsCaptured)
+
+		// Refunds still being paid out count against it too
+		order.RefundedAmount = 1500
+		order.Refunds = []Refund{{ID: "refund-1", Amount: 1500, Status: RefundStatusRequested}}
+		_, err = newRefund(order, RefundRequest{RefundID: "refund-2", OrderID: "order-1", Amount: 501}, at)
+		assert.ErrorIs(t, err, ErrRefundExceedsCaptured)
+	})
+
+	t.Run("rejects more items than ordered", func(t *testing.T) {
+		order := completedOrder()
+		order.Refunds = []Refund{
+			{ID: "refund-1", Items: []RefundItem{{ProductID: "prod-1", Quantity: 1}}, Status: RefundStatusCompleted},
+			// A failed refund returned nothing
+			{ID: "refund-2", Items: []RefundItem{{ProductID: "prod-1", Quantity: 1}}, Status: RefundStatusFailed},
+		}
+
+		request := RefundRequest{RefundID: "refund-3", OrderID: "order-1", Amount: 500, Items: []RefundItem{{ProductID: "prod-1", Quantity: 1}}}
+		_, err := newRefund(order, request, at)
+		assert.NoError(t, err)
+
+		request.Items = []RefundItem{{ProductID: "prod-1", Quantity: 1}, {ProductID: "prod-1", Quantity: 1}}
+		_, err = newRefund(order, request, at)
+		assert.ErrorIs(t, err, ErrRefundExceedsOrder)
+
+		request.Items = []RefundItem{{ProductID: "prod-3", Quantity: 1}}
+		_, err = newRefund(order, request, at)
+		assert.ErrorIs(t, err, ErrRefundExceedsOrder)
+	})
+
+	t.Run("rejects orders not completed", func(t *testing.T) {
+		for _, status := range []string{StatusAwaitingPayment, StatusDeliveryFailed, StatusRefunded} {
+			order := completedOrder()
+			order.Status = status
+			_, err := newRefund(order, RefundRequest{RefundID: "refund-1", OrderID: "order-1"}, at)
+			assert.ErrorIs(t, err, ErrOrderNotRefundable, status)
+		}
+	})
+
+	t.Run("rejects a refund ID already used", func(t *testing.T) {
+		order := completedOrder()
+		order.Refunds = []Refund{{ID: "refund-1", Amount: 100, Status: RefundStatusFailed}}
+		_, err := newRefund(order, RefundRequest{RefundID: "refund-1", OrderID: "order-1"}, at)
+		assert.ErrorIs(t, err, ErrRefundExists)
+	})
+}
+
+type RefundWorkflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env       *testsuite.TestWorkflowEnvironment
+	orders    *memoryOrderRepository
+	inventory *memoryInventory
+}
+
+func (s *RefundWorkflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+	s.orders = newMemoryOrderRepository()
+	s.orders.orders["order-1"] = completedOrder()
+	s.inventory = newTestInventory()
+	s.env.RegisterActivity(NewActivities(s.orders, newTestCustomers(), s.inventory, newTestCatalogue()))
+}
+
+func (s *RefundWorkflowTestSuite) TearDownTest() {
+	s.env.AssertExpectations(s.T())
+}
+
+func TestRefundWorkflowTestSuite(t *testing.T) {
+	suite.Run(t, new(RefundWorkflowTestSuite))
+}
+
+func (s *RefundWorkflowTestSuite) refund() RefundWorkflowState {
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	var state RefundWorkflowState
+	s.NoError(s.env.GetWorkflowResult(&state))
+	return state
+}
+
+func (s *RefundWorkflowTestSuite) Test_FullRefund() {
+	request := RefundRequest{
+		RefundID: "refund-1",
+		OrderID:  "order-1",
+		Items:    []RefundItem{{ProductID: "prod-1", Quantity: 2}, {ProductID: "prod-2", Quantity: 1}},
+	}
+	s.env.ExecuteWorkflow(RefundWorkflow{}.Execute, request)
+
+	state := s.refund()
+	s.Equal(RefundStatusCompleted, state.Status)
+	s.Equal(money.Amount(2000), state.Amount)
+	s.Equal("payment-refund-refund-1", state.PaymentRefundID)
+	s.True(state.Restocked)
+
+	// The items are back in stock
+	s.Equal(102, s.inventory.available("prod-1"))
+	s.Equal(2, s.inventory.available("prod-2"))
+
+	order := s.orders.orders["order-1"]
+	s.Equal(StatusRefunded, order.Status)
+	s.Equal(money.Amount(2000), order.RefundedAmount)
+	s.Equal(StatusChange{Status: StatusRefunded, At: order.UpdatedAt, RefundID: "refund-1"}, order.StatusHistory[len(order.StatusHistory)-1])
+
+	s.Require().Len(s.orders.events, 1)
+	event := s.orders.events[0]
+	s.Equal(EventOrderRefunded, event.EventType)
+	s.Equal("order-1", event.AggregateID)
+	s.JSONEq(`{
+		"order_id": "order-1",
+		"refund_id": "refund-1",
+		"customer_id": "customer-1",
+		"payment_refund_id": "payment-refund-refund-1",
+		"amount": 2000,
+		"refunded_amount": 2000,
+		"captured_amount": 2000,
+		"items": [{"product_id": "prod-1", "quantity": 2}, {"product_id": "prod-2", "quantity": 1}],
+		"status": "refunded",
+		"refunded_at": "`+order.UpdatedAt.Format(time.RFC3339Nano)+`"
+	}`, string(event.Payload))
+}
+
+func (s *RefundWorkflowTestSuite) Test_PartialRefund() {
+	s.env.ExecuteWorkflow(RefundWorkflow{}.Execute, RefundRequest{RefundID: "refund-1", OrderID: "order-1", Amount: 500})
+
+	state := s.refund()
+	s.Equal(RefundStatusCompleted, state.Status)
+	s.False(state.Restocked)
+	s.Equal(100, s.inventory.available("prod-1"))
+
+	order := s.orders.orders["order-1"]
+	s.Equal(StatusPartiallyRefunded, order.Status)
+	s.Equal(money.Amount(500), order.RefundedAmount)
+	s.Len(s.orders.events, 1)
+}
+
+func (s *RefundWorkflowTestSuite) Test_RefundExceedingCapturedRejected() {
+	s.orders.orders["order-1"].RefundedAmount = 1500
+	s.orders.orders["order-1"].Refunds = []Refund{{ID: "refund-0", Amount: 1500, Status: RefundStatusRequested}}
+
+	s.env.ExecuteWorkflow(RefundWorkflow{}.Execute, RefundRequest{RefundID: "refund-1", OrderID: "order-1", Amount: 1000})
+
+	state := s.refund()
+	s.Equal(RefundStatusRejected, state.Status)
+	s.Contains(state.ErrorMessage, "refund exceeds captured amount")
+	s.Len(s.orders.orders["order-1"].Refunds, 1)
+	s.Empty(s.orders.events)
+}
+
+func (s *RefundWorkflowTestSuite) Test_DeclinedRefundReleasesAmount() {
+	declined := temporal.NewNonRetryableApplicationError("refund declined", "RefundDeclined", nil)
+	s.env.OnActivity(activities.ProcessRefund, mock.Anything, "order-1", mock.Anything).Return("", declined)
+
+	s.env.ExecuteWorkflow(RefundWorkflow{}.Execute, RefundRequest{RefundID: "refund-1", OrderID: "order-1", Items: []RefundItem{{ProductID: "prod-1", Quantity: 1}}})
+
+	state := s.refund()
+	s.Equal(RefundStatusFailed, state.Status)
+	s.Contains(state.ErrorMessage, "refund declined")
+	s.False(state.Restocked)
+	s.Equal(100, s.inventory.available("prod-1"))
+
+	// The amount can be refunded again
+	order := s.orders.orders["order-1"]
+	s.Equal(StatusCompleted, order.Status)
+	s.Zero(order.RefundedAmount)
+	s.Equal(RefundStatusFailed, order.Refunds[0].Status)
+	s.Empty(s.orders.events)
+}
+
+func (s *RefundWorkflowTestSuite) Test_RequestRefundRetriedOnce() {
+	// The first attempt records the refund but its result is lost
+	s.env.OnActivity(activities.RequestRefund, mock.Anything, mock.Anything).Return(func(ctx context.Context, request RefundRequest) (Refund, error) {
+		if _, err := s.orders.RequestRefund(ctx, request, time.Now()); err != nil {
+			return Refund{}, err
+		}
+		return Refund{}, errors.New("connection reset")
+	}).Once()
+	s.env.OnActivity(activities.RequestRefund, mock.Anything, mock.Anything).Return(func(ctx context.Context, request RefundRequest) (Refund, error) {
+		return s.orders.RequestRefund(ctx, request, time.Now())
+	}).Once()
+
+	s.env.ExecuteWorkflow(RefundWorkflow{}.Execute, RefundRequest{RefundID: "refund-1", OrderID: "order-1", Amount: 500})
+
+	state := s.refund()
+	s.Equal(RefundStatusCompleted, state.Status)
+	order := s.orders.orders["order-1"]
+	s.Len(order.Refunds, 1)
+	s.Equal(money.Amount(500), order.RefundedAmount)
+}
@@ -0,0 +1,308 @@
+package ordering
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"app/internal/money"
+	"app/internal/outbox"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Order is an order as stored in OrderingDB.orders, keyed on its order ID
+type Order struct {
+	ID         string `bson:"_id" json:"order_id"`
+	CustomerID string `bson:"customer_id" json:"customer_id"`
+	// Customer is copied from the customers projection when the order is created
+	Customer    CustomerSnapshot `bson:"customer" json:"customer"`
+	Items       []OrderItem      `bson:"items" json:"items"`
+	TotalAmount money.Amount     `bson:"total_amount" json:"total_amount"`
+	Status      string           `bson:"status" json:"status"`
+	// The workflow's progress, copied from OrderWorkflowState at every transition
+	ReservationID    string         `bson:"reservation_id,omitempty" json:"reservation_id,omitempty"`
+	PaymentID        string         `bson:"payment_id,omitempty" json:"payment_id,omitempty"`
+	PaymentMethod    string         `bson:"payment_method,omitempty" json:"payment_method,omitempty"`
+	PaymentReference string         `bson:"payment_reference,omitempty" json:"payment_reference,omitempty"`
+	FulfillmentID    string         `bson:"fulfillment_id,omitempty" json:"fulfillment_id,omitempty"`
+	DeliveryID       string         `bson:"delivery_id,omitempty" json:"delivery_id,omitempty"`
+	ErrorMessage     string         `bson:"error_message,omitempty" json:"error_message,omitempty"`
+	Compensations    []Compensation `bson:"compensations,omitempty" json:"compensations,omitempty"`
+	// RefundedAmount is held against TotalAmount by the refunds requested or completed so far
+	RefundedAmount money.Amount   `bson:"refunded_amount" json:"refunded_amount"`
+	Refunds        []Refund       `bson:"refunds,omitempty" json:"refunds,omitempty"`
+	StatusHistory  []StatusChange `bson:"status_history" json:"status_history"`
+	CreatedAt      time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time      `bson:"updated_at" json:"updated_at"`
+}
+
+// StatusChange is an entry of an order's status history
+type StatusChange struct {
+	Status string    `bson:"status" json:"status"`
+	At     time.Time `bson:"at" json:"at"`
+	// RefundID is the refund that moved the order to a refunded status
+	RefundID string `bson:"refund_id,omitempty" json:"refund_id,omitempty"`
+}
+
+// StatusUpdate is a workflow state transition written back to the stored order
+type StatusUpdate struct {
+	State OrderWorkflowState
+	// At is the workflow time of the transition, so a retried write records the same time
+	At time.Time
+}
+
+// OrderRepository stores orders
+type OrderRepository interface {
+	// CreateOrder inserts an order unless one with its ID is already stored
+	CreateOrder(ctx context.Context, order *Order) error
+	// RecordStatus applies a transition to the stored order, once per status
+	RecordStatus(ctx context.Context, update StatusUpdate) error
+	// GetOrder returns a stored order, or ErrOrderNotFound
+	GetOrder(ctx context.Context, orderID string) (*Order, error)
+	// RequestRefund records a refund on the order, holding its amount against the captured
+	// amount; requesting a refund ID already recorded returns the recorded refund, or
+	// ErrRefundExists when it was recorded for a different request
+	RequestRefund(ctx context.Context, request RefundRequest, at time.Time) (Refund, error)
+	// CompleteRefund marks a requested refund as paid out, moves the order to a refunded
+	// status and emits OrderRefunded, once per refund
+	CompleteRefund(ctx context.Context, completion RefundCompletion) error
+	// FailRefund marks a requested refund as failed and releases its amount, once per refund
+	FailRefund(ctx context.Context, failure RefundFailure) error
+}
+
+// refundAttempts bounds how often RequestRefund starts over after the order changed under it
+const refundAttempts = 5
+
+// MongoOrderRepository implements OrderRepository on OrderingDB.orders. Events are written to
+// OrderingDB.ordering_outbox in the same transaction as the change to their order.
+type MongoOrderRepository struct {
+	collection *mongo.Collection
+	outbox     *mongo.Collection
+	sequences  *mongo.Collection
+}
+
+var _ OrderRepository = (*MongoOrderRepository)(nil)
+
+// NewMongoOrderRepository creates an order repository on db's orders collection
+func NewMongoOrderRepository(db *mongo.Database) *MongoOrderRepository {
+	return &MongoOrderRepository{
+		collection: db.Collection("orders"),
+		outbox:     db.Collection("ordering_outbox"),
+		sequences:  db.Collection("ordering_outbox_sequences"),
+	}
+}
+
+func (r *MongoOrderRepository) CreateOrder(ctx context.Context, order *Order) error {
+	_, err := r.collection.InsertOne(ctx, order)
+	if mongo.IsDuplicateKeyError(err) {
+		// Inserted by an earlier attempt of the activity
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create order: %v", err)
+	}
+	return nil
+}
+
+// RecordStatus copies the workflow's progress onto the order and appends the status to its
+// history. Every workflow status is entered at most once per order, so a retried write whose
+// status is already in the history matches nothing and changes nothing. An order rejected by
+// CreateOrder was never stored and is skipped likewise.
+func (r *MongoOrderRepository) RecordStatus(ctx context.Context, update StatusUpdate) error {
+	state := update.State
+	filter := bson.M{
+		"_id":                   state.OrderID,
+		"status_history.status": bson.M{"$ne": state.Status},
+	}
+	change := bson.M{
+		"$set": bson.M{
+			"status":            state.Status,
+			"reservation_id":    state.ReservationID,
+			"payment_id":        state.PaymentID,
+			"payment_method":    state.PaymentMethod,
+			"payment_reference": state.PaymentReference,
+			"fulfillment_id":    state.FulfillmentID,
+			"delivery_id":       state.DeliveryID,
+			"error_message":     state.ErrorMessage,
+			"compensations":     state.Compensations,
+			"updated_at":        update.At,
+		},
+		"$push": bson.M{
+			"status_history": StatusChange{Status: state.Status, At: update.At},
+		},
+	}
+
+	if _, err := r.collection.UpdateOne(ctx, filter, change); err != nil {
+		return fmt.Errorf("failed to record order status: %v", err)
+	}
+	return nil
+}
+
+// GetOrder returns a stored order, or ErrOrderNotFound
+func (r *MongoOrderRepository) GetOrder(ctx context.Context, orderID string) (*Order, error) {
+	var order Order
+	err := r.collection.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %v", err)
+	}
+	return &order, nil
+}
+
+// RequestRefund checks the refund against the order as stored and records it only if no other
+// refund changed the order meanwhile, starting over when one did. Every refund requested or
+// failed changes the refunded amount, so matching it guards the checks made by newRefund.
+func (r *MongoOrderRepository) RequestRefund(ctx context.Context, request RefundRequest, at time.Time) (Refund, error) {
+	for attempt := 0; attempt < refundAttempts; attempt++ {
+		order, err := r.GetOrder(ctx, request.OrderID)
+		if err != nil {
+			return Refund{}, err
+		}
+		recorded, err := recordedRefund(order, request)
+		if err != nil {
+			return Refund{}, err
+		}
+		if recorded != nil {
+			// Requested by an earlier attempt
+			return *recorded, nil
+		}
+		refund, err := newRefund(order, request, at)
+		if err != nil {
+			return Refund{}, err
+		}
+
+		var refundedAmount any = order.RefundedAmount
+		if order.RefundedAmount == 0 {
+			// Orders stored before refunds existed have no refunded amount
+			refundedAmount = bson.M{"$in": bson.A{0, nil}}
+		}
+		filter := bson.M{
+			"_id":               order.ID,
+			"status":            order.Status,
+			"refunded_amount":   refundedAmount,
+			"refunds.refund_id": bson.M{"$ne": refund.ID},
+		}
+		change := bson.M{
+			"$set":  bson.M{"refunded_amount": order.RefundedAmount + refund.Amount, "updated_at": at},
+			"$push": bson.M{"refunds": refund},
+		}
+		result, err := r.collection.UpdateOne(ctx, filter, change)
+		if err != nil {
+			return Refund{}, fmt.Errorf("failed to request refund: %v", err)
+		}
+		if result.MatchedCount == 1 {
+			return refund, nil
+		}
+	}
+	return Refund{}, fmt.Errorf("order %s kept changing while requesting refund %s", request.OrderID, request.RefundID)
+}
+
+// CompleteRefund updates the order and writes its OrderRefunded event in one transaction. The
+// refund moves the order to refunded once the completed refunds add up to the captured
+// amount, and to partially_refunded before; the status is appended to the history with the
+// refund ID, so a status recurs once per refund.
+func (r *MongoOrderRepository) CompleteRefund(ctx context.Context, completion RefundCompletion) error {
+	return r.withTransaction(ctx, func(ctx context.Context) error {
+		order, err := r.GetOrder(ctx, completion.OrderID)
+		if err != nil {
+			return err
+		}
+		refund := order.refund(completion.RefundID)
+		if refund == nil {
+			return fmt.Errorf("%w: %s", ErrRefundNotFound, completion.RefundID)
+		}
+		if refund.Status != RefundStatusRequested {
+			// Completed by an earlier attempt
+			return nil
+		}
+		refund.Status = RefundStatusCompleted
+		refund.PaymentRefundID = completion.PaymentRefundID
+		refund.UpdatedAt = completion.At
+
+		refunded := refundedAmount(order, *refund)
+		status := refundedStatus(order, refunded)
+		event, err := newOrderRefunded(order, *refund, refunded, status, completion.At)
+		if err != nil {
+			return err
+		}
+
+		filter := bson.M{
+			"_id":     order.ID,
+			"refunds": bson.M{"$elemMatch": bson.M{"refund_id": refund.ID, "status": RefundStatusRequested}},
+		}
+		change := bson.M{
+			"$set": bson.M{
+				"refunds.$.status":            refund.Status,
+				"refunds.$.payment_refund_id": refund.PaymentRefundID,
+				"refunds.$.updated_at":        refund.UpdatedAt,
+				"status":                      status,
+				"updated_at":                  completion.At,
+			},
+			"$push": bson.M{
+				"status_history": StatusChange{Status: status, At: completion.At, RefundID: refund.ID},
+			},
+		}
+		if _, err := r.collection.UpdateOne(ctx, filter, change); err != nil {
+			return fmt.Errorf("failed to complete refund: %v", err)
+		}
+
+		event.Sequence, err = outbox.NextSequence(ctx, r.sequences, order.ID)
+		if err != nil {
+			return fmt.Errorf("failed to sequence order event: %v", err)
+		}
+		if _, err := r.outbox.InsertOne(ctx, event); err != nil {
+			return fmt.Errorf("failed to save order event: %v", err)
+		}
+		return nil
+	})
+}
+
+// FailRefund releases the amount a refund held against the captured amount, so it can be
+// refunded again
+func (r *MongoOrderRepository) FailRefund(ctx context.Context, failure RefundFailure) error {
+	order, err := r.GetOrder(ctx, failure.OrderID)
+	if err != nil {
+		return err
+	}
+	refund := order.refund(failure.RefundID)
+	if refund == nil {
+		return fmt.Errorf("%w: %s", ErrRefundNotFound, failure.RefundID)
+	}
+
+	// Only a refund still requested matches, so the amount is released once
+	filter := bson.M{
+		"_id":     order.ID,
+		"refunds": bson.M{"$elemMatch": bson.M{"refund_id": refund.ID, "status": RefundStatusRequested}},
+	}
+	change := bson.M{
+		"$set": bson.M{
+			"refunds.$.status":     RefundStatusFailed,
+			"refunds.$.error":      failure.Error,
+			"refunds.$.updated_at": failure.At,
+			"updated_at":           failure.At,
+		},
+		"$inc": bson.M{"refunded_amount": -refund.Amount},
+	}
+	if _, err := r.collection.UpdateOne(ctx, filter, change); err != nil {
+		return fmt.Errorf("failed to fail refund: %v", err)
+	}
+	return nil
+}
+
+// withTransaction runs fn in a transaction, committing only if it returns nil
+func (r *MongoOrderRepository) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := r.collection.Database().Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
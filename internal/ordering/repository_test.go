@@ -0,0 +1,302 @@
+package ordering
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"app/internal/money"
+
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// memoryOrderRepository keeps orders in memory with the same rules as MongoOrderRepository
+type memoryOrderRepository struct {
+	mu     sync.Mutex
+	orders map[string]*Order
+	events []OutboxEvent
+}
+
+func newMemoryOrderRepository() *memoryOrderRepository {
+	return &memoryOrderRepository{orders: make(map[string]*Order)}
+}
+
+func (r *memoryOrderRepository) CreateOrder(ctx context.Context, order *Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.orders[order.ID]; !ok {
+		stored := *order
+		r.orders[order.ID] = &stored
+	}
+	return nil
+}
+
+func (r *memoryOrderRepository) RecordStatus(ctx context.Context, update StatusUpdate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := update.State
+	order, ok := r.orders[state.OrderID]
+	if !ok {
+		return nil
+	}
+	for _, change := range order.StatusHistory {
+		if change.Status == state.Status {
+			return nil
+		}
+	}
+	order.Status = state.Status
+	order.ReservationID = state.ReservationID
+	order.PaymentID = state.PaymentID
+	order.PaymentMethod = state.PaymentMethod
+	order.PaymentReference = state.PaymentReference
+	order.FulfillmentID = state.FulfillmentID
+	order.DeliveryID = state.DeliveryID
+	order.ErrorMessage = state.ErrorMessage
+	order.Compensations = state.Compensations
+	order.UpdatedAt = update.At
+	order.StatusHistory = append(order.StatusHistory, StatusChange{Status: state.Status, At: update.At})
+	return nil
+}
+
+func (r *memoryOrderRepository) GetOrder(ctx context.Context, orderID string) (*Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order, ok := r.orders[orderID]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	stored := *order
+	stored.Refunds = append([]Refund(nil), order.Refunds...)
+	return &stored, nil
+}
+
+func (r *memoryOrderRepository) RequestRefund(ctx context.Context, request RefundRequest, at time.Time) (Refund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order, ok := r.orders[request.OrderID]
+	if !ok {
+		return Refund{}, ErrOrderNotFound
+	}
+	recorded, err := recordedRefund(order, request)
+	if err != nil {
+		return Refund{}, err
+	}
+	if recorded != nil {
+		return *recorded, nil
+	}
+	refund, err := newRefund(order, request, at)
+	if err != nil {
+		return Refund{}, err
+	}
+	order.RefundedAmount += refund.Amount
+	order.Refunds = append(order.Refunds, refund)
+	order.UpdatedAt = at
+	return refund, nil
+}
+
+func (r *memoryOrderRepository) CompleteRefund(ctx context.Context, completion RefundCompletion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order, ok := r.orders[completion.OrderID]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	refund := order.refund(completion.RefundID)
+	if refund == nil {
+		return ErrRefundNotFound
+	}
+	if refund.Status != RefundStatusRequested {
+		return nil
+	}
+	refund.Status = RefundStatusCompleted
+	refund.PaymentRefundID = completion.PaymentRefundID
+	refund.UpdatedAt = completion.At
+
+	refunded := refundedAmount(order, *refund)
+	status := refundedStatus(order, refunded)
+	event, err := newOrderRefunded(order, *refund, refunded, status, completion.At)
+	if err != nil {
+		return err
+	}
+	order.Status = status
+	order.UpdatedAt = completion.At
+	order.StatusHistory = append(order.StatusHistory, StatusChange{Status: status, At: completion.At, RefundID: refund.ID})
+	event.Sequence = int64(len(r.events) + 1)
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *memoryOrderRepository) FailRefund(ctx context.Context, failure RefundFailure) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order, ok := r.orders[failure.OrderID]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	refund := order.refund(failure.RefundID)
+	if refund == nil {
+		return ErrRefundNotFound
+	}
+	if refund.Status != RefundStatusRequested {
+		return nil
+	}
+	refund.Status = RefundStatusFailed
+	refund.Error = failure.Error
+	refund.UpdatedAt = failure.At
+	order.RefundedAmount -= refund.Amount
+	order.UpdatedAt = failure.At
+	return nil
+}
+
+// statuses returns the status history of a stored order
+func (r *memoryOrderRepository) statuses(orderID string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order, ok := r.orders[orderID]
+	if !ok {
+		return nil
+	}
+	statuses := make([]string, len(order.StatusHistory))
+	for i, change := range order.StatusHistory {
+		statuses[i] = change.Status
+	}
+	return statuses
+}
+
+type OrderRepositoryIntegrationTestSuite struct {
+	suite.Suite
+	client     *mongo.Client
+	db         *mongo.Database
+	repository *MongoOrderRepository
+}
+
+func (s *OrderRepositoryIntegrationTestSuite) SetupSuite() {
+	if !*integration {
+		s.T().Skip("Skipping integration tests")
+	}
+
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		s.T().Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	s.client = client
+	s.db = client.Database("ordering_test")
+	s.repository = NewMongoOrderRepository(s.db)
+}
+
+func (s *OrderRepositoryIntegrationTestSuite) TearDownSuite() {
+	if s.client != nil {
+		s.db.Drop(context.Background())
+		s.client.Disconnect(context.Background())
+	}
+}
+
+func TestOrderRepositoryIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(OrderRepositoryIntegrationTestSuite))
+}
+
+func (s *OrderRepositoryIntegrationTestSuite) TestCreateAndRecordStatus() {
+	ctx := context.Background()
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	order := &Order{
+		ID:            "order-1",
+		CustomerID:    "customer-1",
+		Items:         []OrderItem{{ProductID: "prod-1", Quantity: 2, UnitPrice: 1000, TotalPrice: 2000}},
+		TotalAmount:   2000,
+		Status:        StatusPending,
+		StatusHistory: []StatusChange{{Status: StatusPending, At: created}},
+		CreatedAt:     created,
+		UpdatedAt:     created,
+	}
+	s.Require().NoError(s.repository.CreateOrder(ctx, order))
+
+	// A retried insert leaves the stored order alone
+	retry := *order
+	retry.TotalAmount = 9900
+	s.Require().NoError(s.repository.CreateOrder(ctx, &retry))
+
+	paid := StatusUpdate{
+		State: OrderWorkflowState{OrderID: "order-1", Status: StatusPaymentProcessed, PaymentID: "payment-1"},
+		At:    created.Add(time.Hour),
+	}
+	s.Require().NoError(s.repository.RecordStatus(ctx, paid))
+	// A retried write is recorded once
+	s.Require().NoError(s.repository.RecordStatus(ctx, paid))
+	// An order never stored is skipped
+	s.Require().NoError(s.repository.RecordStatus(ctx, StatusUpdate{State: OrderWorkflowState{OrderID: "order-9", Status: StatusCreationFailed}}))
+
+	stored, err := s.repository.GetOrder(ctx, "order-1")
+	s.Require().NoError(err)
+	s.Equal(money.Amount(2000), stored.TotalAmount)
+	s.Equal(order.Items, stored.Items)
+	s.Equal(StatusPaymentProcessed, stored.Status)
+	s.Equal("payment-1", stored.PaymentID)
+	s.Equal(created.Add(time.Hour), stored.UpdatedAt.UTC())
+	s.Equal([]StatusChange{{Status: StatusPending, At: created}, {Status: StatusPaymentProcessed, At: created.Add(time.Hour)}}, utcHistory(stored.StatusHistory))
+
+	_, err = s.repository.GetOrder(ctx, "order-9")
+	s.ErrorIs(err, ErrOrderNotFound)
+}
+
+func (s *OrderRepositoryIntegrationTestSuite) TestRefunds() {
+	ctx := context.Background()
+	order := completedOrder()
+	order.ID = "order-refunded"
+	s.Require().NoError(s.repository.CreateOrder(ctx, order))
+	at := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	// Two refunds hold all of the captured amount, so a third is rejected
+	first, err := s.repository.RequestRefund(ctx, RefundRequest{RefundID: "refund-1", OrderID: order.ID, Amount: 1500, Items: []RefundItem{{ProductID: "prod-1", Quantity: 1}}}, at)
+	s.Require().NoError(err)
+	retried, err := s.repository.RequestRefund(ctx, RefundRequest{RefundID: "refund-1", OrderID: order.ID, Amount: 1500, Items: []RefundItem{{ProductID: "prod-1", Quantity: 1}}}, at)
+	s.Require().NoError(err)
+	s.Equal(first.Amount, retried.Amount)
+	_, err = s.repository.RequestRefund(ctx, RefundRequest{RefundID: "refund-1", OrderID: order.ID, Amount: 1500}, at)
+	s.ErrorIs(err, ErrRefundExists)
+	_, err = s.repository.RequestRefund(ctx, RefundRequest{RefundID: "refund-2", OrderID: order.ID}, at)
+	s.Require().NoError(err)
+	_, err = s.repository.RequestRefund(ctx, RefundRequest{RefundID: "refund-3", OrderID: order.ID, Amount: 1}, at)
+	s.ErrorIs(err, ErrRefundExceedsCaptured)
+
+	// A failed refund releases its amount, once
+	failure := RefundFailure{OrderID: order.ID, RefundID: "refund-2", Error: "declined", At: at}
+	s.Require().NoError(s.repository.FailRefund(ctx, failure))
+	s.Require().NoError(s.repository.FailRefund(ctx, failure))
+	stored, err := s.repository.GetOrder(ctx, order.ID)
+	s.Require().NoError(err)
+	s.Equal(money.Amount(1500), stored.RefundedAmount)
+
+	// Completing a refund records the status and emits OrderRefunded, once
+	completion := RefundCompletion{OrderID: order.ID, RefundID: "refund-1", PaymentRefundID: "payment-refund-1", At: at.Add(time.Hour)}
+	s.Require().NoError(s.repository.CompleteRefund(ctx, completion))
+	s.Require().NoError(s.repository.CompleteRefund(ctx, completion))
+
+	stored, err = s.repository.GetOrder(ctx, order.ID)
+	s.Require().NoError(err)
+	s.Equal(StatusPartiallyRefunded, stored.Status)
+	s.Equal(RefundStatusCompleted, stored.refund("refund-1").Status)
+	s.Equal("payment-refund-1", stored.refund("refund-1").PaymentRefundID)
+	s.Equal(RefundStatusFailed, stored.refund("refund-2").Status)
+	s.Equal(StatusChange{Status: StatusPartiallyRefunded, At: at.Add(time.Hour), RefundID: "refund-1"}, utcHistory(stored.StatusHistory)[len(stored.StatusHistory)-1])
+
+	var events []OutboxEvent
+	cursor, err := s.db.Collection("ordering_outbox").Find(ctx, bson.M{"aggregate_id": order.ID})
+	s.Require().NoError(err)
+	s.Require().NoError(cursor.All(ctx, &events))
+	s.Require().Len(events, 1)
+	s.Equal(EventOrderRefunded, events[0].EventType)
+	s.Equal(int64(1), events[0].Sequence)
+	s.Equal(OutboxStatusPending, events[0].Status)
+	s.Contains(string(events[0].Payload), `"amount":1500`)
+}
+
+func utcHistory(history []StatusChange) []StatusChange {
+	for i := range history {
+		history[i].At = history[i].At.UTC()
+	}
+	return history
+}
@@ -0,0 +1,80 @@
+package ordering
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Compensations run by OrderWorkflow, as recorded in OrderWorkflowState
+const (
+	CompensationReleaseReservation = "release_reservation"
+	CompensationRefundPayment      = "refund_payment"
+)
+
+// Compensation records an undo step run after the order failed or was cancelled
+type Compensation struct {
+	Name string `bson:"name" json:"name"`
+	// Error is set when the compensation still failed after its retries and needs manual follow-up
+	Error string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// compensationOptions retry every compensation on its own, for longer than a forward step:
+// a refund that keeps failing must not stop the reservation from being released
+var compensationOptions = workflow.ActivityOptions{
+	StartToCloseTimeout: time.Minute,
+	RetryPolicy: &temporal.RetryPolicy{
+		InitialInterval:    time.Second,
+		BackoffCoefficient: 2.0,
+		MaximumInterval:    10 * time.Minute,
+		MaximumAttempts:    10,
+	},
+}
+
+type compensation struct {
+	name     string
+	activity any
+	args     []any
+}
+
+// saga collects the compensations of the steps completed so far
+type saga struct {
+	compensations []compensation
+}
+
+// add registers the activity undoing a step that has just completed
+func (s *saga) add(name string, activity any, args ...any) {
+	s.compensations = append(s.compensations, compensation{name: name, activity: activity, args: args})
+}
+
+// drop forgets a compensation once its step can no longer be undone
+func (s *saga) drop(name string) {
+	for i, c := range s.compensations {
+		if c.name == name {
+			s.compensations = append(s.compensations[:i], s.compensations[i+1:]...)
+			return
+		}
+	}
+}
+
+// compensate runs the registered compensations in reverse order, each to completion before the
+// next, and returns what ran. It runs on a disconnected context, so a cancelled order is
+// compensated too.
+func (s *saga) compensate(ctx workflow.Context) []Compensation {
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	ctx = workflow.WithActivityOptions(ctx, compensationOptions)
+
+	ran := make([]Compensation, 0, len(s.compensations))
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		c := s.compensations[i]
+		result := Compensation{Name: c.name}
+		if err := workflow.ExecuteActivity(ctx, c.activity, c.args...).Get(ctx, nil); err != nil {
+			workflow.GetLogger(ctx).Error("Compensation failed", "Compensation", c.name, "Error", err)
+			result.Error = err.Error()
+		}
+		ran = append(ran, result)
+	}
+	s.compensations = nil
+	return ran
+}
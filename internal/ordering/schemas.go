@@ -0,0 +1,21 @@
+package ordering
+
+import (
+	"embed"
+
+	"app/internal/schema"
+)
+
+// schemaFiles holds the JSON Schema of every event this context produces, one file per type and version
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+func init() {
+	schema.Default.MustRegisterFS("ordering", schemaFiles, "schemas")
+}
+
+// validateEvent checks the payload of an outbox event against the schema of its type and version
+func validateEvent(event OutboxEvent) error {
+	return schema.Validate(EventSubject(event.EventType), event.SchemaVersion, event.Payload)
+}
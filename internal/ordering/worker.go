@@ -0,0 +1,59 @@
+package ordering
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// WorkflowName is the type OrderWorkflow is registered and started under
+	WorkflowName = "OrderWorkflow"
+	// RefundWorkflowName is the type RefundWorkflow is registered and started under
+	RefundWorkflowName = "RefundWorkflow"
+	// DefaultTaskQueue is the task queue polled by ordering workers
+	DefaultTaskQueue = "ordering"
+)
+
+// WorkerConfig controls the task queue and the concurrency of an ordering worker
+type WorkerConfig struct {
+	TaskQueue string
+	// MaxConcurrentActivities and MaxConcurrentWorkflowTasks bound what the worker runs at once
+	MaxConcurrentActivities    int
+	MaxConcurrentWorkflowTasks int
+	// StopTimeout is how long Stop waits for running activities before abandoning them
+	StopTimeout time.Duration
+}
+
+// DefaultWorkerConfig returns the worker settings used when none are supplied
+func DefaultWorkerConfig() WorkerConfig {
+	return WorkerConfig{
+		TaskQueue:                  DefaultTaskQueue,
+		MaxConcurrentActivities:    100,
+		MaxConcurrentWorkflowTasks: 100,
+		StopTimeout:                15 * time.Second,
+	}
+}
+
+// NewWorker creates a worker that runs OrderWorkflow, RefundWorkflow and their activities on
+// the configured task queue; the caller starts it and stops it before closing c
+func NewWorker(c client.Client, config WorkerConfig, activities *Activities) worker.Worker {
+	w := worker.New(c, config.TaskQueue, worker.Options{
+		MaxConcurrentActivityExecutionSize:     config.MaxConcurrentActivities,
+		MaxConcurrentWorkflowTaskExecutionSize: config.MaxConcurrentWorkflowTasks,
+		WorkerStopTimeout:                      config.StopTimeout,
+	})
+	Register(w, activities)
+	return w
+}
+
+// Register registers OrderWorkflow under WorkflowName and RefundWorkflow under
+// RefundWorkflowName, rather than their method names, and every method of activities as an
+// activity
+func Register(r worker.Registry, activities *Activities) {
+	r.RegisterWorkflowWithOptions(OrderWorkflow{}.Execute, workflow.RegisterOptions{Name: WorkflowName})
+	r.RegisterWorkflowWithOptions(RefundWorkflow{}.Execute, workflow.RegisterOptions{Name: RefundWorkflowName})
+	r.RegisterActivity(activities)
+}
@@ -0,0 +1,130 @@
+package ordering
+
+import (
+	"context"
+	"flag"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+)
+
+var integration = flag.Bool("integration", false, "run integration tests")
+
+// WorkerIntegrationTestSuite runs the worker against a Temporal CLI dev server, using the
+// temporal binary on PATH when there is one and downloading it otherwise
+type WorkerIntegrationTestSuite struct {
+	suite.Suite
+	server *testsuite.DevServer
+	worker worker.Worker
+	config WorkerConfig
+}
+
+func (s *WorkerIntegrationTestSuite) SetupSuite() {
+	if !*integration {
+		s.T().Skip("Skipping integration tests")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	options := testsuite.DevServerOptions{
+		LogLevel:  "error",
+		ExtraArgs: []string{"--search-attribute", OrderStatusSearchAttribute.GetName() + "=Keyword"},
+	}
+	if path, err := exec.LookPath("temporal"); err == nil {
+		options.ExistingPath = path
+	}
+	server, err := testsuite.StartDevServer(ctx, options)
+	if err != nil {
+		s.T().Fatalf("Failed to start Temporal dev server: %v", err)
+	}
+	s.server = server
+
+	s.config = DefaultWorkerConfig()
+	s.config.TaskQueue = "ordering-test"
+	s.config.MaxConcurrentActivities = 2
+	s.config.StopTimeout = 5 * time.Second
+	s.worker = NewWorker(server.Client(), s.config, NewActivities(newMemoryOrderRepository(), newTestCustomers(), newTestInventory(), newTestCatalogue()))
+	s.Require().NoError(s.worker.Start())
+}
+
+func (s *WorkerIntegrationTestSuite) TearDownSuite() {
+	if s.worker != nil {
+		s.worker.Stop()
+	}
+	if s.server != nil {
+		s.server.Stop()
+	}
+}
+
+func TestWorkerIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(WorkerIntegrationTestSuite))
+}
+
+func (s *WorkerIntegrationTestSuite) TestRunsOrderWorkflow() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	input := OrderWorkflowInput{
+		OrderID:    "order-1",
+		CustomerID: "customer-1",
+		Items: []OrderItem{
+			{ProductID: "prod-1", Quantity: 2, UnitPrice: 1000, TotalPrice: 2000},
+		},
+		TotalAmount: 2000,
+	}
+
+	// Started by name, as a client without the workflow code would
+	run, err := s.server.Client().ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        "order-1",
+		TaskQueue: s.config.TaskQueue,
+	}, WorkflowName, input)
+	s.Require().NoError(err)
+	s.Require().NoError(s.server.Client().SignalWorkflow(ctx, input.OrderID, "", SignalPaymentInitiated,
+		PaymentInitiated{Method: "card", Reference: "psp-" + input.OrderID}))
+
+	var result OrderWorkflowState
+	s.Require().NoError(run.Get(ctx, &result))
+	s.Equal("completed", result.Status)
+	s.Equal("payment-order-1", result.PaymentID)
+	s.Equal("delivery-order-1", result.DeliveryID)
+
+	// The finished order still answers the state query
+	value, err := s.server.Client().QueryWorkflow(ctx, "order-1", "", QueryGetState)
+	s.Require().NoError(err)
+	var state OrderWorkflowState
+	s.Require().NoError(value.Get(&state))
+	s.Equal(result, state)
+}
+
+func (s *WorkerIntegrationTestSuite) TestRecordsFailedPayment() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	input := OrderWorkflowInput{
+		OrderID:    "order-2",
+		CustomerID: "customer-1",
+		Items: []OrderItem{
+			{ProductID: "prod-1", Quantity: 1, UnitPrice: 1000, TotalPrice: 1000},
+		},
+		TotalAmount: 1000,
+	}
+
+	run, err := s.server.Client().ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        "order-2",
+		TaskQueue: s.config.TaskQueue,
+	}, WorkflowName, input)
+	s.Require().NoError(err)
+	s.Require().NoError(s.server.Client().SignalWorkflow(ctx, input.OrderID, "", SignalPaymentInitiated,
+		PaymentInitiated{Method: "card", Reference: "psp-" + input.OrderID}))
+
+	// The payment activity exhausts its retries before the workflow records the failure
+	var result OrderWorkflowState
+	s.Require().NoError(run.Get(ctx, &result))
+	s.Equal("payment_failed", result.Status)
+	s.Contains(result.ErrorMessage, "insufficient funds")
+}
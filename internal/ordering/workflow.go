@@ -1,33 +1,196 @@
 package ordering
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
+	"app/internal/money"
+
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
+// Order statuses recorded in OrderWorkflowState
+const (
+	StatusPending              = "pending"
+	StatusCreationFailed       = "creation_failed"
+	StatusReservationFailed    = "reservation_failed"
+	StatusAwaitingPayment      = "awaiting_payment"
+	StatusCancelledUnpaid      = "cancelled_unpaid"
+	StatusPaymentFailed        = "payment_failed"
+	StatusPaymentProcessed     = "payment_processed"
+	StatusReservationExpired   = "reservation_expired"
+	StatusFulfillmentFailed    = "fulfillment_failed"
+	StatusFulfillmentProcessed = "fulfillment_processed"
+	StatusDeliveryFailed       = "delivery_failed"
+	StatusCompleted            = "completed"
+	StatusCancelled            = "cancelled"
+)
+
+const (
+	// SignalPaymentInitiated carries a PaymentInitiated once the customer has started paying
+	SignalPaymentInitiated = "paymentInitiated"
+	// PaymentTimeout is how long an order waits for payment before it is cancelled
+	PaymentTimeout = 7 * 24 * time.Hour
+)
+
+// PaymentInitiated is the payload of the paymentInitiated signal
+type PaymentInitiated struct {
+	Method    string `json:"method"`
+	Reference string `json:"reference"`
+}
+
+// Validate rejects a payment the workflow cannot process
+func (p PaymentInitiated) Validate() error {
+	if p.Method == "" {
+		return errors.New("invalid payment: missing method")
+	}
+	if p.Reference == "" {
+		return errors.New("invalid payment: missing reference")
+	}
+	return nil
+}
+
+// QueryGetState is the query returning an order's current OrderWorkflowState
+const QueryGetState = "getState"
+
+// OrderStatusSearchAttribute holds the order's current status, so orders can be listed by
+// status; the namespace must have an OrderStatus Keyword search attribute
+var OrderStatusSearchAttribute = temporal.NewSearchAttributeKeyKeyword("OrderStatus")
+
+// MaxItemQuantity is the largest quantity of a product a single order item may carry
+const MaxItemQuantity = 1000
+
+// OrderItem is a line of an order. Its prices are recomputed from the product catalogue when
+// the order is created; prices left at zero are filled in, others must match.
 type OrderItem struct {
-	ProductID  string
-	Quantity   int
-	UnitPrice  float64
-	TotalPrice float64
+	ProductID  string       `bson:"product_id" json:"product_id"`
+	Quantity   int          `bson:"quantity" json:"quantity"`
+	UnitPrice  money.Amount `bson:"unit_price" json:"unit_price"`
+	TotalPrice money.Amount `bson:"total_price" json:"total_price"`
 }
 
 type OrderWorkflowInput struct {
-	OrderID     string
-	CustomerID  string
-	Items       []OrderItem
-	TotalAmount float64
+	OrderID    string      `json:"order_id"`
+	CustomerID string      `json:"customer_id"`
+	Items      []OrderItem `json:"items"`
+	// TotalAmount is checked against the sum of the priced items, unless left at zero
+	TotalAmount money.Amount `json:"total_amount"`
+}
+
+// Validate rejects an order that cannot be created
+func (in OrderWorkflowInput) Validate() error {
+	if in.OrderID == "" {
+		return errors.New("invalid order: missing order ID")
+	}
+	if in.CustomerID == "" {
+		return errors.New("invalid order: missing customer ID")
+	}
+	if len(in.Items) == 0 {
+		return errors.New("invalid order: no items")
+	}
+	for _, item := range in.Items {
+		if item.ProductID == "" {
+			return errors.New("invalid order: item without product ID")
+		}
+		if item.Quantity <= 0 || item.Quantity > MaxItemQuantity {
+			return fmt.Errorf("invalid order: quantity %d of product %s is not between 1 and %d",
+				item.Quantity, item.ProductID, MaxItemQuantity)
+		}
+		if item.UnitPrice < 0 || item.TotalPrice < 0 {
+			return fmt.Errorf("invalid order: negative price of product %s", item.ProductID)
+		}
+	}
+	if in.TotalAmount < 0 {
+		return errors.New("invalid order: negative total amount")
+	}
+	return nil
 }
 
 type OrderWorkflowState struct {
-	Status        string
-	OrderID       string
-	PaymentID     string
-	FulfillmentID string
-	DeliveryID    string
-	ErrorMessage  string
+	Status        string `json:"status"`
+	OrderID       string `json:"order_id"`
+	ReservationID string `json:"reservation_id,omitempty"`
+	PaymentID     string `json:"payment_id,omitempty"`
+	// PaymentMethod and PaymentReference come from the paymentInitiated signal
+	PaymentMethod    string `json:"payment_method,omitempty"`
+	PaymentReference string `json:"payment_reference,omitempty"`
+	FulfillmentID    string `json:"fulfillment_id,omitempty"`
+	DeliveryID       string `json:"delivery_id,omitempty"`
+	ErrorMessage     string `json:"error_message,omitempty"`
+	// Compensations lists the undo steps run, in order, after the order failed or was cancelled
+	Compensations []Compensation `json:"compensations,omitempty"`
+}
+
+// activities is only used to name activities; workflow code never calls their methods
+var activities *Activities
+
+// recordOptions retry writing a transition back to the stored order until the database is back
+var recordOptions = workflow.ActivityOptions{
+	StartToCloseTimeout: 30 * time.Second,
+	RetryPolicy: &temporal.RetryPolicy{
+		InitialInterval:    time.Second,
+		BackoffCoefficient: 2.0,
+		MaximumInterval:    time.Minute,
+	},
+}
+
+// setStatus moves the order to status, publishes it as the OrderStatus search attribute and
+// writes it back to the stored order
+func setStatus(ctx workflow.Context, state *OrderWorkflowState, status string) {
+	state.Status = status
+	upsertStatusAttribute(ctx, state)
+
+	// A cancelled order still records its final status
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	ctx = workflow.WithActivityOptions(ctx, recordOptions)
+	update := StatusUpdate{State: *state, At: workflow.Now(ctx).UTC()}
+	if err := workflow.ExecuteActivity(ctx, activities.RecordOrderStatus, update).Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to record order status", "OrderID", state.OrderID, "Status", status, "Error", err)
+	}
+}
+
+func upsertStatusAttribute(ctx workflow.Context, state *OrderWorkflowState) {
+	if err := workflow.UpsertTypedSearchAttributes(ctx, OrderStatusSearchAttribute.ValueSet(state.Status)); err != nil {
+		workflow.GetLogger(ctx).Warn("Failed to upsert order status", "OrderID", state.OrderID, "Error", err)
+	}
+}
+
+// fail records the step that stopped the order, reporting an order cancelled mid-step as
+// cancelled, and undoes the steps completed before it
+func fail(ctx workflow.Context, state *OrderWorkflowState, compensations *saga, status string, err error) {
+	if temporal.IsCanceledError(err) {
+		status = StatusCancelled
+	}
+	state.ErrorMessage = err.Error()
+	state.Compensations = compensations.compensate(ctx)
+	setStatus(ctx, state, status)
+}
+
+// awaitPayment blocks until the paymentInitiated signal arrives, failing once PaymentTimeout
+// passes without it or when the order is cancelled
+func awaitPayment(ctx workflow.Context) (PaymentInitiated, error) {
+	var payment PaymentInitiated
+	var timeoutErr error
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+	timer := workflow.NewTimer(timerCtx, PaymentTimeout)
+
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SignalPaymentInitiated), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, &payment)
+	})
+	selector.AddFuture(timer, func(f workflow.Future) {
+		// Cancelling the order cancels the timer too, reported as a CanceledError
+		if timeoutErr = f.Get(ctx, nil); timeoutErr == nil {
+			timeoutErr = fmt.Errorf("payment not initiated within %s", PaymentTimeout)
+		}
+	})
+	selector.Select(ctx)
+
+	return payment, timeoutErr
 }
 
 type OrderWorkflow struct{}
@@ -35,9 +198,19 @@ type OrderWorkflow struct{}
 func (w OrderWorkflow) Execute(ctx workflow.Context, input OrderWorkflowInput) (OrderWorkflowState, error) {
 	state := OrderWorkflowState{
 		OrderID: input.OrderID,
-		Status:  "pending",
 	}
 
+	// Answers with the state as of the last completed step, including after the workflow has finished
+	err := workflow.SetQueryHandler(ctx, QueryGetState, func() (OrderWorkflowState, error) {
+		return state, nil
+	})
+	if err != nil {
+		return state, err
+	}
+	// CreateOrder stores the order as pending
+	state.Status = StatusPending
+	upsertStatusAttribute(ctx, &state)
+
 	activityOptions := workflow.ActivityOptions{
 		StartToCloseTimeout: time.Minute,
 		RetryPolicy: &temporal.RetryPolicy{
@@ -49,51 +222,80 @@ func (w OrderWorkflow) Execute(ctx workflow.Context, input OrderWorkflowInput) (
 	}
 	ctx = workflow.WithActivityOptions(ctx, activityOptions)
 
+	// Compensations for the steps completed so far, run if a later step fails
+	var compensations saga
+
 	// Create Order
 	var orderID string
-	err := workflow.ExecuteActivity(ctx, CreateOrder, input).Get(ctx, &orderID)
+	err = workflow.ExecuteActivity(ctx, activities.CreateOrder, input).Get(ctx, &orderID)
 	if err != nil {
-		state.Status = "creation_failed"
-		state.ErrorMessage = err.Error()
+		fail(ctx, &state, &compensations, StatusCreationFailed, err)
 		return state, nil
 	}
 
+	// Reserve Inventory
+	var reservationID string
+	err = workflow.ExecuteActivity(ctx, activities.ReserveInventory, input).Get(ctx, &reservationID)
+	if err != nil {
+		fail(ctx, &state, &compensations, StatusReservationFailed, err)
+		return state, nil
+	}
+	state.ReservationID = reservationID
+	compensations.add(CompensationReleaseReservation, activities.ReleaseReservation, reservationID)
+
 	// Block until payment process is initiated; or auto-cancel after 1 week
+	setStatus(ctx, &state, StatusAwaitingPayment)
+	payment, err := awaitPayment(ctx)
+	if err != nil {
+		fail(ctx, &state, &compensations, StatusCancelledUnpaid, err)
+		return state, nil
+	}
+	state.PaymentMethod = payment.Method
+	state.PaymentReference = payment.Reference
+
 	// Process Payment
 	var paymentID string
-	err = workflow.ExecuteActivity(ctx, ProcessPayment, orderID).Get(ctx, &paymentID)
-	// When payment is processed
+	err = workflow.ExecuteActivity(ctx, activities.ProcessPayment, orderID, payment).Get(ctx, &paymentID)
+	// A failed payment releases the reserved stock
 	if err != nil {
-		state.Status = "payment_failed"
-		state.ErrorMessage = err.Error()
+		fail(ctx, &state, &compensations, StatusPaymentFailed, err)
 		return state, nil
 	}
 	state.PaymentID = paymentID
-	state.Status = "payment_processed"
+	compensations.add(CompensationRefundPayment, activities.RefundPayment, orderID, paymentID)
+	setStatus(ctx, &state, StatusPaymentProcessed)
+
+	// Keep the reserved stock of the paid order; if the reservation expired meanwhile the stock
+	// may have been sold again, so the customer is refunded
+	err = workflow.ExecuteActivity(ctx, activities.CommitReservation, reservationID).Get(ctx, nil)
+	if err != nil {
+		fail(ctx, &state, &compensations, StatusReservationExpired, err)
+		return state, nil
+	}
 
 	// Process Fulfillment
 	var fulfillmentID string
-	err = workflow.ExecuteActivity(ctx, ProcessFulfillment, orderID).Get(ctx, &fulfillmentID)
-	// If fulfilment fails; need to issue correcting statement to customer
+	err = workflow.ExecuteActivity(ctx, activities.ProcessFulfillment, orderID).Get(ctx, &fulfillmentID)
+	// A failed fulfillment refunds the customer, then releases the reserved stock
 	if err != nil {
-		state.Status = "fulfillment_failed"
-		state.ErrorMessage = err.Error()
+		fail(ctx, &state, &compensations, StatusFulfillmentFailed, err)
 		return state, nil
 	}
 	state.FulfillmentID = fulfillmentID
-	state.Status = "fulfillment_processed"
+	// Fulfilled items have left stock; a failed delivery is refunded but not restocked
+	compensations.drop(CompensationReleaseReservation)
+	setStatus(ctx, &state, StatusFulfillmentProcessed)
 
 	// Process Delivery
 	var deliveryID string
-	err = workflow.ExecuteActivity(ctx, ProcessDelivery, orderID).Get(ctx, &deliveryID)
-	// Delivery failure will lead to operations dealing/fraud/dispute; which will kick off other failure
+	err = workflow.ExecuteActivity(ctx, activities.ProcessDelivery, orderID).Get(ctx, &deliveryID)
+	// Delivery failure will lead to operations dealing/fraud/dispute; the customer is refunded meanwhile
 	if err != nil {
-		state.Status = "delivery_failed"
-		state.ErrorMessage = err.Error()
+		fail(ctx, &state, &compensations, StatusDeliveryFailed, err)
 		return state, nil
 	}
 	state.DeliveryID = deliveryID
-	state.Status = "completed"
+	setStatus(ctx, &state, StatusCompleted)
 
 	return state, nil
 }
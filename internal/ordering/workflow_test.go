@@ -1,12 +1,17 @@
 package ordering
 
 import (
+	"context"
 	"errors"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/stretchr/testify/mock"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
 )
 
@@ -14,21 +19,24 @@ type OrderWorkflowTestSuite struct {
 	suite.Suite
 	testsuite.WorkflowTestSuite
 
-	env *testsuite.TestWorkflowEnvironment
+	env       *testsuite.TestWorkflowEnvironment
+	orders    *memoryOrderRepository
+	inventory *memoryInventory
 }
 
 func (s *OrderWorkflowTestSuite) SetupTest() {
 	s.env = s.NewTestWorkflowEnvironment()
-	s.env.RegisterActivity(CreateOrder)
-	s.env.RegisterActivity(ProcessPayment)
-	s.env.RegisterActivity(ProcessFulfillment)
-	s.env.RegisterActivity(ProcessDelivery)
+	s.orders = newMemoryOrderRepository()
+	s.inventory = newTestInventory()
+	s.env.RegisterActivity(NewActivities(s.orders, newTestCustomers(), s.inventory, newTestCatalogue()))
 }
 
 func (s *OrderWorkflowTestSuite) TearDownTest() {
 	s.env.AssertExpectations(s.T())
 }
 
+var testPayment = PaymentInitiated{Method: "card", Reference: "psp-123"}
+
 func TestOrderWorkflowTestSuite(t *testing.T) {
 	suite.Run(t, new(OrderWorkflowTestSuite))
 }
@@ -42,20 +50,21 @@ func (s *OrderWorkflowTestSuite) Test_SuccessfulOrderWorkflow() {
 			{
 				ProductID:  "prod-1",
 				Quantity:   2,
-				UnitPrice:  10.00,
-				TotalPrice: 20.00,
+				UnitPrice:  1000,
+				TotalPrice: 2000,
 			},
 		},
-		TotalAmount: 20.00,
+		TotalAmount: 2000,
 	}
 
 	// Mock activities
-	s.env.OnActivity(CreateOrder, mock.Anything, input).Return("order-1", nil)
-	s.env.OnActivity(ProcessPayment, mock.Anything, "order-1").Return("payment-1", nil)
-	s.env.OnActivity(ProcessFulfillment, mock.Anything, "order-1").Return("fulfillment-1", nil)
-	s.env.OnActivity(ProcessDelivery, mock.Anything, "order-1").Return("delivery-1", nil)
+	s.env.OnActivity(activities.CreateOrder, mock.Anything, input).Return("order-1", nil)
+	s.env.OnActivity(activities.ProcessPayment, mock.Anything, "order-1", testPayment).Return("payment-1", nil)
+	s.env.OnActivity(activities.ProcessFulfillment, mock.Anything, "order-1").Return("fulfillment-1", nil)
+	s.env.OnActivity(activities.ProcessDelivery, mock.Anything, "order-1").Return("delivery-1", nil)
 
 	// Execute workflow
+	s.initiatePayment(time.Minute)
 	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, input)
 
 	s.True(s.env.IsWorkflowCompleted())
@@ -66,6 +75,8 @@ func (s *OrderWorkflowTestSuite) Test_SuccessfulOrderWorkflow() {
 
 	// Assert final state
 	s.Equal("completed", result.Status)
+	s.Equal("card", result.PaymentMethod)
+	s.Equal("psp-123", result.PaymentReference)
 	s.Equal("payment-1", result.PaymentID)
 	s.Equal("fulfillment-1", result.FulfillmentID)
 	s.Equal("delivery-1", result.DeliveryID)
@@ -98,20 +109,21 @@ func (s *OrderWorkflowTestSuite) Test_FailedPaymentWorkflow() {
 			{
 				ProductID:  "prod-1",
 				Quantity:   1,
-				UnitPrice:  10.00,
-				TotalPrice: 10.00,
+				UnitPrice:  1000,
+				TotalPrice: 1000,
 			},
 		},
-		TotalAmount: 10.00,
+		TotalAmount: 1000,
 	}
 
 	paymentError := "insufficient funds"
 
 	// Mock activities
-	s.env.OnActivity(CreateOrder, mock.Anything, input).Return("order-2", nil)
-	s.env.OnActivity(ProcessPayment, mock.Anything, "order-2").Return("", errors.New(paymentError))
+	s.env.OnActivity(activities.CreateOrder, mock.Anything, input).Return("order-2", nil)
+	s.env.OnActivity(activities.ProcessPayment, mock.Anything, "order-2", testPayment).Return("", errors.New(paymentError))
 
 	// Execute workflow
+	s.initiatePayment(time.Minute)
 	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, input)
 
 	s.True(s.env.IsWorkflowCompleted())
@@ -125,6 +137,7 @@ func (s *OrderWorkflowTestSuite) Test_FailedPaymentWorkflow() {
 	// Assert final state
 	s.Equal("payment_failed", result.Status)
 	s.Contains(result.ErrorMessage, paymentError)
+	s.Equal([]Compensation{{Name: CompensationReleaseReservation}}, result.Compensations)
 
 	// Below catches things are not implemented yet ..
 	//// Execute workflow without mocks to test unimplemented activities
@@ -150,17 +163,17 @@ func (s *OrderWorkflowTestSuite) Test_InvalidOrderWorkflow() {
 			{
 				ProductID:  "prod-1",
 				Quantity:   1,
-				UnitPrice:  10.00,
-				TotalPrice: 10.00,
+				UnitPrice:  1000,
+				TotalPrice: 1000,
 			},
 		},
-		TotalAmount: 10.00,
+		TotalAmount: 1000,
 	}
 
 	invalidOrderError := "invalid order: missing order ID"
 
 	// Mock activities
-	s.env.OnActivity(CreateOrder, mock.Anything, input).Return("", errors.New(invalidOrderError))
+	s.env.OnActivity(activities.CreateOrder, mock.Anything, input).Return("", errors.New(invalidOrderError))
 
 	// Execute workflow
 	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, input)
@@ -193,3 +206,267 @@ func (s *OrderWorkflowTestSuite) Test_InvalidOrderWorkflow() {
 	//s.Empty(result.PaymentID)
 
 }
+
+func (s *OrderWorkflowTestSuite) Test_DeletedCustomerRejected() {
+	attempts := 0
+	s.env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		if info.ActivityType.Name == "CreateOrder" {
+			attempts++
+		}
+	})
+	input := s.orderInput("order-11")
+	input.CustomerID = "deleted-customer"
+
+	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	var result OrderWorkflowState
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(StatusCreationFailed, result.Status)
+	s.Contains(result.ErrorMessage, "customer deleted-customer is deleted")
+	// The rejection is not retried, and nothing was reserved
+	s.Equal(1, attempts)
+	s.Empty(result.ReservationID)
+	s.Empty(result.Compensations)
+}
+
+func (s *OrderWorkflowTestSuite) Test_ReservedStockCommitted() {
+	input := s.orderInput("order-12")
+	input.Items[0].Quantity = 3
+	input.Items[0].TotalPrice = 3000
+	input.TotalAmount = 3000
+
+	result := s.executeOrder(input)
+
+	s.Equal(StatusCompleted, result.Status)
+	s.Equal("reservation-order-12", result.ReservationID)
+	s.Equal(97, s.inventory.available("prod-1"))
+	// Committed, the reservation no longer expires
+	s.inventory.expire(s.env.Now().Add(ReservationTTL))
+	s.Equal(97, s.inventory.available("prod-1"))
+}
+
+func (s *OrderWorkflowTestSuite) Test_ShortStockFailsOrder() {
+	input := s.orderInput("order-13")
+	input.Items = append(input.Items, OrderItem{ProductID: "prod-2", Quantity: 2, UnitPrice: 500, TotalPrice: 1000})
+	input.TotalAmount = 2000
+
+	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	var result OrderWorkflowState
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(StatusReservationFailed, result.Status)
+	s.Contains(result.ErrorMessage, "insufficient stock")
+	// Nothing was reserved, so nothing is released
+	s.Empty(result.Compensations)
+	s.Equal(100, s.inventory.available("prod-1"))
+	s.Equal(1, s.inventory.available("prod-2"))
+}
+
+func (s *OrderWorkflowTestSuite) Test_ExpiredReservationRefunded() {
+	// The reservation expires while the order waits for payment
+	s.env.RegisterDelayedCallback(func() {
+		s.inventory.expire(s.env.Now().Add(ReservationTTL))
+		s.Equal(100, s.inventory.available("prod-1"))
+	}, 30*time.Second)
+	s.env.OnActivity(activities.RefundPayment, mock.Anything, "order-14", "payment-order-14").Return("refund-1", nil).Once()
+
+	result := s.executeOrder(s.orderInput("order-14"))
+
+	s.Equal(StatusReservationExpired, result.Status)
+	s.Contains(result.ErrorMessage, "reservation expired")
+	s.Equal([]Compensation{{Name: CompensationRefundPayment}, {Name: CompensationReleaseReservation}}, result.Compensations)
+	// The expired stock is not returned twice
+	s.Equal(100, s.inventory.available("prod-1"))
+}
+
+func (s *OrderWorkflowTestSuite) Test_QueryAndStatusSearchAttribute() {
+	input := OrderWorkflowInput{
+		OrderID:    "order-4",
+		CustomerID: "customer-4",
+		Items: []OrderItem{
+			{
+				ProductID:  "prod-1",
+				Quantity:   1,
+				UnitPrice:  1000,
+				TotalPrice: 1000,
+			},
+		},
+		TotalAmount: 1000,
+	}
+
+	// Fulfillment takes an hour, leaving the order in payment_processed meanwhile
+	s.env.OnActivity(activities.CreateOrder, mock.Anything, input).Return("order-4", nil)
+	s.env.OnActivity(activities.ProcessPayment, mock.Anything, "order-4", testPayment).Return("payment-4", nil)
+	s.env.OnActivity(activities.ProcessFulfillment, mock.Anything, "order-4").After(time.Hour).Return("fulfillment-4", nil)
+	s.env.OnActivity(activities.ProcessDelivery, mock.Anything, "order-4").Return("delivery-4", nil)
+
+	var statuses []string
+	s.env.OnUpsertTypedSearchAttributes(mock.MatchedBy(func(attributes temporal.SearchAttributes) bool {
+		status, _ := attributes.GetKeyword(OrderStatusSearchAttribute)
+		statuses = append(statuses, status)
+		return true
+	})).Return(nil)
+
+	s.env.RegisterDelayedCallback(func() {
+		value, err := s.env.QueryWorkflow(QueryGetState)
+		s.NoError(err)
+
+		var state OrderWorkflowState
+		s.NoError(value.Get(&state))
+		s.Equal(StatusPaymentProcessed, state.Status)
+		s.Equal("payment-4", state.PaymentID)
+		s.Empty(state.FulfillmentID)
+	}, 2*time.Minute)
+
+	s.initiatePayment(time.Minute)
+	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]string{StatusPending, StatusAwaitingPayment, StatusPaymentProcessed, StatusFulfillmentProcessed, StatusCompleted}, statuses)
+}
+
+func (s *OrderWorkflowTestSuite) orderInput(orderID string) OrderWorkflowInput {
+	return OrderWorkflowInput{
+		OrderID:    orderID,
+		CustomerID: "customer-1",
+		Items: []OrderItem{
+			{
+				ProductID:  "prod-1",
+				Quantity:   1,
+				UnitPrice:  1000,
+				TotalPrice: 1000,
+			},
+		},
+		TotalAmount: 1000,
+	}
+}
+
+// initiatePayment sends the paymentInitiated signal once the workflow has run for after
+func (s *OrderWorkflowTestSuite) initiatePayment(after time.Duration) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SignalPaymentInitiated, testPayment)
+	}, after)
+}
+
+// executeOrder runs an order paid for a minute after it was placed
+func (s *OrderWorkflowTestSuite) executeOrder(input OrderWorkflowInput) OrderWorkflowState {
+	s.initiatePayment(time.Minute)
+	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, input)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	var result OrderWorkflowState
+	s.NoError(s.env.GetWorkflowResult(&result))
+	return result
+}
+
+func (s *OrderWorkflowTestSuite) Test_FailedFulfillmentRefundsThenReleases() {
+	var calls []string
+	s.env.OnActivity(activities.ProcessFulfillment, mock.Anything, "order-5").Return("", errors.New("warehouse closed"))
+	s.env.OnActivity(activities.RefundPayment, mock.Anything, "order-5", "payment-order-5").Return("refund-1", nil).
+		Run(func(mock.Arguments) { calls = append(calls, "refund") }).Once()
+	s.env.OnActivity(activities.ReleaseReservation, mock.Anything, "reservation-order-5").Return(nil).
+		Run(func(mock.Arguments) { calls = append(calls, "release") }).Once()
+
+	result := s.executeOrder(s.orderInput("order-5"))
+
+	s.Equal(StatusFulfillmentFailed, result.Status)
+	s.Contains(result.ErrorMessage, "warehouse closed")
+	// Compensations run in reverse order of the steps they undo
+	s.Equal([]string{"refund", "release"}, calls)
+	s.Equal([]Compensation{{Name: CompensationRefundPayment}, {Name: CompensationReleaseReservation}}, result.Compensations)
+
+	// Every transition is written back to the stored order
+	s.Equal([]string{StatusPending, StatusAwaitingPayment, StatusPaymentProcessed, StatusFulfillmentFailed}, s.orders.statuses("order-5"))
+	s.Equal(result.Compensations, s.orders.orders["order-5"].Compensations)
+}
+
+func (s *OrderWorkflowTestSuite) Test_FailedDeliveryRefundsOnly() {
+	s.env.OnActivity(activities.ProcessDelivery, mock.Anything, "order-6").Return("", errors.New("address not found"))
+	s.env.OnActivity(activities.RefundPayment, mock.Anything, "order-6", "payment-order-6").Return("refund-1", nil).Once()
+
+	result := s.executeOrder(s.orderInput("order-6"))
+
+	s.Equal(StatusDeliveryFailed, result.Status)
+	s.Equal("fulfillment-order-6", result.FulfillmentID)
+	// Fulfilled items are not returned to stock
+	s.Equal([]Compensation{{Name: CompensationRefundPayment}}, result.Compensations)
+}
+
+func (s *OrderWorkflowTestSuite) Test_CompensationsRetriedIndependently() {
+	refundAttempts := 0
+	s.env.OnActivity(activities.ProcessFulfillment, mock.Anything, "order-7").Return("", errors.New("warehouse closed"))
+	s.env.OnActivity(activities.RefundPayment, mock.Anything, "order-7", "payment-order-7").Return("", errors.New("gateway down")).
+		Run(func(mock.Arguments) { refundAttempts++ })
+	s.env.OnActivity(activities.ReleaseReservation, mock.Anything, "reservation-order-7").Return(nil).Once()
+
+	result := s.executeOrder(s.orderInput("order-7"))
+
+	// The refund exhausts its own retries; the release still runs afterwards
+	s.Equal(int(compensationOptions.RetryPolicy.MaximumAttempts), refundAttempts)
+	s.Require().Len(result.Compensations, 2)
+	s.Equal(CompensationRefundPayment, result.Compensations[0].Name)
+	s.Contains(result.Compensations[0].Error, "gateway down")
+	s.Equal(Compensation{Name: CompensationReleaseReservation}, result.Compensations[1])
+}
+
+func (s *OrderWorkflowTestSuite) Test_CancelledOrderIsCompensated() {
+	// Fulfillment takes an hour; the order is cancelled after payment was taken
+	s.env.OnActivity(activities.ProcessFulfillment, mock.Anything, "order-8").After(time.Hour).Return("fulfillment-order-8", nil)
+	s.env.OnActivity(activities.RefundPayment, mock.Anything, "order-8", "payment-order-8").Return("refund-1", nil).Once()
+	s.env.OnActivity(activities.ReleaseReservation, mock.Anything, "reservation-order-8").Return(nil).Once()
+	s.env.RegisterDelayedCallback(s.env.CancelWorkflow, 30*time.Minute)
+
+	result := s.executeOrder(s.orderInput("order-8"))
+
+	s.Equal(StatusCancelled, result.Status)
+	s.Equal([]Compensation{{Name: CompensationRefundPayment}, {Name: CompensationReleaseReservation}}, result.Compensations)
+}
+
+func (s *OrderWorkflowTestSuite) Test_PaymentSignalBeforeTimeout() {
+	start := s.env.Now()
+	s.env.OnActivity(activities.ProcessPayment, mock.Anything, "order-9", testPayment).Return("payment-9", nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		value, err := s.env.QueryWorkflow(QueryGetState)
+		s.NoError(err)
+		var state OrderWorkflowState
+		s.NoError(value.Get(&state))
+		s.Equal(StatusAwaitingPayment, state.Status)
+	}, time.Hour)
+	s.initiatePayment(PaymentTimeout - time.Hour)
+
+	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, s.orderInput("order-9"))
+
+	s.True(s.env.IsWorkflowCompleted())
+	var result OrderWorkflowState
+	s.NoError(s.env.GetWorkflowResult(&result))
+	s.Equal(StatusCompleted, result.Status)
+	s.Equal("payment-9", result.PaymentID)
+	s.Less(s.env.Now().Sub(start), PaymentTimeout)
+}
+
+func (s *OrderWorkflowTestSuite) Test_UnpaidOrderCancelledAfterTimeout() {
+	start := s.env.Now()
+	s.env.OnActivity(activities.ReleaseReservation, mock.Anything, "reservation-order-10").Return(nil).Once()
+
+	s.env.ExecuteWorkflow(OrderWorkflow{}.Execute, s.orderInput("order-10"))
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	var result OrderWorkflowState
+	s.NoError(s.env.GetWorkflowResult(&result))
+
+	// The durable timer fired a week in, without payment being taken
+	s.Equal(StatusCancelledUnpaid, result.Status)
+	s.Contains(result.ErrorMessage, "payment not initiated")
+	s.Empty(result.PaymentID)
+	s.Equal([]Compensation{{Name: CompensationReleaseReservation}}, result.Compensations)
+	s.GreaterOrEqual(s.env.Now().Sub(start), PaymentTimeout)
+	s.Equal([]string{StatusPending, StatusAwaitingPayment, StatusCancelledUnpaid}, s.orders.statuses("order-10"))
+	s.env.AssertNotCalled(s.T(), "ProcessPayment", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Lease identifies the relay instance claiming outbox events and how long its claim lasts
+type Lease struct {
+	Owner    string
+	Duration time.Duration
+}
+
+// NewOwnerID returns an identifier unique to this process, for use as a lease owner
+func NewOwnerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// claimableFilter matches pending events that are unleased or whose lease has expired
+func claimableFilter(now time.Time) bson.M {
+	return bson.M{
+		"status": "pending",
+		"$or": bson.A{
+			bson.M{"lease_expires_at": bson.M{"$exists": false}},
+			bson.M{"lease_expires_at": bson.M{"$lte": now}},
+		},
+	}
+}
+
+// OwnedFilter matches the event only while it is still leased to owner, so a relay
+// whose lease expired cannot overwrite the result of the instance that reclaimed it
+func OwnedFilter(id primitive.ObjectID, owner string) bson.M {
+	return bson.M{"_id": id, "lease_owner": owner}
+}
+
+// ReleaseLease returns the $unset fields that drop an event's lease
+func ReleaseLease() bson.M {
+	return bson.M{"lease_owner": "", "lease_expires_at": ""}
+}
@@ -0,0 +1,25 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNewOwnerID(t *testing.T) {
+	assert.NotEqual(t, NewOwnerID(), NewOwnerID())
+}
+
+func TestClaimableFilter(t *testing.T) {
+	now := time.Now()
+	filter := claimableFilter(now)
+
+	// Only pending events are claimable, either unleased or with an expired lease
+	assert.Equal(t, "pending", filter["status"])
+	assert.Equal(t, bson.A{
+		bson.M{"lease_expires_at": bson.M{"$exists": false}},
+		bson.M{"lease_expires_at": bson.M{"$lte": now}},
+	}, filter["$or"])
+}
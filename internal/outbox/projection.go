@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrStaleEvent is returned when a projection already reflects the event or a later one
+var ErrStaleEvent = errors.New("stale or duplicate event")
+
+// Meta identifies an event and its position within its aggregate
+type Meta struct {
+	EventID  string
+	Sequence int64
+	// Time is when the event was written to its outbox, so replaying it stamps the same time
+	Time time.Time
+}
+
+// UpsertVersioned sets fields on the document matching key, recording the event ID and
+// using the sequence as the document version. Writes that are not newer than the stored
+// version, including redelivered events, return ErrStaleEvent. Key must address a
+// uniquely indexed field so a rejected write cannot insert a second document.
+func UpsertVersioned(ctx context.Context, collection *mongo.Collection, key bson.M, meta Meta, fields bson.M) error {
+	filter := bson.M{
+		"$and": bson.A{
+			key,
+			bson.M{"$or": bson.A{
+				bson.M{"version": bson.M{"$exists": false}},
+				bson.M{"version": bson.M{"$lt": meta.Sequence}},
+			}},
+		},
+	}
+
+	set := bson.M{}
+	for field, value := range fields {
+		set[field] = value
+	}
+	set["event_id"] = meta.EventID
+	set["version"] = meta.Sequence
+
+	_, err := collection.UpdateOne(ctx, filter, bson.M{"$set": set}, options.Update().SetUpsert(true))
+	if err != nil {
+		// The key exists with an equal or newer version, so the upsert tried to insert a duplicate
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrStaleEvent
+		}
+		return err
+	}
+	return nil
+}
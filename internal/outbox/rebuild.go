@@ -0,0 +1,250 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// rebuildProgressInterval is how many events are replayed between progress reports
+	rebuildProgressInterval = 500
+	// rebuildLockDuration is how long a rebuild's lock outlives its last renewal, so the lock of
+	// a rebuild that crashed lapses
+	rebuildLockDuration = time.Minute
+)
+
+// ErrRebuildRunning is returned while another rebuild of the same projection holds its lock
+var ErrRebuildRunning = errors.New("projection rebuild already running")
+
+// RebuildReport counts the events replayed into a projection
+type RebuildReport struct {
+	Projection string `json:"projection"`
+	Total      int64  `json:"total"`
+	Replayed   int64  `json:"replayed"`
+	Applied    int64  `json:"applied"`
+	// Skipped events were already reflected by a later version of the document
+	Skipped int64 `json:"skipped"`
+	Failed  int64 `json:"failed"`
+	Done    bool  `json:"done"`
+}
+
+// IncompleteRebuildError is returned when events failed to replay; the shadow collection is
+// dropped and the target projection left as it was
+type IncompleteRebuildError struct {
+	Report RebuildReport
+}
+
+func (e *IncompleteRebuildError) Error() string {
+	return fmt.Sprintf("rebuild of %s incomplete: %d of %d events failed to replay",
+		e.Report.Projection, e.Report.Failed, e.Report.Replayed)
+}
+
+// Rebuild regenerates a projection by replaying the full history of an outbox, processed
+// events included, in order into a shadow collection that then atomically replaces the target
+type Rebuild[T any] struct {
+	Source *mongo.Collection
+	Target *mongo.Collection
+	// Prepare optionally creates indexes on the shadow collection before replay
+	Prepare func(ctx context.Context, shadow *mongo.Collection) error
+	// Apply projects one event into the given collection; ErrStaleEvent counts as skipped
+	Apply func(ctx context.Context, projection *mongo.Collection, event T) error
+	// Progress optionally receives the running counts: once the rebuild holds its lock and has
+	// counted the events, every rebuildProgressInterval events, and when it is done
+	Progress func(report RebuildReport)
+}
+
+// Run replays the outbox and swaps the rebuilt collection into place. Events written while the
+// rebuild ran are replayed again into the target after the swap, so none are lost. A single
+// event that fails to replay returns an *IncompleteRebuildError without swapping.
+func (r *Rebuild[T]) Run(ctx context.Context) (report RebuildReport, err error) {
+	report = RebuildReport{Projection: r.Target.Name()}
+	startedAt := time.Now()
+
+	// Only one rebuild at a time fills the shadow collection and swaps it in
+	lock, err := acquireRebuildLock(ctx, r.Target)
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		if releaseErr := lock.release(context.WithoutCancel(ctx)); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}()
+
+	shadow := r.Target.Database().Collection(r.Target.Name() + "_rebuild")
+	if err := shadow.Drop(ctx); err != nil {
+		return report, err
+	}
+	swapped := false
+	defer func() {
+		if !swapped {
+			// Nothing half-built is left behind, even when ctx was cancelled
+			if dropErr := shadow.Drop(context.WithoutCancel(ctx)); dropErr != nil && err == nil {
+				err = dropErr
+			}
+		}
+	}()
+	// Create it up front so an empty outbox still swaps in an empty projection
+	if err := shadow.Database().CreateCollection(ctx, shadow.Name()); err != nil {
+		return report, err
+	}
+	if r.Prepare != nil {
+		if err := r.Prepare(ctx, shadow); err != nil {
+			return report, err
+		}
+	}
+
+	total, err := r.Source.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return report, err
+	}
+	report.Total = total
+	if r.Progress != nil {
+		r.Progress(report)
+	}
+
+	if err := r.replay(ctx, lock, shadow, bson.M{}, &report); err != nil {
+		return report, err
+	}
+	if report.Failed > 0 {
+		return report, &IncompleteRebuildError{Report: report}
+	}
+
+	if err := lock.renew(ctx, true); err != nil {
+		return report, err
+	}
+	if err := r.swap(ctx, shadow); err != nil {
+		return report, err
+	}
+	swapped = true
+
+	// Catch up on events the live projector wrote to the collection that was just replaced
+	if err := r.replay(ctx, lock, r.Target, bson.M{"created_at": bson.M{"$gte": startedAt}}, &RebuildReport{}); err != nil {
+		return report, err
+	}
+
+	report.Done = true
+	if r.Progress != nil {
+		r.Progress(report)
+	}
+	return report, nil
+}
+
+func (r *Rebuild[T]) replay(ctx context.Context, lock *rebuildLock, projection *mongo.Collection, filter bson.M, report *RebuildReport) error {
+	cursor, err := r.Source.Find(
+		ctx,
+		filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "sequence", Value: 1}}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		if err := lock.renew(ctx, false); err != nil {
+			return err
+		}
+
+		var event T
+		if err := cursor.Decode(&event); err != nil {
+			report.Failed++
+		} else {
+			switch err := r.Apply(ctx, projection, event); err {
+			case nil:
+				report.Applied++
+			case ErrStaleEvent:
+				report.Skipped++
+			default:
+				report.Failed++
+			}
+		}
+
+		report.Replayed++
+		if r.Progress != nil && report.Replayed%rebuildProgressInterval == 0 {
+			r.Progress(*report)
+		}
+	}
+	return cursor.Err()
+}
+
+// swap renames the shadow collection over the target in a single server-side operation
+func (r *Rebuild[T]) swap(ctx context.Context, shadow *mongo.Collection) error {
+	db := r.Target.Database()
+	return db.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + shadow.Name()},
+		{Key: "to", Value: db.Name() + "." + r.Target.Name()},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+}
+
+// rebuildLock is the claim of one rebuild on its target projection, held in the target
+// database's projection_rebuilds collection and renewed while the rebuild runs
+type rebuildLock struct {
+	locks      *mongo.Collection
+	projection string
+	owner      string
+	renewedAt  time.Time
+}
+
+// acquireRebuildLock claims the target projection, returning ErrRebuildRunning while another
+// rebuild holds an unexpired lock on it
+func acquireRebuildLock(ctx context.Context, target *mongo.Collection) (*rebuildLock, error) {
+	lock := &rebuildLock{
+		locks:      target.Database().Collection("projection_rebuilds"),
+		projection: target.Name(),
+		owner:      NewOwnerID(),
+	}
+
+	now := time.Now()
+	_, err := lock.locks.UpdateOne(
+		ctx,
+		bson.M{"_id": lock.projection, "expires_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"owner": lock.owner, "started_at": now, "expires_at": now.Add(rebuildLockDuration)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// The lock exists and has not expired, so the upsert tried to insert a second one
+		return nil, fmt.Errorf("%w: %s", ErrRebuildRunning, lock.projection)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock projection %s: %v", lock.projection, err)
+	}
+	lock.renewedAt = now
+	return lock, nil
+}
+
+// renew extends the lock once a third of its duration has passed, or always when forced. It
+// returns ErrRebuildRunning when the lock lapsed and another rebuild took it over.
+func (l *rebuildLock) renew(ctx context.Context, force bool) error {
+	now := time.Now()
+	if !force && now.Sub(l.renewedAt) < rebuildLockDuration/3 {
+		return nil
+	}
+
+	result, err := l.locks.UpdateOne(
+		ctx,
+		bson.M{"_id": l.projection, "owner": l.owner},
+		bson.M{"$set": bson.M{"expires_at": now.Add(rebuildLockDuration)}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to renew lock on projection %s: %v", l.projection, err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("%w: lock on %s lost", ErrRebuildRunning, l.projection)
+	}
+	l.renewedAt = now
+	return nil
+}
+
+// release drops the lock if this rebuild still holds it
+func (l *rebuildLock) release(ctx context.Context) error {
+	_, err := l.locks.DeleteOne(ctx, bson.M{"_id": l.projection, "owner": l.owner})
+	return err
+}
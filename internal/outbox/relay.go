@@ -0,0 +1,192 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OrderedRelay delivers outbox events one at a time per aggregate, in sequence order,
+// while different aggregates are relayed in parallel. Only the head of an aggregate,
+// its lowest-sequence unfinished event, is ever claimed, so event N+1 is not published
+// until event N has been acknowledged and marked finished by Process.
+type OrderedRelay[T any] struct {
+	Collection *mongo.Collection
+	Lease      Lease
+	// Unfinished lists the statuses that hold back later events of the same aggregate
+	Unfinished []string
+	// Due optionally restricts which head events may be claimed, e.g. to honour retry backoff
+	Due func(now time.Time) bson.M
+	// Concurrency bounds how many aggregates are relayed at once
+	Concurrency int
+	// Process publishes a claimed event; a non-nil error stops the aggregate until the next run
+	// and is returned by RunAggregate and RunOnce
+	Process func(ctx context.Context, event T) error
+}
+
+// RunOnce relays up to limit aggregates that have pending events, returning the first error
+// of any aggregate; the other aggregates are still relayed
+func (r *OrderedRelay[T]) RunOnce(ctx context.Context, limit int) error {
+	aggregateIDs, err := r.pendingAggregates(ctx, limit)
+	if err != nil {
+		return err
+	}
+
+	concurrency := r.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for _, aggregateID := range aggregateIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(aggregateID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := r.RunAggregate(ctx, aggregateID); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(aggregateID)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// RunAggregate relays the events of one aggregate in order until it is drained or blocked.
+// An event Process fails on keeps its lease, so the rest of the aggregate waits for it, and
+// its error is returned.
+func (r *OrderedRelay[T]) RunAggregate(ctx context.Context, aggregateID string) error {
+	for ctx.Err() == nil {
+		event, ok, err := r.claimHead(ctx, aggregateID)
+		if err != nil || !ok {
+			return err
+		}
+
+		if err := r.Process(ctx, event); err != nil {
+			return fmt.Errorf("relaying aggregate %q: %w", aggregateID, err)
+		}
+	}
+	return nil
+}
+
+// pendingAggregates returns the aggregates with pending events, those waiting longest first
+func (r *OrderedRelay[T]) pendingAggregates(ctx context.Context, limit int) ([]string, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": "pending"}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        "$aggregate_id",
+			"created_at": bson.M{"$min": "$created_at"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := r.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		AggregateID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	aggregateIDs := make([]string, 0, len(groups))
+	for _, group := range groups {
+		aggregateIDs = append(aggregateIDs, group.AggregateID)
+	}
+	return aggregateIDs, nil
+}
+
+// claimHead leases the head event of an aggregate; ok is false when the aggregate is
+// drained, its head is not pending (e.g. dead-lettered), not yet due, or leased elsewhere
+func (r *OrderedRelay[T]) claimHead(ctx context.Context, aggregateID string) (event T, ok bool, err error) {
+	var head struct {
+		ID     primitive.ObjectID `bson:"_id"`
+		Status string             `bson:"status"`
+	}
+	err = r.Collection.FindOne(
+		ctx,
+		bson.M{"aggregate_id": aggregateValue(aggregateID), "status": bson.M{"$in": r.Unfinished}},
+		options.FindOne().SetSort(bson.D{{Key: "sequence", Value: 1}}),
+	).Decode(&head)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return event, false, nil
+		}
+		return event, false, err
+	}
+	if head.Status != "pending" {
+		return event, false, nil
+	}
+
+	now := time.Now()
+	filter := bson.A{bson.M{"_id": head.ID}, claimableFilter(now)}
+	if r.Due != nil {
+		filter = append(filter, r.Due(now))
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"lease_owner":      r.Lease.Owner,
+			"lease_expires_at": now.Add(r.Lease.Duration),
+		},
+	}
+
+	err = r.Collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"$and": filter},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&event)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return event, false, nil
+		}
+		return event, false, err
+	}
+	return event, true, nil
+}
+
+// aggregateValue matches an aggregate ID, treating events written without one as a single aggregate
+func aggregateValue(aggregateID string) any {
+	if aggregateID == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return aggregateID
+}
+
+// NextSequence atomically increments and returns the sequence number of an aggregate.
+// Called with a session context it takes part in the caller's transaction.
+func NextSequence(ctx context.Context, counters *mongo.Collection, aggregateID string) (int64, error) {
+	var counter struct {
+		Sequence int64 `bson:"sequence"`
+	}
+	err := counters.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": aggregateID},
+		bson.M{"$inc": bson.M{"sequence": int64(1)}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Sequence, nil
+}
@@ -0,0 +1,15 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAggregateValue(t *testing.T) {
+	assert.Equal(t, "PROD123", aggregateValue("PROD123"))
+
+	// Events written before aggregates existed are relayed as one aggregate
+	assert.Equal(t, bson.M{"$in": bson.A{"", nil}}, aggregateValue(""))
+}
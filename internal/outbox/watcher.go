@@ -0,0 +1,136 @@
+// Package outbox holds the parts of the transactional outbox relay shared by the bounded contexts.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mode selects how a relay discovers new outbox events
+type Mode string
+
+const (
+	// ModePolling queries the outbox for pending events on an interval
+	ModePolling Mode = "polling"
+	// ModeChangeStream tails the outbox with a change stream, falling back to polling on a standalone mongod
+	ModeChangeStream Mode = "change_stream"
+)
+
+// CheckpointsCollection stores the last resume token of every watcher in the outbox's database
+const CheckpointsCollection = "relay_checkpoints"
+
+// changeStreamNotSupported is the server error code returned by a standalone mongod
+const changeStreamNotSupported = 40573
+
+// pendingPipeline matches inserted events and events moved back to pending, e.g. by a redrive
+var pendingPipeline = mongo.Pipeline{
+	{{Key: "$match", Value: bson.M{
+		"$or": bson.A{
+			bson.M{"operationType": "insert"},
+			bson.M{"operationType": "update", "updateDescription.updatedFields.status": "pending"},
+		},
+	}}},
+}
+
+// Checkpoint is the persisted position of a watcher
+type Checkpoint struct {
+	Name        string    `bson:"_id"`
+	ResumeToken bson.Raw  `bson:"resume_token"`
+	UpdatedAt   time.Time `bson:"updated_at"`
+}
+
+// HandlerFunc relays the outbox event with the given ID
+type HandlerFunc func(ctx context.Context, id primitive.ObjectID) error
+
+// Watcher tails an outbox collection with a change stream and persists the
+// resume token after every handled event, so a restarted relay continues where it stopped
+type Watcher struct {
+	name        string
+	collection  *mongo.Collection
+	checkpoints *mongo.Collection
+}
+
+// NewWatcher creates a watcher; name identifies its checkpoint and must be unique per outbox
+func NewWatcher(name string, collection *mongo.Collection) *Watcher {
+	return &Watcher{
+		name:        name,
+		collection:  collection,
+		checkpoints: collection.Database().Collection(CheckpointsCollection),
+	}
+}
+
+// Run calls handle for every event that becomes pending until ctx is cancelled or an error occurs
+func (w *Watcher) Run(ctx context.Context, handle HandlerFunc) error {
+	opts := options.ChangeStream()
+	token, err := w.resumeToken(ctx)
+	if err != nil {
+		return err
+	}
+	if token != nil {
+		opts.SetStartAfter(token)
+	}
+
+	stream, err := w.collection.Watch(ctx, pendingPipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var change struct {
+			DocumentKey struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+
+		if err := handle(ctx, change.DocumentKey.ID); err != nil {
+			return err
+		}
+
+		if err := w.saveResumeToken(ctx, stream.ResumeToken()); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return stream.Err()
+}
+
+func (w *Watcher) resumeToken(ctx context.Context) (bson.Raw, error) {
+	var checkpoint Checkpoint
+	err := w.checkpoints.FindOne(ctx, bson.M{"_id": w.name}).Decode(&checkpoint)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return checkpoint.ResumeToken, nil
+}
+
+func (w *Watcher) saveResumeToken(ctx context.Context, token bson.Raw) error {
+	_, err := w.checkpoints.UpdateOne(
+		ctx,
+		bson.M{"_id": w.name},
+		bson.M{"$set": bson.M{"resume_token": token, "updated_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// IsChangeStreamUnsupported reports whether err means the deployment cannot open change streams
+func IsChangeStreamUnsupported(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamNotSupported)
+}
@@ -0,0 +1,22 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsChangeStreamUnsupported(t *testing.T) {
+	standalone := mongo.CommandError{
+		Code:    40573,
+		Message: "The $changeStream stage is only supported on replica sets",
+	}
+
+	assert.True(t, IsChangeStreamUnsupported(standalone))
+	assert.True(t, IsChangeStreamUnsupported(fmt.Errorf("watch failed: %w", standalone)))
+	assert.False(t, IsChangeStreamUnsupported(mongo.CommandError{Code: 11600}))
+	assert.False(t, IsChangeStreamUnsupported(errors.New("connection refused")))
+}
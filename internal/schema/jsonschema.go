@@ -0,0 +1,273 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is a compiled JSON Schema. Only the keywords the event schemas need are
+// supported: type, properties, required, additionalProperties, items, enum,
+// minimum, maximum, minLength, maxLength and the date-time and email formats.
+// Compile rejects any other keyword, so a schema never accepts more than it says.
+type Schema struct {
+	RawType              json.RawMessage    `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []json.RawMessage  `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Format               string             `json:"format,omitempty"`
+
+	types []string
+}
+
+// ValidationError lists every place a document breaks its schema
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "schema validation failed: " + strings.Join(e.Problems, "; ")
+}
+
+// supportedKeywords are the keywords Validate enforces, and annotations that assert nothing
+var supportedKeywords = map[string]bool{
+	"type": true, "properties": true, "required": true, "additionalProperties": true,
+	"items": true, "enum": true, "minimum": true, "maximum": true,
+	"minLength": true, "maxLength": true, "format": true,
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"examples": true, "default": true,
+}
+
+// supportedFormats are the formats Validate checks
+var supportedFormats = map[string]bool{"date-time": true, "email": true}
+
+// Compile parses a JSON Schema document, rejecting keywords and formats it cannot enforce
+func Compile(document []byte) (*Schema, error) {
+	if err := checkKeywords(document, "$"); err != nil {
+		return nil, err
+	}
+	var s Schema
+	if err := json.Unmarshal(document, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.compile("$"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// checkKeywords walks a schema document and its subschemas, returning an error naming the
+// first keyword Validate would silently ignore
+func checkKeywords(document json.RawMessage, path string) error {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(document, &keywords); err != nil {
+		return fmt.Errorf("invalid schema at %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !supportedKeywords[name] {
+			return fmt.Errorf("unsupported schema keyword %q at %s", name, path)
+		}
+	}
+
+	if raw, ok := keywords["format"]; ok {
+		var format string
+		if err := json.Unmarshal(raw, &format); err != nil || !supportedFormats[format] {
+			return fmt.Errorf("unsupported schema format %s at %s", raw, path)
+		}
+	}
+	if raw, ok := keywords["properties"]; ok {
+		var properties map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &properties); err != nil {
+			return fmt.Errorf("invalid schema properties at %s: %w", path, err)
+		}
+		for name, property := range properties {
+			if err := checkKeywords(property, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	if raw, ok := keywords["items"]; ok {
+		return checkKeywords(raw, path+"[]")
+	}
+	return nil
+}
+
+func (s *Schema) compile(path string) error {
+	if len(s.RawType) > 0 {
+		var one string
+		if err := json.Unmarshal(s.RawType, &one); err == nil {
+			s.types = []string{one}
+		} else if err := json.Unmarshal(s.RawType, &s.types); err != nil {
+			return fmt.Errorf("invalid schema type at %s", path)
+		}
+	}
+	for _, t := range s.types {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("unsupported schema type %q at %s", t, path)
+		}
+	}
+	for name, prop := range s.Properties {
+		if err := prop.compile(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+	return nil
+}
+
+// Validate checks a JSON document against the schema
+func (s *Schema) Validate(document []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return &ValidationError{Problems: []string{"invalid JSON: " + err.Error()}}
+	}
+
+	var problems []string
+	s.validate("$", value, &problems)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (s *Schema) validate(path string, value any, problems *[]string) {
+	report := func(format string, args ...any) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.types) > 0 && !matchesType(s.types, value) {
+		report("expected %s, got %s", strings.Join(s.types, " or "), typeOf(value))
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		report("value is not one of the allowed values")
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				report("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					report("unexpected property %q", name)
+				}
+				continue
+			}
+			prop.validate(path+"."+name, v[name], problems)
+		}
+
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			report("shorter than %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			report("longer than %d characters", *s.MaxLength)
+		}
+		switch s.Format {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				report("not an RFC 3339 date-time")
+			}
+		case "email":
+			if at := strings.Index(v, "@"); at < 1 || at == len(v)-1 {
+				report("not an email address")
+			}
+		}
+
+	case json.Number:
+		n, _ := v.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			report("less than minimum %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			report("greater than maximum %v", *s.Maximum)
+		}
+	}
+}
+
+func matchesType(types []string, value any) bool {
+	actual := typeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case json.Number:
+		if n, err := v.Float64(); err == nil && n == math.Trunc(n) && !strings.ContainsAny(v.String(), ".eE") {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func inEnum(enum []json.RawMessage, value any) bool {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range enum {
+		var compact bytes.Buffer
+		if json.Compact(&compact, allowed) == nil && bytes.Equal(compact.Bytes(), encoded) {
+			return true
+		}
+	}
+	return false
+}
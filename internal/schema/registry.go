@@ -0,0 +1,167 @@
+// Package schema is the in-process registry of event payload schemas. Producers
+// validate payloads before writing them to an outbox and consumers validate them
+// on receipt, so a payload that drifts from its schema is caught at either end.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownEvent is returned for an event type or version that has no registered schema
+var ErrUnknownEvent = errors.New("unknown event schema")
+
+// Registry maps event types and schema versions to their JSON Schema, and
+// holds the upcasters that carry payloads from one version to the next
+type Registry struct {
+	mu     sync.RWMutex
+	events map[string]*entry
+}
+
+type entry struct {
+	context   string
+	versions  map[int]*Schema
+	upcasters map[int]Upcaster
+}
+
+// CatalogEntry describes a registered event type
+type CatalogEntry struct {
+	Type     string `json:"type"`
+	Context  string `json:"context"`
+	Versions []int  `json:"versions"`
+}
+
+// Default is the registry the bounded contexts register their events with
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{events: make(map[string]*entry)}
+}
+
+// Register adds the schema of one version of an event type produced by the given bounded context
+func (r *Registry) Register(context, eventType string, version int, document []byte) error {
+	if version < 1 {
+		return fmt.Errorf("event %s: schema version must be at least 1", eventType)
+	}
+	s, err := Compile(document)
+	if err != nil {
+		return fmt.Errorf("event %s v%d: %w", eventType, version, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.events[eventType]
+	if !ok {
+		e = &entry{context: context, versions: make(map[int]*Schema), upcasters: make(map[int]Upcaster)}
+		r.events[eventType] = e
+	}
+	if e.context != context {
+		return fmt.Errorf("event %s is already produced by %s", eventType, e.context)
+	}
+	if _, ok := e.versions[version]; ok {
+		return fmt.Errorf("event %s v%d is already registered", eventType, version)
+	}
+	e.versions[version] = s
+	return nil
+}
+
+// MustRegister is Register for schemas embedded at build time; it panics on error
+func (r *Registry) MustRegister(context, eventType string, version int, document []byte) {
+	if err := r.Register(context, eventType, version, document); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterFS registers every schema file in dir, named <event type>.v<version>.json
+func (r *Registry) RegisterFS(context string, fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".json")
+		i := strings.LastIndex(name, ".v")
+		if i < 1 {
+			return fmt.Errorf("schema file %s: name must be <type>.v<version>.json", file)
+		}
+		version, err := strconv.Atoi(name[i+2:])
+		if err != nil {
+			return fmt.Errorf("schema file %s: invalid version: %w", file, err)
+		}
+
+		document, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		if err := r.Register(context, name[:i], version, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MustRegisterFS is RegisterFS for schemas embedded at build time; it panics on error
+func (r *Registry) MustRegisterFS(context string, fsys fs.FS, dir string) {
+	if err := r.RegisterFS(context, fsys, dir); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the schema of an event type at the given version
+func (r *Registry) Lookup(eventType string, version int) (*Schema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.events[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEvent, eventType)
+	}
+	s, ok := e.versions[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s v%d", ErrUnknownEvent, eventType, version)
+	}
+	return s, nil
+}
+
+// Validate checks a payload against the schema of its event type and version
+func (r *Registry) Validate(eventType string, version int, payload []byte) error {
+	s, err := r.Lookup(eventType, version)
+	if err != nil {
+		return err
+	}
+	if err := s.Validate(payload); err != nil {
+		return fmt.Errorf("event %s v%d: %w", eventType, version, err)
+	}
+	return nil
+}
+
+// Catalog lists every registered event type, sorted by type, with its versions in ascending order
+func (r *Registry) Catalog() []CatalogEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	catalog := make([]CatalogEntry, 0, len(r.events))
+	for eventType, e := range r.events {
+		versions := make([]int, 0, len(e.versions))
+		for v := range e.versions {
+			versions = append(versions, v)
+		}
+		sort.Ints(versions)
+		catalog = append(catalog, CatalogEntry{Type: eventType, Context: e.context, Versions: versions})
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Type < catalog[j].Type })
+	return catalog
+}
+
+// Validate checks a payload against the Default registry
+func Validate(eventType string, version int, payload []byte) error {
+	return Default.Validate(eventType, version, payload)
+}
@@ -0,0 +1,114 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const productSchema = `{
+	"type": "object",
+	"required": ["product_id", "quantity"],
+	"additionalProperties": false,
+	"properties": {
+		"product_id": {"type": "string", "minLength": 1},
+		"quantity": {"type": "integer", "minimum": 0},
+		"status": {"type": "string", "enum": ["in_stock", "out_of_stock"]},
+		"tags": {"type": "array", "items": {"type": "string"}},
+		"updated_at": {"type": "string", "format": "date-time"}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Register("inventory", "inventory.created", 1, []byte(productSchema)))
+
+	t.Run("accepts valid payload", func(t *testing.T) {
+		err := r.Validate("inventory.created", 1, []byte(`{"product_id":"P1","quantity":3,"status":"in_stock","tags":["a"],"updated_at":"2024-01-02T03:04:05Z"}`))
+		assert.NoError(t, err)
+	})
+
+	t.Run("reports every problem", func(t *testing.T) {
+		err := r.Validate("inventory.created", 1, []byte(`{"quantity":-1.5,"status":"gone","tags":[1],"updated_at":"yesterday","extra":true}`))
+
+		var invalid *ValidationError
+		require.ErrorAs(t, err, &invalid)
+		assert.ElementsMatch(t, []string{
+			`$: missing required property "product_id"`,
+			`$: unexpected property "extra"`,
+			`$.quantity: expected integer, got number`,
+			`$.status: value is not one of the allowed values`,
+			`$.tags[0]: expected string, got integer`,
+			`$.updated_at: not an RFC 3339 date-time`,
+		}, invalid.Problems)
+	})
+
+	t.Run("rejects non-JSON payload", func(t *testing.T) {
+		assert.Error(t, r.Validate("inventory.created", 1, []byte(`not json`)))
+	})
+
+	t.Run("rejects unknown type and version", func(t *testing.T) {
+		assert.True(t, errors.Is(r.Validate("inventory.deleted", 1, []byte(`{}`)), ErrUnknownEvent))
+		assert.True(t, errors.Is(r.Validate("inventory.created", 2, []byte(`{}`)), ErrUnknownEvent))
+	})
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	require.NoError(t, r.Register("inventory", "inventory.created", 1, []byte(productSchema)))
+
+	assert.Error(t, r.Register("inventory", "inventory.created", 1, []byte(productSchema)), "duplicate version")
+	assert.Error(t, r.Register("customers", "inventory.created", 2, []byte(productSchema)), "second producer")
+	assert.Error(t, r.Register("inventory", "inventory.updated", 0, []byte(productSchema)), "version zero")
+	assert.Error(t, r.Register("inventory", "inventory.updated", 1, []byte(`{"type":"decimal"}`)), "unsupported type")
+}
+
+func TestCompileRejectsUnsupportedKeywords(t *testing.T) {
+	for schema, message := range map[string]string{
+		`{"type":"string","pattern":"^P"}`:                            `unsupported schema keyword "pattern" at $`,
+		`{"properties":{"tags":{"type":"array","minItems":1}}}`:       `unsupported schema keyword "minItems" at $.tags`,
+		`{"properties":{"tags":{"items":{"const":"a"}}}}`:             `unsupported schema keyword "const" at $.tags[]`,
+		`{"oneOf":[{"type":"string"},{"type":"integer"}]}`:            `unsupported schema keyword "oneOf" at $`,
+		`{"properties":{"customer":{"$ref":"#/$defs/customer"}}}`:     `unsupported schema keyword "$ref" at $.customer`,
+		`{"properties":{"website":{"type":"string","format":"uri"}}}`: `unsupported schema format "uri" at $.website`,
+	} {
+		_, err := Compile([]byte(schema))
+		assert.EqualError(t, err, message, schema)
+	}
+
+	// Annotations assert nothing and are accepted
+	_, err := Compile([]byte(`{"$schema":"https://json-schema.org/draft/2020-12/schema","title":"t","description":"d","type":"object"}`))
+	assert.NoError(t, err)
+}
+
+func TestCatalog(t *testing.T) {
+	r := NewRegistry()
+	r.MustRegister("inventory", "inventory.updated", 2, []byte(productSchema))
+	r.MustRegister("inventory", "inventory.updated", 1, []byte(productSchema))
+	r.MustRegister("customers", "customers.CustomerCreated", 1, []byte(`{"type":"object"}`))
+
+	assert.Equal(t, []CatalogEntry{
+		{Type: "customers.CustomerCreated", Context: "customers", Versions: []int{1}},
+		{Type: "inventory.updated", Context: "inventory", Versions: []int{1, 2}},
+	}, r.Catalog())
+}
+
+func TestRegisterFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"schemas/inventory.created.v1.json": {Data: []byte(productSchema)},
+		"schemas/inventory.created.v2.json": {Data: []byte(productSchema)},
+		"schemas/README.md":                 {Data: []byte("not a schema")},
+	}
+
+	r := NewRegistry()
+	require.NoError(t, r.RegisterFS("inventory", fsys, "schemas"))
+	assert.Equal(t, []CatalogEntry{
+		{Type: "inventory.created", Context: "inventory", Versions: []int{1, 2}},
+	}, r.Catalog())
+
+	fsys["schemas/inventory.updated.json"] = &fstest.MapFile{Data: []byte(productSchema)}
+	assert.Error(t, NewRegistry().RegisterFS("inventory", fsys, "schemas"), "missing version")
+}
@@ -0,0 +1,110 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Upcaster transforms a payload from one schema version of an event type to the next
+type Upcaster func(payload []byte) ([]byte, error)
+
+// ObjectUpcaster builds an Upcaster that edits the payload as a JSON object,
+// e.g. to rename a field or fill in a default for a new one
+func ObjectUpcaster(edit func(fields map[string]any) error) Upcaster {
+	return func(payload []byte) ([]byte, error) {
+		var fields map[string]any
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			return nil, err
+		}
+		if err := edit(fields); err != nil {
+			return nil, err
+		}
+		return json.Marshal(fields)
+	}
+}
+
+// RegisterUpcaster adds the upcaster from fromVersion to fromVersion+1 of an event type;
+// the schemas of both versions must already be registered
+func (r *Registry) RegisterUpcaster(eventType string, fromVersion int, upcaster Upcaster) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.events[eventType]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEvent, eventType)
+	}
+	for _, v := range []int{fromVersion, fromVersion + 1} {
+		if _, ok := e.versions[v]; !ok {
+			return fmt.Errorf("%w: %s v%d", ErrUnknownEvent, eventType, v)
+		}
+	}
+	if _, ok := e.upcasters[fromVersion]; ok {
+		return fmt.Errorf("event %s already has an upcaster from v%d", eventType, fromVersion)
+	}
+	e.upcasters[fromVersion] = upcaster
+	return nil
+}
+
+// MustRegisterUpcaster is RegisterUpcaster for upcasters registered at init; it panics on error
+func (r *Registry) MustRegisterUpcaster(eventType string, fromVersion int, upcaster Upcaster) {
+	if err := r.RegisterUpcaster(eventType, fromVersion, upcaster); err != nil {
+		panic(err)
+	}
+}
+
+// Latest returns the current schema version of an event type
+func (r *Registry) Latest(eventType string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.events[eventType]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownEvent, eventType)
+	}
+	latest := 0
+	for v := range e.versions {
+		latest = max(latest, v)
+	}
+	return latest, nil
+}
+
+// Upcast validates a payload against the schema it was written with, carries it through
+// the upcasters to the current version, and validates the result. Version 0 marks events
+// written before schema versions were recorded and is read as version 1.
+func (r *Registry) Upcast(eventType string, version int, payload []byte) ([]byte, int, error) {
+	if version == 0 {
+		version = 1
+	}
+	if err := r.Validate(eventType, version, payload); err != nil {
+		return nil, 0, err
+	}
+	latest, err := r.Latest(eventType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for version < latest {
+		r.mu.RLock()
+		upcaster, ok := r.events[eventType].upcasters[version]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, 0, fmt.Errorf("event %s has no upcaster from v%d", eventType, version)
+		}
+
+		payload, err = upcaster(payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("upcasting event %s from v%d: %w", eventType, version, err)
+		}
+		version++
+	}
+
+	if err := r.Validate(eventType, version, payload); err != nil {
+		return nil, 0, err
+	}
+	return payload, version, nil
+}
+
+// Upcast brings a payload to the current version of its event type using the Default registry
+func Upcast(eventType string, version int, payload []byte) ([]byte, int, error) {
+	return Default.Upcast(eventType, version, payload)
+}
@@ -0,0 +1,96 @@
+package schema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	customerV1 = `{"type":"object","required":["name"],"properties":{"name":{"type":"string"}}}`
+	customerV2 = `{"type":"object","required":["first_name","last_name"],"additionalProperties":false,"properties":{"first_name":{"type":"string"},"last_name":{"type":"string"}}}`
+	customerV3 = `{"type":"object","required":["first_name","last_name","tier"],"additionalProperties":false,"properties":{"first_name":{"type":"string"},"last_name":{"type":"string"},"tier":{"type":"string"}}}`
+)
+
+func newCustomerRegistry(t *testing.T) *Registry {
+	r := NewRegistry()
+	r.MustRegister("customers", "customers.CustomerCreated", 1, []byte(customerV1))
+	r.MustRegister("customers", "customers.CustomerCreated", 2, []byte(customerV2))
+	r.MustRegister("customers", "customers.CustomerCreated", 3, []byte(customerV3))
+
+	// v1 -> v2 splits the name, v2 -> v3 defaults the new tier
+	require.NoError(t, r.RegisterUpcaster("customers.CustomerCreated", 1, ObjectUpcaster(func(fields map[string]any) error {
+		name, _ := fields["name"].(string)
+		first, last, _ := strings.Cut(name, " ")
+		delete(fields, "name")
+		fields["first_name"], fields["last_name"] = first, last
+		return nil
+	})))
+	require.NoError(t, r.RegisterUpcaster("customers.CustomerCreated", 2, ObjectUpcaster(func(fields map[string]any) error {
+		fields["tier"] = "standard"
+		return nil
+	})))
+	return r
+}
+
+func TestUpcast(t *testing.T) {
+	r := newCustomerRegistry(t)
+
+	t.Run("chains upcasters to the latest version", func(t *testing.T) {
+		payload, version, err := r.Upcast("customers.CustomerCreated", 1, []byte(`{"name":"Ada Lovelace"}`))
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, version)
+		assert.JSONEq(t, `{"first_name":"Ada","last_name":"Lovelace","tier":"standard"}`, string(payload))
+	})
+
+	t.Run("reads version 0 as version 1", func(t *testing.T) {
+		_, version, err := r.Upcast("customers.CustomerCreated", 0, []byte(`{"name":"Ada Lovelace"}`))
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, version)
+	})
+
+	t.Run("leaves current payload unchanged", func(t *testing.T) {
+		current := `{"first_name":"Ada","last_name":"Lovelace","tier":"gold"}`
+		payload, version, err := r.Upcast("customers.CustomerCreated", 3, []byte(current))
+
+		require.NoError(t, err)
+		assert.Equal(t, 3, version)
+		assert.JSONEq(t, current, string(payload))
+	})
+
+	t.Run("rejects payload invalid for its own version", func(t *testing.T) {
+		_, _, err := r.Upcast("customers.CustomerCreated", 2, []byte(`{"name":"Ada Lovelace"}`))
+
+		var invalid *ValidationError
+		assert.ErrorAs(t, err, &invalid)
+	})
+
+	t.Run("rejects unknown version", func(t *testing.T) {
+		_, _, err := r.Upcast("customers.CustomerCreated", 4, []byte(`{}`))
+
+		assert.True(t, errors.Is(err, ErrUnknownEvent))
+	})
+}
+
+func TestUpcastMissingUpcaster(t *testing.T) {
+	r := NewRegistry()
+	r.MustRegister("inventory", "inventory.updated", 1, []byte(`{"type":"object"}`))
+	r.MustRegister("inventory", "inventory.updated", 2, []byte(`{"type":"object"}`))
+
+	_, _, err := r.Upcast("inventory.updated", 1, []byte(`{}`))
+
+	assert.Error(t, err)
+}
+
+func TestRegisterUpcaster(t *testing.T) {
+	r := newCustomerRegistry(t)
+
+	assert.Error(t, r.RegisterUpcaster("customers.CustomerCreated", 1, nil), "duplicate upcaster")
+	assert.Error(t, r.RegisterUpcaster("customers.CustomerCreated", 3, nil), "no target version")
+	assert.Error(t, r.RegisterUpcaster("customers.CustomerDeleted", 1, nil), "unknown type")
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,58 +12,83 @@ import (
 	"syscall"
 	"time"
 
+	"app/internal/config"
 	"app/internal/customers"
+	"app/internal/inventory"
+	"app/internal/modulith"
+	"app/internal/ordering"
+	"app/internal/outbox"
+	"app/internal/schema"
 
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/nats-io/nats.go"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	temporalclient "go.temporal.io/sdk/client"
 )
 
-const (
-	mongoURI = "mongodb://localhost:27017"
-)
+// startupTimeout bounds module start hooks
+const startupTimeout = 30 * time.Second
 
 type App struct {
-	mux          *http.ServeMux
-	repository   *customers.MongoRepository
-	setupHandler *customers.SetupHandler
-	service      *customers.Service
-	forwarder    customers.EventForwarder
+	cfg         config.Config
+	mux         *http.ServeMux
+	mongoClient *mongo.Client
+	natsConn    *nats.Conn
+	temporal    temporalclient.Client
+	host        *modulith.Host
+	rebuilds    *rebuildJobs
 }
 
-func NewApp() (*App, error) {
+// NewApp opens the one Mongo client, NATS connection and Temporal client shared by every module
+func NewApp(cfg config.Config) (*App, error) {
 	// Initialize MongoDB client
 	ctx := context.Background()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
 	}
 
-	// Initialize repository
-	repository := customers.NewMongoRepository(client.Database("CustomersDB"))
-
-	// Initialize setup handler
-	setupHandler, err := customers.NewSetupHandler(mongoURI)
+	// Initialize NATS connection
+	natsConn, err := nats.Connect(cfg.NATS.URL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create setup handler: %v", err)
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
 	}
 
-	// Initialize forwarder
-	forwarder := customers.NewEventForwarder(mongoURI)
-	forwarder.Start()
-
-	// Initialize service
-	service := customers.NewService(repository, forwarder)
+	// Initialize Temporal client
+	temporalClient, err := temporalclient.Dial(temporalclient.Options{
+		HostPort:  cfg.Temporal.HostPort,
+		Namespace: cfg.Temporal.Namespace,
+	})
+	if err != nil {
+		natsConn.Close()
+		client.Disconnect(ctx)
+		return nil, fmt.Errorf("failed to connect to Temporal: %v", err)
+	}
 
 	// Initialize router
 	mux := http.NewServeMux()
 
 	app := &App{
-		mux:          mux,
-		repository:   repository,
-		setupHandler: setupHandler,
-		service:      service,
-		forwarder:    forwarder,
+		cfg:         cfg,
+		mux:         mux,
+		mongoClient: client,
+		natsConn:    natsConn,
+		temporal:    temporalClient,
+		host:        modulith.NewHost(mux, natsConn, modulith.NewNATSPublisher(natsConn)),
+		rebuilds:    newRebuildJobs(),
+	}
+
+	// Register modules in startup order
+	if err := app.registerModules(); err != nil {
+		app.close()
+		return nil, err
+	}
+	startCtx, cancel := context.WithTimeout(ctx, startupTimeout)
+	defer cancel()
+	if err := app.host.Start(startCtx); err != nil {
+		app.close()
+		return nil, err
 	}
 
 	// Setup routes
@@ -71,26 +97,107 @@ func NewApp() (*App, error) {
 	return app, nil
 }
 
+// registerModules plugs every bounded context into the host with its configuration
+func (a *App) registerModules() error {
+	dbs := a.cfg.Mongo.Databases
+	relay := a.cfg.Relay
+
+	forwarderConfig := customers.DefaultForwarderConfig()
+	forwarderConfig.Mode = outbox.Mode(relay.Mode)
+	forwarderConfig.PollInterval = relay.PollInterval
+	forwarderConfig.MaxRetries = relay.MaxRetries
+	forwarderConfig.BaseBackoff = relay.BaseBackoff
+	forwarderConfig.MaxBackoff = relay.MaxBackoff
+	forwarderConfig.LeaseDuration = relay.LeaseDuration
+	forwarderConfig.BatchSize = relay.BatchSize
+	forwarderConfig.Concurrency = relay.Concurrency
+
+	inventoryRelay := inventory.DefaultRelayConfig()
+	inventoryRelay.Mode = outbox.Mode(relay.Mode)
+	inventoryRelay.PollInterval = relay.PollInterval
+	inventoryRelay.LeaseDuration = relay.LeaseDuration
+	inventoryRelay.BatchSize = relay.BatchSize
+	inventoryRelay.Concurrency = relay.Concurrency
+
+	modules := []struct {
+		module modulith.Module
+		config map[string]any
+	}{
+		{customers.NewModule(), map[string]any{
+			"client":        a.mongoClient,
+			"db":            a.mongoClient.Database(dbs.Customers),
+			"projection_db": a.mongoClient.Database(dbs.Ordering),
+			"forwarder":     forwarderConfig,
+		}},
+		{inventory.NewModule(a.natsConn), map[string]any{
+			"db":    a.mongoClient.Database(dbs.Inventory),
+			"relay": inventoryRelay,
+		}},
+		{ordering.NewModule(), map[string]any{
+			"db":     a.mongoClient.Database(dbs.Ordering),
+			"orders": ordering.NewTemporalOrderClient(a.temporal, a.cfg.Temporal.TaskQueue),
+			"relay": ordering.RelayConfig{
+				PollInterval:  relay.PollInterval,
+				LeaseDuration: relay.LeaseDuration,
+				BatchSize:     relay.BatchSize,
+				Concurrency:   relay.Concurrency,
+			},
+		}},
+	}
+
+	for _, m := range modules {
+		if err := a.host.Register(m.module, m.config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupRoutes mounts the routes that span modules; each module mounts its own through the host
 func (a *App) setupRoutes() {
-	// Customer routes
-	a.mux.HandleFunc("POST /customers", a.createCustomer)
-	a.mux.HandleFunc("PUT /customers/{id}", a.updateCustomer)
-	a.mux.HandleFunc("GET /customers/{id}", a.getCustomer)
-	a.mux.HandleFunc("DELETE /customers/{id}", a.deleteCustomer)
+	// Admin routes
+	a.mux.HandleFunc("POST /admin/projections/{name}/rebuild", a.rebuildProjection)
+	a.mux.HandleFunc("GET /admin/projections/{name}/rebuild", a.rebuildProjectionStatus)
 
-	// Setup routes
-	a.mux.HandleFunc("POST /setup/testdata", a.setupTestData)
-	a.mux.HandleFunc("POST /setup/reset", a.resetData)
+	// Event routes
+	a.mux.HandleFunc("GET /events/catalog", a.eventCatalog)
+}
+
+// Shutdown tears the app down after ingress has stopped: projection rebuilds are cancelled,
+// NATS subscriptions are drained, modules stop once their relays finish the current batch,
+// and only then are the Temporal client, the NATS connection and the Mongo client closed
+func (a *App) Shutdown(ctx context.Context) error {
+	if err := a.rebuilds.stop(ctx); err != nil {
+		log.Printf("Error stopping projection rebuilds: %v", err)
+	}
+
+	err := a.host.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Error stopping modules: %v", err)
+	}
+
+	a.temporal.Close()
+	a.natsConn.Close()
+	if disconnectErr := a.mongoClient.Disconnect(ctx); disconnectErr != nil {
+		log.Printf("Error disconnecting from MongoDB: %v", disconnectErr)
+		err = errors.Join(err, disconnectErr)
+	}
+	return err
 }
 
-func (a *App) Cleanup() {
-	a.setupHandler.Close()
-	a.forwarder.Stop()
+// close releases an app that failed to start: modules started so far are stopped, then the
+// connections NewApp opened are closed
+func (a *App) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		log.Printf("Error releasing app after failed start: %v", err)
+	}
 }
 
-func (a *App) Run(addr string) error {
+func (a *App) Run() error {
 	server := &http.Server{
-		Addr:         addr,
+		Addr:         a.cfg.HTTP.Addr,
 		Handler:      a.mux,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
@@ -108,122 +215,52 @@ func (a *App) Run(addr string) error {
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	<-stop
 
-	// Shutdown server gracefully
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	// Stop ingress first, then drain the modules; one deadline covers the whole shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.HTTP.ShutdownTimeout)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
-		return fmt.Errorf("server shutdown failed: %v", err)
-	}
-
-	return nil
-}
-
-func (a *App) setupTestData(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	if err := a.setupHandler.SetupTestData(ctx); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to setup test data: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, "Test data setup completed successfully")
-}
-
-func (a *App) resetData(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	if err := a.setupHandler.SetupTestData(ctx); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to reset data: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, "Data reset completed successfully")
-}
-
-func (a *App) createCustomer(w http.ResponseWriter, r *http.Request) {
-	var customer customers.Customer
-	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
-		return
-	}
-
-	if err := a.service.CreateCustomer(r.Context(), &customer); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create customer: %v", err), http.StatusInternalServerError)
-		return
+	serverErr := server.Shutdown(ctx)
+	if serverErr != nil {
+		serverErr = fmt.Errorf("server shutdown failed: %v", serverErr)
 	}
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(customer)
+	return errors.Join(serverErr, a.Shutdown(ctx))
 }
 
-func (a *App) updateCustomer(w http.ResponseWriter, r *http.Request) {
-	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
-	if err != nil {
-		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
-		return
-	}
-
-	var customer customers.Customer
-	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
-		return
-	}
-
-	customer.ID = id
-	if err := a.service.UpdateCustomer(r.Context(), &customer); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update customer: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(customer)
+// eventCatalog lists every registered event type, its schema versions and its producing context
+func (a *App) eventCatalog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema.Default.Catalog())
 }
 
-func (a *App) getCustomer(w http.ResponseWriter, r *http.Request) {
-	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+func main() {
+	cfg, err := config.Load()
 	if err != nil {
-		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
-		return
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	customer, err := a.service.FindCustomerByID(r.Context(), id, false)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			http.Error(w, "Customer not found", http.StatusNotFound)
-			return
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rebuild-projection":
+			if err := runRebuildCommand(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("Rebuild failed: %v", err)
+			}
+		case "worker":
+			if err := runWorkerCommand(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("Worker failed: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
 		}
-		http.Error(w, fmt.Sprintf("Failed to get customer: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	json.NewEncoder(w).Encode(customer)
-}
-
-func (a *App) deleteCustomer(w http.ResponseWriter, r *http.Request) {
-	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
-	if err != nil {
-		http.Error(w, "Invalid customer ID", http.StatusBadRequest)
 		return
 	}
 
-	if err := a.service.SoftDeleteCustomer(r.Context(), id); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete customer: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusNoContent)
-}
-
-func main() {
-	app, err := NewApp()
+	app, err := NewApp(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize app: %v", err)
 	}
-	defer app.Cleanup()
 
-	log.Println("Starting server on :8080...")
-	if err := app.Run(":8080"); err != nil {
+	log.Printf("Starting server on %s...", cfg.HTTP.Addr)
+	if err := app.Run(); err != nil {
 		log.Fatalf("Server error: %v", err)
 	}
 }
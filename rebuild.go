@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"app/internal/config"
+	"app/internal/customers"
+	"app/internal/inventory"
+	"app/internal/outbox"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrUnknownProjection is returned when rebuilding a projection that does not exist
+var ErrUnknownProjection = errors.New("unknown projection")
+
+// rebuildProjection replays the outbox behind the named projection and swaps the result into place
+func rebuildProjection(ctx context.Context, client *mongo.Client, dbs config.Databases, name string, progress func(outbox.RebuildReport)) (outbox.RebuildReport, error) {
+	switch name {
+	case "customers":
+		return customers.RebuildProjection(ctx, client.Database(dbs.Customers), client.Database(dbs.Ordering), progress)
+	case "inventory":
+		return inventory.NewRepository(client.Database(dbs.Inventory)).RebuildProjection(ctx, progress)
+	default:
+		return outbox.RebuildReport{}, fmt.Errorf("%w %q", ErrUnknownProjection, name)
+	}
+}
+
+func logRebuildProgress(report outbox.RebuildReport) {
+	log.Printf("Rebuilding %s: %d/%d events replayed (%d applied, %d skipped, %d failed)",
+		report.Projection, report.Replayed, report.Total, report.Applied, report.Skipped, report.Failed)
+}
+
+// rebuildStatus is the progress of a rebuild started over HTTP
+type rebuildStatus struct {
+	Projection string               `json:"projection"`
+	Running    bool                 `json:"running"`
+	Report     outbox.RebuildReport `json:"report"`
+	Error      string               `json:"error,omitempty"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+}
+
+// rebuildJobs runs the rebuilds started over HTTP detached from their requests, keeping the
+// status of the latest rebuild of each projection
+type rebuildJobs struct {
+	mu       sync.Mutex
+	statuses map[string]*rebuildStatus
+	// ctx is cancelled by stop; running tracks the rebuilds until they return
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+func newRebuildJobs() *rebuildJobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rebuildJobs{statuses: make(map[string]*rebuildStatus), ctx: ctx, cancel: cancel}
+}
+
+// start runs rebuild in the background and returns once it has taken the projection's lock,
+// or with the error that kept it from starting, e.g. outbox.ErrRebuildRunning
+func (j *rebuildJobs) start(name string, rebuild func(ctx context.Context, progress func(outbox.RebuildReport)) (outbox.RebuildReport, error)) (rebuildStatus, error) {
+	j.mu.Lock()
+	previous := j.statuses[name]
+	if previous != nil && previous.Running {
+		j.mu.Unlock()
+		return rebuildStatus{}, fmt.Errorf("%w: %s", outbox.ErrRebuildRunning, name)
+	}
+	status := &rebuildStatus{Projection: name, Running: true, StartedAt: time.Now()}
+	j.statuses[name] = status
+	j.mu.Unlock()
+
+	started := make(chan error, 1)
+	var once sync.Once
+	signal := func(err error) { once.Do(func() { started <- err }) }
+
+	j.running.Add(1)
+	go func() {
+		defer j.running.Done()
+		report, err := rebuild(j.ctx, func(report outbox.RebuildReport) {
+			j.update(status, report)
+			logRebuildProgress(report)
+			signal(nil)
+		})
+		j.finish(status, report, err)
+		signal(err)
+	}()
+
+	if err := <-started; err != nil {
+		// The rebuild never started, so the previous one stays the latest
+		j.mu.Lock()
+		if previous != nil {
+			j.statuses[name] = previous
+		} else {
+			delete(j.statuses, name)
+		}
+		j.mu.Unlock()
+		return rebuildStatus{}, err
+	}
+	return j.status(name)
+}
+
+func (j *rebuildJobs) update(status *rebuildStatus, report outbox.RebuildReport) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status.Report = report
+}
+
+func (j *rebuildJobs) finish(status *rebuildStatus, report outbox.RebuildReport, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	finishedAt := time.Now()
+	status.Running = false
+	status.Report = report
+	status.FinishedAt = &finishedAt
+	if err != nil {
+		status.Error = err.Error()
+		log.Printf("Rebuilding %s failed: %v", status.Projection, err)
+	}
+}
+
+// status returns a copy of the latest rebuild of the named projection
+func (j *rebuildJobs) status(name string) (rebuildStatus, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status, ok := j.statuses[name]
+	if !ok {
+		return rebuildStatus{}, fmt.Errorf("no rebuild of projection %q", name)
+	}
+	return *status, nil
+}
+
+// stop cancels the running rebuilds, leaving their projections as they were, and waits for
+// them to return until ctx is done
+func (j *rebuildJobs) stop(ctx context.Context) error {
+	j.cancel()
+	done := make(chan struct{})
+	go func() {
+		j.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rebuildProjection handles POST /admin/projections/{name}/rebuild, starting a rebuild that
+// outlives the request; its progress is reported by GET on the status URL
+func (a *App) rebuildProjection(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	status, err := a.rebuilds.start(name, func(ctx context.Context, progress func(outbox.RebuildReport)) (outbox.RebuildReport, error) {
+		return rebuildProjection(ctx, a.mongoClient, a.cfg.Mongo.Databases, name, progress)
+	})
+	if errors.Is(err, ErrUnknownProjection) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, outbox.ErrRebuildRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to rebuild projection: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/admin/projections/"+name+"/rebuild")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(status)
+}
+
+// rebuildProjectionStatus handles GET /admin/projections/{name}/rebuild
+func (a *App) rebuildProjectionStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := a.rebuilds.status(r.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// runRebuildCommand implements the "rebuild-projection <customers|inventory>" subcommand
+func runRebuildCommand(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: rebuild-projection <customers|inventory>")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	report, err := rebuildProjection(ctx, client, cfg.Mongo.Databases, args[0], logRebuildProgress)
+	if err != nil {
+		return err
+	}
+	logRebuildProgress(report)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"app/internal/outbox"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildJobs(t *testing.T) {
+	jobs := newRebuildJobs()
+	release := make(chan struct{})
+	rebuild := func(ctx context.Context, progress func(outbox.RebuildReport)) (outbox.RebuildReport, error) {
+		report := outbox.RebuildReport{Projection: "projection_customers", Total: 2}
+		progress(report)
+		<-release
+		report.Replayed, report.Applied, report.Done = 2, 2, true
+		return report, nil
+	}
+
+	// The rebuild outlives start, reporting its progress
+	status, err := jobs.start("customers", rebuild)
+	require.NoError(t, err)
+	assert.True(t, status.Running)
+	assert.Equal(t, int64(2), status.Report.Total)
+
+	_, err = jobs.start("customers", rebuild)
+	assert.ErrorIs(t, err, outbox.ErrRebuildRunning)
+
+	close(release)
+	require.NoError(t, jobs.stop(context.Background()))
+	status, err = jobs.status("customers")
+	require.NoError(t, err)
+	assert.False(t, status.Running)
+	assert.True(t, status.Report.Done)
+	assert.NotNil(t, status.FinishedAt)
+	assert.Empty(t, status.Error)
+}
+
+func TestRebuildJobsNotStarted(t *testing.T) {
+	jobs := newRebuildJobs()
+	locked := func(ctx context.Context, progress func(outbox.RebuildReport)) (outbox.RebuildReport, error) {
+		return outbox.RebuildReport{}, outbox.ErrRebuildRunning
+	}
+
+	// A rebuild that never took the lock leaves no status behind
+	_, err := jobs.start("inventory", locked)
+	assert.ErrorIs(t, err, outbox.ErrRebuildRunning)
+	_, err = jobs.status("inventory")
+	assert.Error(t, err)
+
+	// A rebuild that fails once started records its error
+	failing := func(ctx context.Context, progress func(outbox.RebuildReport)) (outbox.RebuildReport, error) {
+		report := outbox.RebuildReport{Projection: "inventory_projections", Failed: 1}
+		progress(report)
+		return report, errors.New("1 event failed")
+	}
+	_, err = jobs.start("inventory", failing)
+	require.NoError(t, err)
+	require.NoError(t, jobs.stop(context.Background()))
+	status, err := jobs.status("inventory")
+	require.NoError(t, err)
+	assert.Equal(t, "1 event failed", status.Error)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"app/internal/config"
+	"app/internal/inventory"
+	"app/internal/ordering"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+)
+
+// runWorkerCommand implements the "worker" subcommand: it runs the ordering workflow worker
+// until SIGINT or SIGTERM, then stops polling and waits for running activities. Orders are
+// stored in the ordering database.
+func runWorkerCommand(cfg config.Config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: worker")
+	}
+
+	ctx := context.Background()
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Mongo.URI))
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %v", err)
+	}
+	defer mongoClient.Disconnect(ctx)
+	orderingDB := mongoClient.Database(cfg.Mongo.Databases.Ordering)
+	inventoryRepository := inventory.NewRepository(mongoClient.Database(cfg.Mongo.Databases.Inventory))
+	activities := ordering.NewActivities(
+		ordering.NewMongoOrderRepository(orderingDB),
+		ordering.NewMongoCustomerRepository(orderingDB),
+		orderingInventory{inventoryRepository},
+		inventoryRepository,
+	)
+
+	c, err := client.Dial(client.Options{
+		HostPort:  cfg.Temporal.HostPort,
+		Namespace: cfg.Temporal.Namespace,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to Temporal: %v", err)
+	}
+	defer c.Close()
+
+	w := ordering.NewWorker(c, ordering.WorkerConfig{
+		TaskQueue:                  cfg.Temporal.TaskQueue,
+		MaxConcurrentActivities:    cfg.Temporal.MaxConcurrentActivities,
+		MaxConcurrentWorkflowTasks: cfg.Temporal.MaxConcurrentWorkflowTasks,
+		StopTimeout:                cfg.Temporal.WorkerStopTimeout,
+	}, activities)
+
+	log.Printf("Starting ordering worker on task queue %s...", cfg.Temporal.TaskQueue)
+	if err := w.Run(worker.InterruptCh()); err != nil {
+		return fmt.Errorf("worker failed: %v", err)
+	}
+	log.Println("Ordering worker stopped")
+	return nil
+}
+
+// orderingInventory lets the ordering activities reserve stock in the inventory module and return
+// it, translating its errors into the ones ordering.Inventory documents
+type orderingInventory struct {
+	repository *inventory.Repository
+}
+
+var _ ordering.Inventory = orderingInventory{}
+
+func (i orderingInventory) Reserve(ctx context.Context, reservationID string, quantities map[string]int, expiresAt time.Time) error {
+	return orderingInventoryError(i.repository.Reserve(ctx, reservationID, quantities, expiresAt))
+}
+
+func (i orderingInventory) Release(ctx context.Context, reservationID string) error {
+	return orderingInventoryError(i.repository.Release(ctx, reservationID))
+}
+
+func (i orderingInventory) Commit(ctx context.Context, reservationID string) error {
+	return orderingInventoryError(i.repository.Commit(ctx, reservationID))
+}
+
+func (i orderingInventory) Restock(ctx context.Context, restockID string, quantities map[string]int) error {
+	return i.repository.Restock(ctx, restockID, quantities)
+}
+
+// inventoryError keeps the message of an inventory error while matching its ordering counterpart
+type inventoryError struct {
+	err, target error
+}
+
+func (e inventoryError) Error() string   { return e.err.Error() }
+func (e inventoryError) Unwrap() []error { return []error{e.err, e.target} }
+
+func orderingInventoryError(err error) error {
+	switch {
+	case errors.Is(err, inventory.ErrInsufficientStock):
+		return inventoryError{err, ordering.ErrInsufficientStock}
+	case errors.Is(err, inventory.ErrReservationExpired):
+		return inventoryError{err, ordering.ErrReservationExpired}
+	}
+	return err
+}